@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+)
+
+// adminFlags are shared by the export and import subcommands, which either talk to a running
+// receiver or open its database directly while it is stopped
+type adminFlags struct {
+	url   string
+	token string
+	store storeOptions
+	file  string
+}
+
+func (a *adminFlags) register(flagset *flag.FlagSet, fileUsage string) {
+	flagset.StringVar(&a.url, "url", "", "The base URL of a running receiver")
+	flagset.StringVar(&a.token, "token", "", "The admin token of the running receiver at -url")
+	a.store.registerOffline(flagset, "'badger', 'bolt', 'redis', 'sqlite'")
+	flagset.StringVar(&a.file, "file", "", fileUsage)
+}
+
+func (a *adminFlags) validate(out io.Writer) bool {
+	if (a.url == "") == (a.store.path == "") {
+		fmt.Fprintln(out, "exactly one of -url or -db.path is required")
+		return false
+	}
+	return true
+}
+
+// request sends a request to the admin endpoint at path of the running receiver
+func (a *adminFlags) request(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(a.url, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", store.ExportContentType)
+	}
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// runExport implements the export subcommand, writing the contents of a store in the versioned export format
+func runExport(args []string, out io.Writer) int {
+	var a adminFlags
+	flagset := flag.NewFlagSet("export", flag.ContinueOnError)
+	flagset.SetOutput(out)
+	a.register(flagset, "The file the export is written to. Empty (default) writes to stdout")
+	if err := flagset.Parse(args); err != nil {
+		return 2
+	}
+	if !a.validate(out) {
+		return 2
+	}
+
+	w := out
+	if a.file != "" {
+		f, err := os.Create(a.file)
+		if err != nil {
+			fmt.Fprintf(out, "failed to create export: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := exportStore(a, w); err != nil {
+		fmt.Fprintf(out, "failed to export store: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func exportStore(a adminFlags, w io.Writer) error {
+	if a.store.path != "" {
+		s, err := openStore(a.store, log.NewNopLogger(), nil)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		return store.Export(context.Background(), s, w)
+	}
+
+	resp, err := a.request(http.MethodGet, "/admin/export", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// runImport implements the import subcommand, loading an export into a store
+func runImport(args []string, out io.Writer) int {
+	var a adminFlags
+	flagset := flag.NewFlagSet("import", flag.ContinueOnError)
+	flagset.SetOutput(out)
+	a.register(flagset, "The export to import")
+	if err := flagset.Parse(args); err != nil {
+		return 2
+	}
+	if !a.validate(out) {
+		return 2
+	}
+	if a.file == "" {
+		fmt.Fprintln(out, "-file is required")
+		return 2
+	}
+
+	f, err := os.Open(a.file)
+	if err != nil {
+		fmt.Fprintf(out, "failed to open export: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	n, err := importStore(a, f)
+	if err != nil {
+		fmt.Fprintf(out, "failed to import store: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "%d entries imported\n", n)
+	return 0
+}
+
+func importStore(a adminFlags, r io.Reader) (int, error) {
+	if a.store.path != "" {
+		s, err := openStore(a.store, log.NewNopLogger(), nil)
+		if err != nil {
+			return 0, err
+		}
+		n, err := store.Import(context.Background(), s, r)
+		if cerr := s.Close(); err == nil && cerr != nil {
+			return n, cerr
+		}
+		return n, err
+	}
+
+	resp, err := a.request(http.MethodPost, "/admin/import", r)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, responseError(resp)
+	}
+	var into struct {
+		Imported int `json:"imported"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&into); err != nil {
+		return 0, err
+	}
+	return into.Imported, nil
+}
+
+// backupSinceHeader is the trailer holding the version to request the next incremental backup with
+const backupSinceHeader = "X-Backup-Since"
+
+// runBackup implements the backup subcommand, writing a consistent incremental backup of a Badger store
+func runBackup(args []string, out io.Writer) int {
+	var a adminFlags
+	var since uint64
+	flagset := flag.NewFlagSet("backup", flag.ContinueOnError)
+	flagset.SetOutput(out)
+	a.register(flagset, "The file the backup is written to")
+	flagset.Uint64Var(&since, "since", 0, "Only back up entries written after this version. Zero (default) takes a full backup")
+	if err := flagset.Parse(args); err != nil {
+		return 2
+	}
+	if !a.validate(out) {
+		return 2
+	}
+	if a.file == "" {
+		fmt.Fprintln(out, "-file is required")
+		return 2
+	}
+
+	f, err := os.Create(a.file)
+	if err != nil {
+		fmt.Fprintf(out, "failed to create backup: %v\n", err)
+		return 1
+	}
+	next, err := backupStore(a, f, since)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(a.file)
+		fmt.Fprintf(out, "failed to back up store: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "backup written to %s, use -since=%d for the next incremental backup\n", a.file, next)
+	return 0
+}
+
+func backupStore(a adminFlags, w io.Writer, since uint64) (uint64, error) {
+	if a.store.path != "" {
+		s, err := openStore(a.store, log.NewNopLogger(), nil)
+		if err != nil {
+			return 0, err
+		}
+		defer s.Close()
+		b, ok := s.(store.Backuper)
+		if !ok {
+			return 0, fmt.Errorf("backups are not supported by the %s backend", a.store.backend)
+		}
+		return b.Backup(w, since)
+	}
+
+	resp, err := a.request(http.MethodGet, fmt.Sprintf("/admin/backup?since=%d", since), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, responseError(resp)
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return 0, err
+	}
+	next := resp.Trailer.Get(backupSinceHeader)
+	if next == "" {
+		return 0, fmt.Errorf("backup did not complete")
+	}
+	return strconv.ParseUint(next, 10, 64)
+}
+
+func responseError(resp *http.Response) error {
+	b, _ := io.ReadAll(resp.Body)
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+)
+
+func newAdminTestServer(t *testing.T, s store.Store) *httptest.Server {
+	t.Helper()
+	srv := &server{
+		router:      mux.NewRouter(),
+		logger:      log.NewNopLogger(),
+		store:       s,
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+	}
+	srv.routes()
+	ts := httptest.NewServer(srv.router)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestExportImport(t *testing.T) {
+	from := store.NewInMemStore()
+	source := newAdminTestServer(t, from)
+	resp, err := http.Post(source.URL+"/webhook", "application/json", getSamplePayload(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	file := filepath.Join(t.TempDir(), "export.ndjson")
+	var out bytes.Buffer
+	if code := runExport([]string{"-url", source.URL, "-file", file}, &out); code != 0 {
+		t.Fatalf("expected export to succeed but got exit code %d\n%s", code, out.String())
+	}
+
+	dbPath := t.TempDir()
+	if code := runImport([]string{"-db.path", dbPath, "-file", file}, &out); code != 0 {
+		t.Fatalf("expected import to succeed but got exit code %d\n%s", code, out.String())
+	}
+
+	to := store.NewInMemStore()
+	target := newAdminTestServer(t, to)
+	out.Reset()
+	if code := runExport([]string{"-db.path", dbPath}, &out); code != 0 {
+		t.Fatalf("expected export to succeed but got exit code %d\n%s", code, out.String())
+	}
+	resp, err = http.Post(target.URL+"/admin/import", store.ExportContentType, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 response but got %d", resp.StatusCode)
+	}
+
+	want, _ := from.List(context.Background())
+	got, _ := to.List(context.Background())
+	if len(got) != 1 || !reflect.DeepEqual(want, got) {
+		t.Fatalf("wanted %v got %v", want, got)
+	}
+}
+
+func TestExportAdminToken(t *testing.T) {
+	srv := &server{
+		router:      mux.NewRouter(),
+		logger:      log.NewNopLogger(),
+		store:       store.NewInMemStore(),
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+		adminToken:  "admin",
+	}
+	srv.routes()
+	ts := httptest.NewServer(srv.router)
+	defer ts.Close()
+
+	var out bytes.Buffer
+	if code := runExport([]string{"-url", ts.URL}, &out); code != 1 || !strings.Contains(out.String(), "401") {
+		t.Fatalf("expected export without the admin token to fail but got exit code %d\n%s", code, out.String())
+	}
+	out.Reset()
+	if code := runExport([]string{"-url", ts.URL, "-token", "admin"}, &out); code != 0 {
+		t.Fatalf("expected export to succeed but got exit code %d\n%s", code, out.String())
+	}
+}
+
+func TestExportEncrypted(t *testing.T) {
+	srv := &server{
+		router:      mux.NewRouter(),
+		logger:      log.NewNopLogger(),
+		store:       store.NewInMemStore(),
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+		encrypted:   true,
+	}
+	srv.routes()
+	ts := httptest.NewServer(srv.router)
+	defer ts.Close()
+
+	for _, path := range []string{"/admin/export", "/admin/backup"} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("%s: expected 403 response but got %d", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	ts := newAdminTestServer(t, store.NewInMemStore())
+	resp, err := http.Post(ts.URL+"/admin/import", store.ExportContentType, strings.NewReader(`{"version":99}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 response but got %d", resp.StatusCode)
+	}
+
+	var out bytes.Buffer
+	if code := runImport([]string{"-url", ts.URL, "-db.path", t.TempDir(), "-file", "export.ndjson"}, &out); code != 2 {
+		t.Fatalf("expected usage error but got exit code %d", code)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	from, err := store.NewKeyValueStore("", log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newAdminTestServer(t, from)
+	post := func(payload io.Reader) {
+		resp, err := http.Post(ts.URL+"/webhook", "application/json", payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	dir := t.TempDir()
+	full, incremental := filepath.Join(dir, "full.bak"), filepath.Join(dir, "incremental.bak")
+	post(getSamplePayload(t))
+	var out bytes.Buffer
+	if code := runBackup([]string{"-url", ts.URL, "-file", full}, &out); code != 0 {
+		t.Fatalf("expected backup to succeed but got exit code %d\n%s", code, out.String())
+	}
+	// the first version is taken by the schema version written when the store is created
+	if !strings.Contains(out.String(), "-since=2 ") {
+		t.Fatalf("expected next version to be reported but got %s", out.String())
+	}
+
+	post(strings.NewReader(`{"receiver":"other","groupLabels":{"alertname":"Other"},"alerts":[{"status":"firing"}]}`))
+	if code := runBackup([]string{"-url", ts.URL, "-file", incremental, "-since", "2"}, &out); code != 0 {
+		t.Fatalf("expected backup to succeed but got exit code %d\n%s", code, out.String())
+	}
+
+	to, err := store.NewKeyValueStore("", log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{full, incremental} {
+		if err := restore(to, file, log.NewNopLogger()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, _ := to.List(context.Background())
+	if len(got) != 1 || got[0].ID != "Test_webhook" {
+		t.Fatalf("expected restore to be skipped once the store holds entries but got %v", got)
+	}
+
+	if _, err := store.RestorePath(to, incremental); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := from.List(context.Background())
+	got, _ = to.List(context.Background())
+	if len(got) != 2 || !reflect.DeepEqual(want, got) {
+		t.Fatalf("wanted %v got %v", want, got)
+	}
+}
+
+func TestBackupUnsupported(t *testing.T) {
+	ts := newAdminTestServer(t, store.NewInMemStore())
+	resp, err := http.Get(ts.URL + "/admin/backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("expected 501 response but got %d", resp.StatusCode)
+	}
+}
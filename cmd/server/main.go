@@ -3,30 +3,76 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"text/template"
 	"time"
 
 	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/dedup"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/format"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/forward"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/labels"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/lifecycle"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/recorder"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/sigv4"
 	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/tenant"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/timing"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/transform"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 
 	"github.com/gorilla/mux"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
 	listenAddress string
 	logLevel      string
 	storeIDTmpl   string
-	dbPath        string
+	storeOpts     storeOptions
+	webhookFormat string
+	strict        bool
+	strictSkew    time.Duration
+	tokens        integrationTokens
+	snsCreds      sigv4.Credentials
+	apiV2Enabled  bool
+	apiV2Receiver string
+	adminToken    string
+
+	timerExpectations timing.Expectations
+	timingRetention   time.Duration
+	alertRetention    time.Duration
+	dedupWindow       time.Duration
+	recordFile        string
+	forwardConfig     string
+	forwardWorkers    int
+	forwardQueueSize  int
+	transformConfig   string
+	backupDir         string
+	backupInterval    time.Duration
+	restorePath       string
+	shutdownTimeout   time.Duration
+	memoryEviction    string
+	tenantsConfig     string
+	storeTimeout      time.Duration
 )
 
 const (
@@ -34,48 +80,234 @@ const (
 	defaultLogLevel        = "info"
 	defaultStoreIDTemplate = `{{ .GroupLabels.alertname }}_{{ .Receiver }}`
 	defaultDbPath          = ""
+	defaultStoreBackend    = ""
+	defaultAPIV2Receiver   = "api-v2"
+	maxRejections          = 1000
+	defaultTimingTolerance = 5 * time.Second
+	defaultTimingRetention = 24 * time.Hour
+	defaultAlertRetention  = 24 * time.Hour
+	defaultDedupWindow     = 30 * time.Second
+	defaultForwardWorkers  = 4
+	defaultForwardQueue    = 1000
+	defaultBackupInterval  = time.Hour
+	defaultShutdownTimeout = 20 * time.Second
+	tenantExpiryInterval   = time.Minute
+	ndjsonContentType      = "application/x-ndjson"
 )
 
+// subcommands are run instead of the server when named by the first argument
+var subcommands = map[string]func(args []string, out io.Writer) int{
+	"backup":     runBackup,
+	"export":     runExport,
+	"import":     runImport,
+	"migrate":    runMigrate,
+	"rotate-key": runRotateKey,
+	"replay":     runReplay,
+	"verify":     runVerify,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:], os.Stdout))
+		}
+	}
+
 	flagset := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	flagset.StringVar(&listenAddress, "listen.address", defaultListenAddress, "The network address to listen on")
 	flagset.StringVar(&logLevel, "log.level", defaultLogLevel, "One of 'debug', 'info', 'warn', 'error'")
 	flagset.StringVar(&storeIDTmpl, "id.template", defaultStoreIDTemplate, "The template used to generate the ID for storage")
-	flagset.StringVar(&dbPath, "db.path", defaultDbPath, "The file path to the history store. Empty (default) uses in-memory store")
+	flagset.StringVar(&storeOpts.path, "db.path", defaultDbPath, "The file path to the history store, or the server URL for the redis backend. Empty (default) uses in-memory store")
+	flagset.StringVar(&storeOpts.backend, "store.backend", defaultStoreBackend, "The history store backend. One of 'badger', 'bolt', 'memory', 'redis', 'sqlite'. Empty (default) uses 'memory' when -db.path is empty and 'badger' otherwise")
+	flagset.IntVar(&storeOpts.memory.MaxEntries, "memory.max-entries", 0, "The number of entries kept by the memory store backend before the oldest are evicted. Zero (default) is unbounded")
+	flagset.Int64Var(&storeOpts.memory.MaxBytes, "memory.max-bytes", 0, "The approximate size in bytes of the alerts kept by the memory store backend before the oldest are evicted. Zero (default) is unbounded")
+	flagset.StringVar(&memoryEviction, "memory.eviction", string(store.EvictLRU), "The entry the memory store backend evicts when over its limits. One of 'lru', 'fifo'")
+	flagset.StringVar(&storeOpts.redisPrefix, "redis.prefix", store.DefaultRedisPrefix, "The prefix of every key written by the redis store backend")
+	flagset.DurationVar(&storeOpts.redisTTL, "redis.ttl", 0, "How long the redis store backend keeps the history of an ID after its last notification. Zero (default) keeps it forever")
+	flagset.StringVar(&storeOpts.encryptionKeyFile, "encryption.key-file", "", "A file holding the 16, 24 or 32 byte key the history store is encrypted at rest with. Empty (default) stores history in plaintext. Not supported by the sqlite backend")
+	flagset.DurationVar(&storeOpts.encryptionRotation, "encryption.rotation", 0, "How often the badger store backend generates a new data key for new writes. Zero (default) uses 10 days")
+	flagset.StringVar(&webhookFormat, "webhook.format", format.AlertmanagerName, "The inbound format served on /webhook")
+	flagset.BoolVar(&strict, "webhook.strict", false, "Reject Alertmanager webhook payloads that do not strictly match the webhook schema")
+	flagset.DurationVar(&strictSkew, "webhook.strict-skew", format.DefaultMaxSkew, "The clock difference between Alertmanager and the receiver allowed when -webhook.strict checks the end time of each alert against its status")
+	flagset.StringVar(&tokens.telegram, "telegram.token", "", "The bot token accepted by the Telegram stand-in. Empty (default) accepts any token")
+	flagset.StringVar(&tokens.pushover, "pushover.token", "", "The application token accepted by the Pushover stand-in. Empty (default) accepts any token")
+	flagset.StringVar(&tokens.webex, "webex.token", "", "The access token accepted by the Webex stand-in. Empty (default) accepts any token")
+	flagset.StringVar(&snsCreds.AccessKeyID, "sns.access-key-id", "", "The access key ID used to verify SigV4 signed SNS requests. Empty (default) disables verification")
+	flagset.StringVar(&snsCreds.SecretAccessKey, "sns.secret-access-key", "", "The secret access key used to verify SigV4 signed SNS requests")
+	flagset.BoolVar(&apiV2Enabled, "api.v2.enabled", false, "Expose the Alertmanager API v2 /api/v2/alerts endpoint so that Prometheus and Thanos Ruler can push alerts directly")
+	flagset.StringVar(&apiV2Receiver, "api.v2.receiver", defaultAPIV2Receiver, "The receiver name given to alerts pushed to /api/v2/alerts when generating the ID for storage")
+	flagset.DurationVar(&timerExpectations.GroupWait, "timing.group-wait", 0, "The expected group_wait asserted at /timing/assert. Zero (default) disables the assertion")
+	flagset.DurationVar(&timerExpectations.GroupInterval, "timing.group-interval", 0, "The expected group_interval asserted at /timing/assert. Zero (default) disables the assertion")
+	flagset.DurationVar(&timerExpectations.RepeatInterval, "timing.repeat-interval", 0, "The expected repeat_interval asserted at /timing/assert. Zero (default) disables the assertion")
+	flagset.DurationVar(&timerExpectations.Tolerance, "timing.tolerance", defaultTimingTolerance, "The allowed difference between observed and expected route timers")
+	flagset.DurationVar(&timingRetention, "timing.retention", defaultTimingRetention, "How long the timing of a group is kept after its last notification. Zero keeps it until the receiver restarts")
+	flagset.DurationVar(&alertRetention, "lifecycle.retention", defaultAlertRetention, "How long the timeline of an alert is kept after its last notification. Zero keeps it until the receiver restarts")
+	flagset.DurationVar(&dedupWindow, "dedup.window", defaultDedupWindow, "Notifications with the same group key and alerts received again within this window are counted as duplicates")
+
+	flagset.StringVar(&recordFile, "record.file", "", "Append every inbound webhook request to this NDJSON file for later replay. Empty (default) disables recording")
+
+	flagset.StringVar(&transformConfig, "transform.config", "", "A YAML file of transformations applied to each notification before it is stored and forwarded. Empty (default) disables transformation")
+	flagset.StringVar(&forwardConfig, "forward.config", "", "A YAML file of downstream webhooks each accepted notification is forwarded to. Empty (default) disables forwarding")
+	flagset.IntVar(&forwardWorkers, "forward.workers", defaultForwardWorkers, "The number of notifications forwarded concurrently")
+	flagset.IntVar(&forwardQueueSize, "forward.queue-size", defaultForwardQueue, "The number of notifications waiting for a forwarding worker before further notifications are not forwarded")
+	flagset.StringVar(&backupDir, "backup.dir", "", "The directory incremental backups of the history store are written to. Empty (default) disables scheduled backups")
+	flagset.DurationVar(&backupInterval, "backup.interval", defaultBackupInterval, "How often a backup is written to -backup.dir")
+	flagset.StringVar(&restorePath, "restore.path", "", "A backup file or directory of backups loaded at startup if the history store is empty")
+	flagset.DurationVar(&storeTimeout, "store.timeout", 0, "How long a request may wait on the history store before failing with 503 Service Unavailable. Zero (default) waits until the client goes away")
+	flagset.StringVar(&adminToken, "admin.token", "", "The bearer token required by the /admin endpoints. Empty (default) serves them without auth unless a tenant requires a token, in which case they are refused")
+	flagset.StringVar(&tenantsConfig, "tenants.config", "", "A YAML file of tenant auth tokens and retention. Empty (default) serves every tenant without auth or retention")
+	flagset.DurationVar(&shutdownTimeout, "shutdown.timeout", defaultShutdownTimeout, "How long to wait for in-flight requests to complete on shutdown before closing the store")
 
 	flagset.Parse(os.Args[1:])
+	storeOpts.memory.Eviction = store.EvictionPolicy(memoryEviction)
 
 	logger := setupLogger(logLevel)
-	store, err := store.NewKeyValueStore(dbPath, logger)
+	metrics := prometheus.NewRegistry()
+	metrics.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	var err error
+	var forwards *forward.Queue
+	if forwardConfig != "" {
+		cfg, err := forward.LoadFile(forwardConfig)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to load forwarding config", "err", err)
+			os.Exit(1)
+		}
+		if forwardWorkers < 1 || forwardQueueSize < 0 {
+			level.Error(logger).Log("msg", "-forward.workers must be at least 1 and -forward.queue-size must not be negative")
+			os.Exit(1)
+		}
+		forwards = forward.NewQueue(forward.NewForwarder(cfg), forwardWorkers, forwardQueueSize)
+	}
+
+	var transforms *transform.Pipeline
+	if transformConfig != "" {
+		if transforms, err = transform.LoadFile(transformConfig); err != nil {
+			level.Error(logger).Log("msg", "failed to load transformation config", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	var rec *recorder.Recorder
+	if recordFile != "" {
+		// requests are recorded as received, before annotations are redacted
+		if transforms != nil && transforms.Redacts() {
+			level.Error(logger).Log("msg", "-record.file cannot be combined with a redact_annotations transformation as recordings hold the raw request")
+			os.Exit(1)
+		}
+		if rec, err = recorder.Open(recordFile); err != nil {
+			level.Error(logger).Log("msg", "failed to open recording", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	var tenantsCfg *tenant.Config
+	if tenantsConfig != "" {
+		if tenantsCfg, err = tenant.LoadFile(tenantsConfig); err != nil {
+			level.Error(logger).Log("msg", "failed to load tenants config", "err", err)
+			os.Exit(1)
+		}
+	}
+	tenants := tenant.NewRegistry(tenantsCfg)
+
+	historyStore, err := openStore(storeOpts, logger, metrics)
 	if err != nil {
 		level.Error(logger).Log("msg", "failed to initialise database", "err", err)
 		os.Exit(1)
 	}
+	if restorePath != "" {
+		if err := restore(historyStore, restorePath, logger); err != nil {
+			level.Error(logger).Log("msg", "failed to restore database", "err", err)
+			historyStore.Close()
+			os.Exit(1)
+		}
+	}
+	// backups hold values as they were written, so they are migrated once restored
+	if err := migrateStore(context.Background(), historyStore, logger); err != nil {
+		level.Error(logger).Log("msg", "failed to migrate database", "err", err)
+		historyStore.Close()
+		os.Exit(1)
+	}
+
+	var backups *store.BackupDir
+	backupCtx, stopBackups := context.WithCancel(context.Background())
+	defer stopBackups()
+	if backupDir != "" {
+		if storeOpts.encryptionKeyFile != "" {
+			level.Error(logger).Log("msg", "backups are written in plaintext and cannot be used with -encryption.key-file")
+			historyStore.Close()
+			os.Exit(1)
+		}
+		b, ok := historyStore.(store.Backuper)
+		if !ok {
+			level.Error(logger).Log("msg", "backups are not supported by the store backend", "backend", storeOpts.backend)
+			historyStore.Close()
+			os.Exit(1)
+		}
+		if backups, err = store.NewBackupDir(backupDir, b, logger); err != nil {
+			level.Error(logger).Log("msg", "failed to initialise backups", "err", err)
+			historyStore.Close()
+			os.Exit(1)
+		}
+		go backups.Run(backupCtx, backupInterval)
+	}
+
+	expiryCtx, stopExpiry := context.WithCancel(context.Background())
+	defer stopExpiry()
+	if tenants.Retains() {
+		go tenants.Run(expiryCtx, historyStore, tenantExpiryInterval, logger)
+	}
 
 	srv := &server{
-		logger:      logger,
-		store:       store,
-		router:      mux.NewRouter(),
-		idGenerator: buildIdGenerator(storeIDTmpl),
+		logger:        logger,
+		store:         historyStore,
+		router:        mux.NewRouter(),
+		idGenerator:   buildIdGenerator(storeIDTmpl),
+		webhookFormat: webhookFormat,
+		strict:        strict,
+		strictSkew:    strictSkew,
+		rejections:    format.NewRejectionLog(maxRejections),
+		lifecycle:     lifecycle.NewTracker(alertRetention),
+		timing:        timing.NewAnalyzer(timerExpectations, timingRetention),
+		dedup:         dedup.NewDetector(dedupWindow, metrics),
+		metrics:       metrics,
+		recorder:      rec,
+		transforms:    transforms,
+		forwards:      forwards,
+		backups:       backups,
+		tenants:       tenants,
+		storeTimeout:  storeTimeout,
+		tokens:        tokens,
+		snsCreds:      snsCreds,
+		apiV2Enabled:  apiV2Enabled,
+		apiV2Receiver: apiV2Receiver,
+		adminToken:    adminToken,
+		encrypted:     storeOpts.encryptionKeyFile != "",
 	}
 
 	go func() {
 		if err := srv.run(listenAddress); err != nil {
 			if !errors.Is(err, http.ErrServerClosed) {
 				level.Error(logger).Log("msg", "server run returned an error", "err", err)
+				historyStore.Close()
 				os.Exit(1)
 			}
 		}
 	}()
 
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
-	level.Info(logger).Log("msg", "interrupt received. shutting down gracefully")
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	sig := <-c
+	level.Info(logger).Log("msg", "signal received. shutting down gracefully", "signal", sig)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
+	stopBackups()
+	stopExpiry()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
-	srv.close(ctx)
+	if err := srv.close(ctx); err != nil {
+		level.Error(logger).Log("msg", "failed to shut down cleanly", "err", err)
+		os.Exit(1)
+	}
 
 	level.Info(logger).Log("msg", "exiting...")
 	os.Exit(0)
@@ -87,74 +319,611 @@ type server struct {
 	router *mux.Router
 	srv    *http.Server
 	idGenerator
+	webhookFormat string
+	strict        bool
+	// strictSkew is the clock difference allowed by strict validation
+	strictSkew    time.Duration
+	rejections    *format.RejectionLog
+	lifecycle     *lifecycle.Tracker
+	timing        *timing.Analyzer
+	dedup         *dedup.Detector
+	metrics       *prometheus.Registry
+	recorder      *recorder.Recorder
+	transforms    *transform.Pipeline
+	forwards      *forward.Queue
+	backups       *store.BackupDir
+	tenants       *tenant.Registry
+	storeTimeout  time.Duration
+	tokens        integrationTokens
+	snsCreds      sigv4.Credentials
+	apiV2Enabled  bool
+	apiV2Receiver string
+	// adminToken is the bearer token required by the admin endpoints
+	adminToken string
+	// encrypted disables the export and backup endpoints, which would write the store in plaintext
+	encrypted bool
+}
+
+// integrationTokens holds the credentials each integration stand-in expects.
+// An empty value accepts any non-empty token.
+type integrationTokens struct {
+	telegram string
+	pushover string
+	webex    string
 }
 
 func (s *server) run(address string) error {
-	s.srv = &http.Server{Addr: address, Handler: s.router}
-	s.routes()
-	level.Info(s.logger).Log("msg", "server starting", "address", address)
-	return s.srv.ListenAndServe()
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.serve(l)
+}
+
+func (s *server) serve(l net.Listener) error {
+	s.srv = &http.Server{Handler: s.router}
+	if err := s.routes(); err != nil {
+		return err
+	}
+	level.Info(s.logger).Log("msg", "server starting", "address", l.Addr())
+	return s.srv.Serve(l)
+}
+
+func (s *server) routes() error {
+	formats, err := s.formats()
+	if err != nil {
+		return err
+	}
+	if s.tenants == nil {
+		s.tenants = tenant.NewRegistry(nil)
+	}
+
+	// the inbound formats, history and reports are served for the tenant in the header at the root, and for any tenant under /t/{tenant}
+	for _, router := range []*mux.Router{s.router, s.router.PathPrefix("/t/{tenant}").Subrouter()} {
+		for _, route := range formats.Routes() {
+			router.Handle(route.Path, s.tenant(s.record(s.handleFormat(route.Format)))).Methods(http.MethodPost)
+		}
+		router.Handle("/history/{id}", s.tenant(s.handleHistory())).Methods(http.MethodGet)
+		router.Handle("/history/{id}/forwards", s.tenant(s.handleForwards())).Methods(http.MethodGet)
+		router.Handle("/history", s.tenant(s.handleListHistory())).Methods(http.MethodGet)
+		router.Handle("/history", s.tenant(s.handleResetHistory())).Methods(http.MethodDelete)
+		router.Handle("/rejections", s.tenant(s.handleRejections())).Methods(http.MethodGet)
+		router.Handle("/alerts/{fingerprint}", s.tenant(s.handleAlertTimeline())).Methods(http.MethodGet)
+		router.Handle("/alerts", s.tenant(s.handleListAlertTimelines())).Methods(http.MethodGet)
+		router.Handle("/timing/groups", s.tenant(s.handleTiming(func(a *timing.Analyzer, tenant string) interface{} { return a.Groups(tenant) }))).Methods(http.MethodGet)
+		router.Handle("/timing/routes", s.tenant(s.handleTiming(func(a *timing.Analyzer, tenant string) interface{} { return a.Routes(tenant) }))).Methods(http.MethodGet)
+		router.Handle("/timing/assert", s.tenant(s.handleTimingAssert())).Methods(http.MethodGet)
+		router.Handle("/duplicates", s.tenant(s.handleDuplicates())).Methods(http.MethodGet)
+	}
+
+	// the admin endpoints read and write the store of every tenant
+	s.router.Handle("/admin/export", s.admin(s.handleExport())).Methods(http.MethodGet)
+	s.router.Handle("/admin/import", s.admin(s.handleImport())).Methods(http.MethodPost)
+	s.router.Handle("/admin/backup", s.admin(s.handleBackup())).Methods(http.MethodGet)
+	if s.metrics != nil {
+		s.router.Handle("/metrics", promhttp.HandlerFor(s.metrics, promhttp.HandlerOpts{})).Methods(http.MethodGet)
+	}
+	return nil
+}
+
+// formats registers every supported inbound format on its default path and binds
+// the configured webhook format to /webhook
+func (s *server) formats() (*format.Registry, error) {
+	var snsVerifier *sigv4.Verifier
+	if s.snsCreds.AccessKeyID != "" {
+		snsVerifier = sigv4.NewVerifier(s.snsCreds)
+	}
+
+	registry := format.NewRegistry()
+	for _, f := range []struct {
+		format format.Format
+		paths  []string
+	}{
+		{format: s.alertmanagerFormat()},
+		{format: format.NewTelegram(s.tokens.telegram), paths: []string{"/bot{token}/sendMessage"}},
+		{format: format.NewPushover(s.tokens.pushover), paths: []string{"/1/messages.json"}},
+		{format: format.NewWebex(s.tokens.webex), paths: []string{"/v1/messages"}},
+		{format: format.NewSNS(snsVerifier), paths: []string{"/"}},
+	} {
+		if err := registry.Register(f.format, f.paths...); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.apiV2Enabled {
+		f := format.NewAlertmanagerAPI(format.IDGenerator(s.idGenerator), s.apiV2Receiver)
+		if err := registry.Register(f, "/api/v2/alerts"); err != nil {
+			return nil, err
+		}
+	}
+
+	webhookFormat := s.webhookFormat
+	if webhookFormat == "" {
+		webhookFormat = format.AlertmanagerName
+	}
+	if err := registry.Bind("/webhook", webhookFormat); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// alertmanagerFormat returns the Alertmanager webhook format, checked strictly if enabled
+func (s *server) alertmanagerFormat() *format.Alertmanager {
+	f := format.NewAlertmanager(format.IDGenerator(s.idGenerator), s.strict)
+	f.MaxSkew = s.strictSkew
+	return f
+}
+
+// record wraps next so that its requests are written to the recording, if enabled
+func (s *server) record(next http.Handler) http.Handler {
+	if s.recorder == nil {
+		return next
+	}
+	return s.recorder.Middleware(next, func(err error) {
+		level.Error(s.logger).Log("msg", "failed to record request", "err", err)
+	})
+}
+
+type tenantContextKey struct{}
+
+// tenant resolves the tenant of a request from the /t/{tenant} path or the tenant header and checks its bearer token.
+// Requests without a tenant are served from the default tenant.
+func (s *server) tenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := mux.Vars(r)["tenant"]
+		if !ok {
+			name = r.Header.Get(tenant.Header)
+		}
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !tenant.ValidName(name) {
+			http.Error(w, "invalid tenant", http.StatusBadRequest)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !s.tenants.Authorize(name, token) {
+			level.Warn(s.logger).Log("msg", "unauthorized tenant request", "tenant", name, "path", r.URL.Path)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, name)))
+	})
+}
+
+// admin checks the admin bearer token of requests to endpoints that span every tenant.
+// Without an admin token they are only served while no tenant requires a token.
+func (s *server) admin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			if s.tenants.Authenticated() {
+				http.Error(w, "admin endpoints require -admin.token when tenants are authenticated", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			level.Warn(s.logger).Log("msg", "unauthorized admin request", "path", r.URL.Path)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tenantOf returns the tenant resolved for r, empty for the default tenant
+func tenantOf(r *http.Request) string {
+	name, _ := r.Context().Value(tenantContextKey{}).(string)
+	return name
+}
+
+// storeFor returns the view of the store holding the history of the tenant of r
+func (s *server) storeFor(r *http.Request) store.Store {
+	return store.Namespace(s.store, tenantOf(r))
+}
+
+// storeContext bounds the store operations of a request by the store timeout, if set,
+// as well as by the request itself
+func (s *server) storeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if s.storeTimeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), s.storeTimeout)
+}
+
+// backgroundStoreContext bounds store operations that outlive their request by the store timeout, if set
+func (s *server) backgroundStoreContext() (context.Context, context.CancelFunc) {
+	if s.storeTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), s.storeTimeout)
+}
+
+// storeStatus maps an error returned by the store to a status code.
+// Operations cut short by the store timeout or the client going away report the store as overloaded.
+func storeStatus(err error) int {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, store.ErrInvalidID):
+		return http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// storeError writes the response for a failed store operation
+func storeError(w http.ResponseWriter, msg string, err error) {
+	status := storeStatus(err)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+		msg = "history store overloaded: " + msg + ": " + err.Error()
+	}
+	http.Error(w, msg, status)
+}
+
+// Supported store backends
+const (
+	badgerBackend = "badger"
+	boltBackend   = "bolt"
+	memoryBackend = "memory"
+	redisBackend  = "redis"
+	sqliteBackend = "sqlite"
+)
+
+// storeOptions select and configure the history store opened by openStore
+type storeOptions struct {
+	backend string
+	// path is the file path to the store, or the server URL for the redis backend
+	path        string
+	memory      store.InMemoryOptions
+	redisPrefix string
+	redisTTL    time.Duration
+	// encryptionKeyFile holds the key the store is encrypted with. Empty stores history in plaintext.
+	encryptionKeyFile string
+	// encryptionRotation is how often the badger store backend generates a new data key
+	encryptionRotation time.Duration
+}
+
+// registerOffline registers the flags of the subcommands that open the store of a stopped receiver
+func (o *storeOptions) registerOffline(flagset *flag.FlagSet, backends string) {
+	flagset.StringVar(&o.path, "db.path", "", "The file path to the history store of a stopped receiver, or the server URL for the redis backend")
+	flagset.StringVar(&o.backend, "store.backend", defaultStoreBackend, "The history store backend used with -db.path. One of "+backends+". Empty (default) uses 'badger'")
+	flagset.StringVar(&o.redisPrefix, "redis.prefix", store.DefaultRedisPrefix, "The prefix of every key written by the redis store backend")
+	flagset.StringVar(&o.encryptionKeyFile, "encryption.key-file", "", "A file holding the key the history store used with -db.path is encrypted with. Not supported by the sqlite backend")
+}
+
+// openStore opens the history store selected by opts and registers its metrics with reg when it is not nil.
+// An empty backend is the memory backend when the path is empty and badger otherwise.
+func openStore(opts storeOptions, logger log.Logger, reg prometheus.Registerer) (store.Store, error) {
+	backend, path := opts.backend, opts.path
+	if backend == "" {
+		backend = badgerBackend
+		if path == "" {
+			backend = memoryBackend
+		}
+	}
+
+	var key []byte
+	if opts.encryptionKeyFile != "" {
+		var err error
+		if key, err = store.LoadEncryptionKey(opts.encryptionKeyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	switch backend {
+	case badgerBackend:
+		s, err := store.NewEncryptedKeyValueStore(path, store.EncryptionOptions{Key: key, DataKeyRotation: opts.encryptionRotation}, logger)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case boltBackend:
+		s, err := store.NewEncryptedBoltStore(path, key)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case memoryBackend:
+		if path != "" {
+			return nil, fmt.Errorf("the memory store backend does not persist to a path")
+		}
+		if key != nil {
+			return nil, fmt.Errorf("the memory store backend does not persist history to encrypt")
+		}
+		s, err := store.NewBoundedInMemStore(opts.memory, reg)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case redisBackend:
+		s, err := store.NewEncryptedRedisStore(path, opts.redisPrefix, opts.redisTTL, key)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case sqliteBackend:
+		// the labels and annotations are kept in indexed columns to be queried, so they cannot be sealed
+		if key != nil {
+			return nil, fmt.Errorf("the sqlite store backend does not support encryption, use 'badger', 'bolt' or 'redis'")
+		}
+		s, err := store.NewSQLStore(path)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown store backend %q", backend)
 }
 
-func (s *server) routes() {
-	s.router.HandleFunc("/webhook", s.handleWebhook()).Methods(http.MethodPost)
-	s.router.HandleFunc("/history/{id}", s.handleHistory()).Methods(http.MethodGet)
-	s.router.HandleFunc("/history", s.handleListHistory()).Methods(http.MethodGet)
+// restore loads the backups at path into s unless it already holds entries
+func restore(st store.Store, path string, logger log.Logger) error {
+	s, ok := st.(store.Backuper)
+	if !ok {
+		return fmt.Errorf("restoring backups is not supported by the store backend")
+	}
+	empty, err := s.Empty()
+	if err != nil {
+		return err
+	}
+	if !empty {
+		level.Warn(logger).Log("msg", "skipping restore as the database is not empty", "path", path)
+		return nil
+	}
+
+	files, err := store.RestorePath(s, path)
+	if err != nil {
+		return err
+	}
+	level.Info(logger).Log("msg", "database restored", "path", path, "files", len(files))
+	return nil
 }
 
+// close stops accepting requests and waits for in-flight requests to complete until ctx is done.
+// Forwards in progress are cancelled, and the recording, a final backup and the store are then flushed and closed.
 func (s *server) close(ctx context.Context) error {
-	return s.srv.Shutdown(ctx)
+	var err error
+	if s.srv != nil {
+		if err = s.srv.Shutdown(ctx); err != nil {
+			level.Warn(s.logger).Log("msg", "in-flight requests did not complete in time", "err", err)
+			s.srv.Close()
+		}
+	}
+	if s.forwards != nil {
+		if dropped := s.forwards.Close(); dropped > 0 {
+			level.Warn(s.logger).Log("msg", "notifications waiting to be forwarded were dropped", "count", dropped)
+		}
+	}
+
+	if s.recorder != nil {
+		if err := s.recorder.Close(); err != nil {
+			level.Error(s.logger).Log("msg", "failed to close recording", "err", err)
+		}
+	}
+	if s.backups != nil {
+		// capture anything written since the last scheduled backup
+		if _, err := s.backups.Backup(); err != nil {
+			level.Error(s.logger).Log("msg", "final backup failed", "err", err)
+		}
+	}
+
+	if cerr := s.store.Close(); cerr != nil {
+		return fmt.Errorf("failed to close store: %w", cerr)
+	}
+	return err
 }
 
-func (s *server) handleWebhook() http.HandlerFunc {
+// handleFormat serves a single inbound notification format, saving each normalized record to the store
+func (s *server) handleFormat(f format.Format) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 
-		var into api.Message
-		if err := json.NewDecoder(r.Body).Decode(&into); err != nil {
-			level.Error(s.logger).Log("msg", "failed to decode JSON body", "err", err)
-			http.Error(w, "failed to decode JSON body", http.StatusBadRequest)
+		receivedAt := time.Now().UTC()
+		var body []byte
+		if s.forwards != nil {
+			var err error
+			if body, err = io.ReadAll(r.Body); err != nil {
+				level.Error(s.logger).Log("msg", "failed to read request body", "format", f.Name(), "err", err)
+				f.WriteResponse(w, nil, format.Errorf(http.StatusBadRequest, "failed to read request body"))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		n, err := f.Decode(r)
+		if err != nil {
+			level.Error(s.logger).Log("msg", "failed to decode request", "format", f.Name(), "err", err)
+			// in strict mode unknown fields fail to decode, so they are rejections too
+			if s.rejections != nil {
+				s.rejections.Add(tenantOf(r), f, r, err)
+			}
+			f.WriteResponse(w, nil, err)
 			return
 		}
 
-		level.Debug(s.logger).Log("msg", "webhook received", "data", into)
+		level.Debug(s.logger).Log("msg", "notification received", "format", f.Name(), "data", fmt.Sprint(n))
+
+		if err := f.Validate(n); err != nil {
+			level.Error(s.logger).Log("msg", "invalid notification", "format", f.Name(), "err", err)
+			if s.rejections != nil {
+				s.rejections.Add(tenantOf(r), f, r, err)
+			}
+			f.WriteResponse(w, nil, err)
+			return
+		}
 
-		id, err := s.idGenerator(into)
+		records, err := f.Normalize(n)
 		if err != nil {
-			level.Error(s.logger).Log("msg", "failed to generate ID for store", "err", err)
-			http.Error(w, "failed to generate ID from request body", http.StatusInternalServerError)
+			level.Error(s.logger).Log("msg", "failed to normalize notification", "format", f.Name(), "err", err)
+			f.WriteResponse(w, nil, err)
 			return
 		}
 
-		if err := s.store.Set(id, into.Alerts); err != nil {
-			level.Error(s.logger).Log("msg", "failed to save alerts", "id", id, "err", err)
-			http.Error(w, "failed to save webhook info", http.StatusInternalServerError)
+		if records, err = s.transform(records); err != nil {
+			level.Error(s.logger).Log("msg", "failed to transform notification", "format", f.Name(), "err", err)
+			f.WriteResponse(w, nil, format.Errorf(http.StatusInternalServerError, "failed to transform notification"))
 			return
 		}
 
-		resp, err := json.Marshal(api.MessageResponse{ID: id})
+		ctx, cancel := s.storeContext(r)
+		defer cancel()
+		name := tenantOf(r)
+		for _, rec := range records {
+			if err := s.tenants.Save(ctx, s.store, name, rec.ID, rec.Alerts, receivedAt); err != nil {
+				level.Error(s.logger).Log("msg", "failed to save alerts", "id", rec.ID, "tenant", name, "err", err)
+				switch status := storeStatus(err); status {
+				case http.StatusServiceUnavailable:
+					w.Header().Set("Retry-After", "1")
+					f.WriteResponse(w, nil, format.Errorf(status, "history store overloaded: failed to save alerts: %v", err))
+					return
+				case http.StatusBadRequest:
+					f.WriteResponse(w, nil, format.Errorf(status, "invalid notification id: %v", err))
+					return
+				}
+				f.WriteResponse(w, nil, err)
+				return
+			}
+			// only Alertmanager notifications carry the receiver and group key the analysers are keyed by
+			if f.Name() == format.AlertmanagerName {
+				s.observe(name, rec, remoteHost(r), receivedAt)
+			}
+		}
+		s.forward(name, records, body, r.Header)
+
+		f.WriteResponse(w, records, nil)
+	}
+}
+
+// forward queues the raw notification body for the downstream webhooks, if enabled,
+// and saves the outcomes against each stored record once forwarded
+// When a transformation pipeline is configured each transformed message is forwarded in place of the raw body.
+func (s *server) forward(tenant string, records []format.Record, body []byte, header http.Header) {
+	if s.forwards == nil {
+		return
+	}
+	if s.transforms == nil {
+		s.forwardBody(tenant, records, body, header)
+		return
+	}
+	for _, rec := range records {
+		b := body
+		if rec.Message != nil {
+			var err error
+			if b, err = json.Marshal(rec.Message); err != nil {
+				level.Error(s.logger).Log("msg", "failed to encode transformed notification", "id", rec.ID, "err", err)
+				continue
+			}
+		}
+		s.forwardBody(tenant, []format.Record{rec}, b, header)
+	}
+}
+
+func (s *server) forwardBody(tenant string, records []format.Record, body []byte, header http.Header) {
+	err := s.forwards.Enqueue(body, header, func(outcomes []forward.Outcome) {
+		for _, o := range outcomes {
+			if !o.Success() {
+				level.Warn(s.logger).Log("msg", "failed to forward notification", "target", o.Target, "attempts", o.Attempts, "err", o.Error)
+			}
+		}
+		// the request has completed, so only the store timeout bounds the save
+		ctx, cancel := s.backgroundStoreContext()
+		defer cancel()
+		st := store.Namespace(s.store, tenant)
+		for _, rec := range records {
+			if err := store.AddForwards(ctx, st, rec.ID, outcomes); err != nil {
+				level.Error(s.logger).Log("msg", "failed to save forwarding outcomes", "id", rec.ID, "tenant", tenant, "err", err)
+			}
+		}
+	})
+	if err != nil {
+		level.Warn(s.logger).Log("msg", "notification not forwarded", "tenant", tenant, "err", err)
+	}
+}
+
+// transform runs the transformation pipeline, if configured, over each record.
+// Records holding a message are given a new ID generated from the transformed message.
+func (s *server) transform(records []format.Record) ([]format.Record, error) {
+	if s.transforms == nil {
+		return records, nil
+	}
+
+	var out []format.Record
+	for _, rec := range records {
+		if rec.Message == nil {
+			msgs, err := s.transforms.Apply(api.Message{Alerts: rec.Alerts})
+			if err != nil {
+				return nil, err
+			}
+			for _, m := range msgs {
+				out = append(out, format.Record{ID: splitID(rec.ID, m, len(msgs)), Alerts: m.Alerts})
+			}
+			continue
+		}
+
+		msgs, err := s.transforms.Apply(*rec.Message)
 		if err != nil {
-			level.Error(s.logger).Log("msg", "failed to encode response", "id", id, "err", err)
-			http.Error(w, "failed to encode response", http.StatusInternalServerError)
-			return
+			return nil, err
+		}
+		for i := range msgs {
+			id, err := s.idGenerator(msgs[i])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, format.Record{ID: splitID(id, msgs[i], len(msgs)), Alerts: msgs[i].Alerts, Message: &msgs[i]})
 		}
+	}
+	return out, nil
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(resp)
+// splitID suffixes id with the fingerprint of the single alert of msg when it is one of n messages split from a
+// notification, so that the splits do not overwrite each other
+func splitID(id string, msg api.Message, n int) string {
+	if n <= 1 || len(msg.Alerts) != 1 {
+		return id
+	}
+	return id + "_" + lifecycle.Fingerprint(msg.Alerts[0])
+}
+
+// remoteHost returns the address a request was sent from without its port, which changes with every connection
+// and would make each delivery from the same peer look like a different source
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// observe passes a stored record of tenant delivered from source to each of the enabled analysers
+func (s *server) observe(tenant string, rec format.Record, source string, receivedAt time.Time) {
+	if rec.Message == nil {
+		return
+	}
+	if s.lifecycle != nil {
+		s.lifecycle.Observe(tenant, *rec.Message, receivedAt)
+	}
+	if s.timing != nil {
+		s.timing.Observe(tenant, *rec.Message, receivedAt)
+	}
+	if s.dedup != nil && s.dedup.Observe(tenant, *rec.Message, source, receivedAt) {
+		level.Warn(s.logger).Log("msg", "duplicate notification received", "id", rec.ID, "tenant", tenant, "groupKey", rec.Message.GroupKey, "source", source)
 	}
 }
 
 func (s *server) handleHistory() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id, _ := mux.Vars(r)["id"]
-		history, err := s.store.Get(id)
+		ctx, cancel := s.storeContext(r)
+		defer cancel()
+		history, err := s.storeFor(r).Get(ctx, id)
 		if err != nil {
-			status := http.StatusInternalServerError
 			level.Error(s.logger).Log("msg", "failed to read webhook history", "id", id, "err", err)
-			if errors.Is(err, store.ErrNotFound) {
-				status = http.StatusNotFound
-			}
-			http.Error(w, "failed to read webhook history", status)
+			storeError(w, "failed to read webhook history", err)
 			return
 		}
 
@@ -169,23 +938,418 @@ func (s *server) handleHistory() http.HandlerFunc {
 	}
 }
 
+// handleForwards returns the forwarding outcomes saved against a stored notification
+func (s *server) handleForwards() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		ctx, cancel := s.storeContext(r)
+		defer cancel()
+		outcomes, err := store.Forwards(ctx, s.storeFor(r), id)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "no forwarding outcomes found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			level.Error(s.logger).Log("msg", "failed to read forwarding outcomes", "id", id, "err", err)
+			storeError(w, "failed to read forwarding outcomes", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(outcomes); err != nil {
+			level.Error(s.logger).Log("msg", "failed to encode forwarding outcomes", "id", id, "err", err)
+			http.Error(w, "failed to encode forwarding outcomes", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// handleExport streams the full contents of the store in the versioned export format
+func (s *server) handleExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.encrypted {
+			http.Error(w, "exports are disabled as the store is encrypted at rest", http.StatusForbidden)
+			return
+		}
+		export := &exportWriter{w: w}
+		if err := store.Export(r.Context(), s.store, export); err != nil {
+			level.Error(s.logger).Log("msg", "failed to export store", "err", err)
+			// once the header is written the status is sent, and the missing trailer marks the export as incomplete
+			if !export.started {
+				storeError(w, "failed to export store", err)
+			}
+		}
+	}
+}
+
+// exportWriter sets the export response headers before the first write
+type exportWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (e *exportWriter) Write(b []byte) (int, error) {
+	if !e.started {
+		e.started = true
+		e.w.Header().Set("Content-Type", store.ExportContentType)
+		e.w.Header().Set("Content-Disposition", `attachment; filename="export.ndjson"`)
+	}
+	return e.w.Write(b)
+}
+
+// handleImport loads an export into the store, overwriting entries with the same ID
+func (s *server) handleImport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		n, err := store.Import(r.Context(), s.store, r.Body)
+		if err != nil {
+			level.Error(s.logger).Log("msg", "failed to import store", "imported", n, "err", err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, store.ErrInvalidExport) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, fmt.Sprintf("failed to import store: %v", err), status)
+			return
+		}
+		level.Info(s.logger).Log("msg", "store imported", "entries", n)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]int{"imported": n}); err != nil {
+			level.Error(s.logger).Log("msg", "failed to encode import response", "err", err)
+		}
+	}
+}
+
+// handleBackup streams a consistent backup of the entries written after the version in the since parameter.
+// The version to request the next incremental backup with is sent in the backupSinceHeader trailer.
+func (s *server) handleBackup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.encrypted {
+			http.Error(w, "backups are disabled as the store is encrypted at rest", http.StatusForbidden)
+			return
+		}
+		b, ok := s.store.(store.Backuper)
+		if !ok {
+			http.Error(w, "backups are not supported by the store", http.StatusNotImplemented)
+			return
+		}
+
+		var since uint64
+		if v := r.URL.Query().Get("since"); v != "" {
+			var err error
+			if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+				http.Error(w, "invalid since parameter", http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.Header().Set("Trailer", backupSinceHeader)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		next, err := b.Backup(w, since)
+		if err != nil {
+			// the status has already been sent so the missing trailer signals the failure
+			level.Error(s.logger).Log("msg", "failed to write backup", "err", err)
+			return
+		}
+		w.Header().Set(backupSinceHeader, strconv.FormatUint(next, 10))
+	}
+}
+
+// handleListHistory streams every stored entry, or with any of the matchers, status, from and to
+// parameters only those holding an alert that satisfies them all. Entries are written as a JSON array,
+// or as NDJSON when the client accepts application/x-ndjson.
 func (s *server) handleListHistory() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		history, err := s.store.List()
+		q, err := parseHistoryQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := s.storeContext(r)
+		defer cancel()
+		st := s.storeFor(r)
+		stream := &historyStream{w: w, ndjson: strings.Contains(r.Header.Get("Accept"), ndjsonContentType)}
+		if q == nil {
+			err = st.Iterate(ctx, stream.write)
+		} else {
+			err = store.SelectEach(ctx, st, *q, stream.write)
+		}
+		if err == nil {
+			err = stream.close()
+		}
+		if err == nil {
+			return
+		}
+
+		level.Error(s.logger).Log("msg", "failed to list webhook history", "err", err)
+		// once an entry is written the status is sent, so the response is left truncated
+		if stream.n == 0 {
+			storeError(w, "failed to list webhook history", err)
+		}
+	}
+}
+
+// historyStream encodes entries to a response as they are read from the store. Nothing is written before
+// the first entry so that an error until then can still be reported with its status code.
+type historyStream struct {
+	w      http.ResponseWriter
+	ndjson bool
+	// n is the number of entries written
+	n int
+}
+
+func (h *historyStream) write(e api.MessageEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	sep := ","
+	if h.n == 0 {
+		h.writeHeader()
+		sep = "["
+	}
+	if h.ndjson {
+		sep = ""
+		b = append(b, '\n')
+	}
+	h.n++
+	if _, err := io.WriteString(h.w, sep); err != nil {
+		return err
+	}
+	_, err = h.w.Write(b)
+	return err
+}
+
+func (h *historyStream) close() error {
+	if h.n == 0 {
+		h.writeHeader()
+		if !h.ndjson {
+			_, err := io.WriteString(h.w, "[]\n")
+			return err
+		}
+		return nil
+	}
+	if h.ndjson {
+		return nil
+	}
+	_, err := io.WriteString(h.w, "]\n")
+	return err
+}
+
+func (h *historyStream) writeHeader() {
+	contentType := "application/json"
+	if h.ndjson {
+		contentType = ndjsonContentType
+	}
+	h.w.Header().Set("Content-Type", contentType)
+}
+
+// handleResetHistory deletes the history of every ID of the tenant
+func (s *server) handleResetHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := s.storeContext(r)
+		defer cancel()
+		name := tenantOf(r)
+		n, err := s.tenants.Reset(ctx, s.store, name)
 		if err != nil {
-			level.Error(s.logger).Log("msg", "failed to list webhook history", "err", err)
-			http.Error(w, "failed to list webhook history", http.StatusInternalServerError)
+			level.Error(s.logger).Log("msg", "failed to reset webhook history", "tenant", name, "err", err)
+			storeError(w, "failed to reset webhook history", err)
 			return
 		}
+		s.resetReports(name)
+		level.Info(s.logger).Log("msg", "webhook history reset", "tenant", name, "entries", n)
 
 		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(history); err != nil {
-			level.Error(s.logger).Log("msg", "failed to encode the list webhook history", "err", err)
-			http.Error(w, "failed to encode the list webhook history", http.StatusInternalServerError)
+		if err := json.NewEncoder(w).Encode(map[string]int{"deleted": n}); err != nil {
+			level.Error(s.logger).Log("msg", "failed to encode reset response", "err", err)
+		}
+	}
+}
+
+// resetReports forgets what the analysers and the rejection log observed for tenant
+func (s *server) resetReports(tenant string) {
+	if s.lifecycle != nil {
+		s.lifecycle.Reset(tenant)
+	}
+	if s.timing != nil {
+		s.timing.Reset(tenant)
+	}
+	if s.dedup != nil {
+		s.dedup.Reset(tenant)
+	}
+	if s.rejections != nil {
+		s.rejections.Reset(tenant)
+	}
+}
+
+// parseHistoryQuery returns nil if no query parameters are set
+func parseHistoryQuery(params url.Values) (*store.Query, error) {
+	var (
+		q   store.Query
+		set bool
+	)
+	for _, param := range params["matchers"] {
+		ms, err := labels.ParseMatchers(param)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse matchers: %w", err)
+		}
+		q.Matchers = append(q.Matchers, ms...)
+		set = true
+	}
+	if v := params.Get("status"); v != "" {
+		q.Status = v
+		set = true
+	}
+	for name, t := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		v := params.Get(name)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s parameter: %w", name, err)
+		}
+		*t = parsed
+		set = true
+	}
+	if !set {
+		return nil, nil
+	}
+	return &q, nil
+}
+
+func (s *server) handleRejections() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rejections := []format.Rejection{}
+		if s.rejections != nil {
+			rejections = s.rejections.List(tenantOf(r))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(rejections); err != nil {
+			level.Error(s.logger).Log("msg", "failed to encode rejections", "err", err)
+			http.Error(w, "failed to encode rejections", http.StatusInternalServerError)
 			return
 		}
+	}
+}
 
-		return
+func (s *server) handleAlertTimeline() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fingerprint := mux.Vars(r)["fingerprint"]
+		if s.lifecycle == nil {
+			http.Error(w, "alert lifecycle tracking is disabled", http.StatusNotFound)
+			return
+		}
+
+		timeline, ok := s.lifecycle.Get(tenantOf(r), fingerprint)
+		if !ok {
+			level.Error(s.logger).Log("msg", "failed to read alert timeline", "fingerprint", fingerprint, "err", store.ErrNotFound)
+			http.Error(w, "failed to read alert timeline", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(timeline); err != nil {
+			level.Error(s.logger).Log("msg", "failed to encode alert timeline", "fingerprint", fingerprint, "err", err)
+			http.Error(w, "failed to encode alert timeline", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// handleListAlertTimelines returns the timelines of alerts matching every matchers parameter.
+// Each parameter may hold a single matcher or a set such as {alertname="Test",job=~"prom.*"}.
+func (s *server) handleListAlertTimelines() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var matchers labels.Matchers
+		for _, param := range r.URL.Query()["matchers"] {
+			ms, err := labels.ParseMatchers(param)
+			if err != nil {
+				level.Error(s.logger).Log("msg", "failed to parse matchers", "matchers", param, "err", err)
+				http.Error(w, "failed to parse matchers: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			matchers = append(matchers, ms...)
+		}
+
+		timelines := []lifecycle.Timeline{}
+		if s.lifecycle != nil {
+			timelines = s.lifecycle.List(tenantOf(r), matchers)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(timelines); err != nil {
+			level.Error(s.logger).Log("msg", "failed to encode alert timelines", "err", err)
+			http.Error(w, "failed to encode alert timelines", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (s *server) handleTiming(report func(a *timing.Analyzer, tenant string) interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.timing == nil {
+			http.Error(w, "timing analysis is disabled", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report(s.timing, tenantOf(r))); err != nil {
+			level.Error(s.logger).Log("msg", "failed to encode timing report", "err", err)
+			http.Error(w, "failed to encode timing report", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// handleTimingAssert responds with 417 Expectation Failed and the violations when any observed
+// timing is outside the tolerance of the expected route timers
+func (s *server) handleTimingAssert() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.timing == nil {
+			http.Error(w, "timing analysis is disabled", http.StatusNotFound)
+			return
+		}
+
+		violations := s.timing.Violations(tenantOf(r))
+		status := http.StatusOK
+		if len(violations) > 0 {
+			status = http.StatusExpectationFailed
+			for _, v := range violations {
+				level.Warn(s.logger).Log("msg", "route timer violation", "violation", v)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(struct {
+			Pass       bool               `json:"pass"`
+			Violations []timing.Violation `json:"violations"`
+		}{Pass: len(violations) == 0, Violations: violations}); err != nil {
+			level.Error(s.logger).Log("msg", "failed to encode timing violations", "err", err)
+		}
+	}
+}
+
+func (s *server) handleDuplicates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.dedup == nil {
+			http.Error(w, "duplicate detection is disabled", http.StatusNotFound)
+			return
+		}
+
+		name := tenantOf(r)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(struct {
+			Total      int               `json:"total"`
+			Duplicates []dedup.Duplicate `json:"duplicates"`
+		}{Total: s.dedup.Total(name), Duplicates: s.dedup.Duplicates(name)}); err != nil {
+			level.Error(s.logger).Log("msg", "failed to encode duplicates", "err", err)
+			http.Error(w, "failed to encode duplicates", http.StatusInternalServerError)
+			return
+		}
 	}
 }
 
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
+	"runtime"
+	"runtime/debug"
 	"strings"
 	"testing"
 	"time"
@@ -14,6 +19,14 @@ import (
 	"github.com/go-kit/log"
 	"github.com/gorilla/mux"
 	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/dedup"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/format"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/forward"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/lifecycle"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/timing"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/transform"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func TestGetHandler(t *testing.T) {
@@ -189,6 +202,715 @@ func TestListHandler(t *testing.T) {
 	}
 }
 
+func TestListHandlerQuery(t *testing.T) {
+	sqlStore, err := openStore(storeOptions{backend: sqliteBackend}, log.NewNopLogger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlStore.Close()
+	srv := &server{
+		router:      mux.NewRouter(),
+		logger:      log.NewNopLogger(),
+		store:       sqlStore,
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+	}
+	srv.routes()
+
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook", getSamplePayload(t)))
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 response but got %d", w.Result().StatusCode)
+	}
+
+	for query, expect := range map[string]int{
+		"": 1,
+		`?matchers={dc="eu-west-1"}&status=firing`:           1,
+		"?status=resolved":                                   0,
+		"?from=2018-08-03T08:00:00Z&to=2018-08-03T08:00:01Z": 0,
+		"?from=2018-08-03T07:00:00Z":                         1,
+	} {
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/history"+strings.ReplaceAll(query, `"`, "%22"), nil))
+		var entries []api.MessageEntry
+		if err := json.NewDecoder(w.Body).Decode(&entries); err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != expect {
+			t.Fatalf("%s: wanted %v got %v", query, expect, entries)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/history?from=yesterday", nil))
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 response but got %d", w.Result().StatusCode)
+	}
+}
+
+func TestListHandlerStream(t *testing.T) {
+	entries := []api.MessageEntry{
+		{ID: "a", Alerts: []api.Alert{{Status: "firing"}}},
+		{ID: "b", Alerts: []api.Alert{{Status: "resolved"}}},
+	}
+	var listed []api.MessageEntry
+	var listErr error
+	srv := &server{
+		router: mux.NewRouter(),
+		logger: log.NewNopLogger(),
+		store: mockStore{
+			listFn: func() ([]api.MessageEntry, error) { return listed, listErr },
+		},
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+	}
+	srv.routes()
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/history", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("")
+	if w.Body.String() != "[]\n" || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected an empty JSON array but got %s %q", w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	listed = entries
+	w = get(ndjsonContentType)
+	if w.Header().Get("Content-Type") != ndjsonContentType {
+		t.Fatalf("wanted %s got %s", ndjsonContentType, w.Header().Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	if len(lines) != len(entries) {
+		t.Fatalf("wanted %d lines got %q", len(entries), w.Body.String())
+	}
+	for i, line := range lines {
+		var e api.MessageEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.ID != entries[i].ID || e.Alerts[0].Status != entries[i].Alerts[0].Status {
+			t.Fatalf("wanted %v got %v", entries[i], e)
+		}
+	}
+
+	w = get("application/json")
+	var got []api.MessageEntry
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(entries) {
+		t.Fatalf("wanted %v got %v", entries, got)
+	}
+
+	listErr = fmt.Errorf("unavailable")
+	if w = get(""); w.Result().StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected 500 response but got %d", w.Result().StatusCode)
+	}
+}
+
+// discardResponseWriter drops the body so that a benchmark measures the memory of the handler alone
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d *discardResponseWriter) Header() http.Header         { return d.header }
+func (d *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardResponseWriter) WriteHeader(int)             {}
+
+// BenchmarkListHistory reports the peak heap growth of listing 100k entries, streamed as a JSON array
+// and as NDJSON, against encoding the whole List in one go as the handler did before it streamed
+func BenchmarkListHistory(b *testing.B) {
+	const entries = 100000
+	ctx := context.Background()
+	st, err := store.NewKeyValueStore("", log.NewNopLogger())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer st.Close()
+	alerts := []api.Alert{{
+		Status:      "firing",
+		Labels:      map[string]string{"alertname": "Benchmark", "severity": "critical", "instance": "localhost:9090"},
+		Annotations: map[string]string{"summary": "an alert used to benchmark listing the history"},
+	}}
+	for i := 0; i < entries; i++ {
+		if err := st.Set(ctx, fmt.Sprintf("id-%06d", i), alerts); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	srv := &server{
+		router:      mux.NewRouter(),
+		logger:      log.NewNopLogger(),
+		store:       st,
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+	}
+	srv.routes()
+
+	for _, bc := range []struct {
+		name  string
+		serve func(w http.ResponseWriter)
+	}{
+		{name: "json", serve: func(w http.ResponseWriter) {
+			srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/history", nil))
+		}},
+		{name: "ndjson", serve: func(w http.ResponseWriter) {
+			req := httptest.NewRequest(http.MethodGet, "/history", nil)
+			req.Header.Set("Accept", ndjsonContentType)
+			srv.router.ServeHTTP(w, req)
+		}},
+		{name: "buffered", serve: func(w http.ResponseWriter) {
+			history, err := st.List(ctx)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := json.NewEncoder(w).Encode(history); err != nil {
+				b.Fatal(err)
+			}
+		}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var peak uint64
+			for i := 0; i < b.N; i++ {
+				if growth := peakHeapGrowth(func() { bc.serve(&discardResponseWriter{header: http.Header{}}) }); growth > peak {
+					peak = growth
+				}
+			}
+			b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MiB")
+		})
+	}
+}
+
+// peakHeapGrowth samples the heap while fn runs and returns its largest growth. The garbage collector runs
+// often meanwhile, so that the heap follows the memory held by fn rather than garbage awaiting collection.
+func peakHeapGrowth(fn func()) uint64 {
+	defer debug.SetGCPercent(debug.SetGCPercent(5))
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	base := stats.HeapAlloc
+
+	done := make(chan struct{})
+	peak := make(chan uint64)
+	go func() {
+		var max uint64
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				peak <- max
+				return
+			case <-ticker.C:
+				var stats runtime.MemStats
+				runtime.ReadMemStats(&stats)
+				if stats.HeapAlloc > base && stats.HeapAlloc-base > max {
+					max = stats.HeapAlloc - base
+				}
+			}
+		}
+	}()
+	fn()
+	close(done)
+	return <-peak
+}
+
+func TestWebhookFormat(t *testing.T) {
+	var savedID string
+	srv := &server{
+		router: mux.NewRouter(),
+		logger: log.NewNopLogger(),
+		store: mockStore{
+			setFn: func(id string, alerts []api.Alert) error {
+				savedID = id
+				return nil
+			},
+		},
+		idGenerator:   buildIdGenerator(defaultStoreIDTemplate),
+		webhookFormat: format.WebexName,
+	}
+	if err := srv.routes(); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"roomId":"room","text":"firing"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer any")
+
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 response but got %d", w.Result().StatusCode)
+	}
+	if savedID != "webex_room" {
+		t.Fatalf("wanted webex_room but got %s", savedID)
+	}
+
+	srv = &server{router: mux.NewRouter(), logger: log.NewNopLogger(), webhookFormat: "unknown"}
+	if err := srv.routes(); err == nil {
+		t.Fatal("expected unknown webhook format to fail")
+	}
+}
+
+func TestStrictWebhookRejections(t *testing.T) {
+	srv := &server{
+		router:      mux.NewRouter(),
+		logger:      log.NewNopLogger(),
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+		strict:      true,
+		rejections:  format.NewRejectionLog(maxRejections),
+	}
+	srv.routes()
+
+	// the sample payload has no alert fingerprints
+	req, err := http.NewRequest(http.MethodPost, "/webhook", getSamplePayload(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 response but got %d", w.Result().StatusCode)
+	}
+	if !strings.Contains(w.Body.String(), "alerts[0]: fingerprint must not be empty") {
+		t.Fatalf("unexpected response %s", w.Body.String())
+	}
+
+	req, err = http.NewRequest(http.MethodGet, "/rejections", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+
+	var rejections []format.Rejection
+	if err := json.NewDecoder(w.Body).Decode(&rejections); err != nil {
+		t.Fatal(err)
+	}
+	if len(rejections) != 1 || rejections[0].Errors[0] != "alerts[0]: fingerprint must not be empty" {
+		t.Fatalf("unexpected rejections %v", rejections)
+	}
+
+	// payloads that cannot be decoded are rejections too
+	for _, body := range []string{`{"version": "4", "unknown": true}`, `not json`} {
+		req := httptest.NewRequest(http.MethodPost, "/t/team-a/webhook", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, req)
+		if w.Result().StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected 400 response but got %d", w.Result().StatusCode)
+		}
+	}
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/t/team-a/rejections", nil))
+	rejections = nil
+	if err := json.NewDecoder(w.Body).Decode(&rejections); err != nil {
+		t.Fatal(err)
+	}
+	if len(rejections) != 2 || !strings.Contains(rejections[0].Errors[0], "unknown") {
+		t.Fatalf("unexpected rejections %v", rejections)
+	}
+}
+
+func TestAlertTimelines(t *testing.T) {
+	srv := &server{
+		router: mux.NewRouter(),
+		logger: log.NewNopLogger(),
+		store: mockStore{
+			setFn: func(id string, alerts []api.Alert) error { return nil },
+		},
+		idGenerator:  buildIdGenerator(defaultStoreIDTemplate),
+		lifecycle:    lifecycle.NewTracker(0),
+		apiV2Enabled: true,
+	}
+	srv.routes()
+
+	req, err := http.NewRequest(http.MethodPost, "/webhook", getSamplePayload(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 response but got %d", w.Result().StatusCode)
+	}
+
+	// alerts pushed by a ruler have no receiver or group key so are not tracked
+	req, err = http.NewRequest(http.MethodPost, "/api/v2/alerts", strings.NewReader(`[{"labels":{"alertname":"Pushed","job":"prometheus24","dc":"eu-west-1"}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 response but got %d", w.Result().StatusCode)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, `/alerts?matchers={job="prometheus24"}&matchers=dc=~"eu-.*"`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+
+	var timelines []lifecycle.Timeline
+	if err := json.NewDecoder(w.Body).Decode(&timelines); err != nil {
+		t.Fatal(err)
+	}
+	if len(timelines) != 1 || timelines[0].Receivers[0] != "webhook" || timelines[0].FirstFiringAt.IsZero() {
+		t.Fatalf("unexpected timelines %v", timelines)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, "/alerts/"+timelines[0].Fingerprint, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 response but got %d", w.Result().StatusCode)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, "/alerts/unknown", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 response but got %d", w.Result().StatusCode)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, `/alerts?matchers={job="x"`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 response but got %d", w.Result().StatusCode)
+	}
+}
+
+func TestTimingReports(t *testing.T) {
+	srv := &server{
+		router: mux.NewRouter(),
+		logger: log.NewNopLogger(),
+		store: mockStore{
+			setFn: func(id string, alerts []api.Alert) error { return nil },
+		},
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+		timing:      timing.NewAnalyzer(timing.Expectations{GroupWait: 30 * time.Second, Tolerance: time.Second}, 0),
+	}
+	srv.routes()
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodPost, "/webhook", getSamplePayload(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, req)
+		if w.Result().StatusCode != http.StatusOK {
+			t.Fatalf("expected 200 response but got %d", w.Result().StatusCode)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/timing/groups", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+
+	var reports []timing.Report
+	if err := json.NewDecoder(w.Body).Decode(&reports); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Notifications != 2 || reports[0].RepeatInterval.Count != 1 || reports[0].Route != "{}" {
+		t.Fatalf("unexpected timing reports %v", reports)
+	}
+
+	// the sample payload started firing in 2018 so the observed group wait is far beyond the expectation
+	req, err = http.NewRequest(http.MethodGet, "/timing/assert", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+	if w.Result().StatusCode != http.StatusExpectationFailed {
+		t.Fatalf("expected 417 response but got %d", w.Result().StatusCode)
+	}
+}
+
+func TestDuplicateNotifications(t *testing.T) {
+	metrics := prometheus.NewRegistry()
+	srv := &server{
+		router: mux.NewRouter(),
+		logger: log.NewNopLogger(),
+		store: mockStore{
+			setFn: func(id string, alerts []api.Alert) error { return nil },
+		},
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+		dedup:       dedup.NewDetector(time.Minute, metrics),
+		metrics:     metrics,
+	}
+	srv.routes()
+
+	for _, peer := range []string{"10.0.0.1:9093", "10.0.0.2:9093"} {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", getSamplePayload(t))
+		req.RemoteAddr = peer
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, req)
+		if w.Result().StatusCode != http.StatusOK {
+			t.Fatalf("expected 200 response but got %d", w.Result().StatusCode)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/duplicates", nil))
+	var into struct {
+		Total      int               `json:"total"`
+		Duplicates []dedup.Duplicate `json:"duplicates"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&into); err != nil {
+		t.Fatal(err)
+	}
+	if into.Total != 1 || len(into.Duplicates) != 1 || into.Duplicates[0].Deliveries[1].Source != "10.0.0.2" {
+		t.Fatalf("unexpected duplicates %v", into)
+	}
+
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `webhook_duplicate_notifications_total{receiver="webhook"} 1`) {
+		t.Fatalf("expected duplicate metric in %s", w.Body.String())
+	}
+}
+
+func TestForwarding(t *testing.T) {
+	var forwarded []byte
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded, _ = io.ReadAll(r.Body)
+		if r.Header.Get("X-Scope-OrgID") != "team-a" {
+			t.Errorf("expected target header to be injected but got %v", r.Header)
+		}
+	}))
+	t.Cleanup(downstream.Close)
+
+	cfg, err := forward.Parse([]byte(fmt.Sprintf(`
+targets:
+- name: downstream
+  url: %s
+  headers:
+    X-Scope-OrgID: team-a
+`, downstream.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &server{
+		router:      mux.NewRouter(),
+		logger:      log.NewNopLogger(),
+		store:       store.NewInMemStore(),
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+		forwards:    forward.NewQueue(forward.NewForwarder(cfg), 1, 1),
+	}
+	srv.routes()
+	t.Cleanup(func() { srv.close(context.Background()) })
+
+	payload, err := io.ReadAll(getSamplePayload(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(payload)))
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 response but got %d", w.Result().StatusCode)
+	}
+
+	// outcomes are saved once the notification is forwarded in the background
+	w = awaitForwards(t, srv, "/history/Test_webhook/forwards")
+	if !bytes.Equal(forwarded, payload) {
+		t.Fatalf("wanted %s got %s", payload, forwarded)
+	}
+	var outcomes []forward.Outcome
+	if err := json.NewDecoder(w.Body).Decode(&outcomes); err != nil {
+		t.Fatal(err)
+	}
+	if len(outcomes) != 1 || outcomes[0].Target != "downstream" || outcomes[0].Status != http.StatusOK || !outcomes[0].Success() {
+		t.Fatalf("unexpected outcomes %v", outcomes)
+	}
+
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/history/unknown/forwards", nil))
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 response but got %d", w.Result().StatusCode)
+	}
+}
+
+// awaitForwards polls path until the forwarding outcomes it serves have been saved
+func awaitForwards(t *testing.T, srv *server, path string) *httptest.ResponseRecorder {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Result().StatusCode != http.StatusNotFound {
+			return w
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected forwarding outcomes at %s", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTransformations(t *testing.T) {
+	received := make(chan api.Message, 1)
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m api.Message
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			t.Error(err)
+		}
+		received <- m
+	}))
+	t.Cleanup(downstream.Close)
+
+	cfg, err := forward.Parse([]byte("targets: [{url: " + downstream.URL + "}]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transforms, err := transform.Parse([]byte(`
+transformations:
+- action: drop_labels
+  labels: [dc]
+- action: rename_label
+  source: instance
+  target: host
+- action: redact_annotations
+  regex: some
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := map[string][]api.Alert{}
+	srv := &server{
+		router: mux.NewRouter(),
+		logger: log.NewNopLogger(),
+		store: mockStore{
+			setFn: func(id string, alerts []api.Alert) error {
+				stored[id] = alerts
+				return nil
+			},
+		},
+		idGenerator: buildIdGenerator(`{{ .CommonLabels.host }}`),
+		transforms:  transforms,
+		forwards:    forward.NewQueue(forward.NewForwarder(cfg), 1, 1),
+	}
+	srv.routes()
+
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook", getSamplePayload(t)))
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 response but got %d", w.Result().StatusCode)
+	}
+	t.Cleanup(func() { srv.forwards.Close() })
+
+	alerts, ok := stored["localhost:9090"]
+	if !ok {
+		t.Fatalf("expected ID to be generated from the transformed message but got %v", stored)
+	}
+	expectLabels := map[string]string{"alertname": "Test", "host": "localhost:9090", "job": "prometheus24"}
+	if !reflect.DeepEqual(alerts[0].Labels, expectLabels) {
+		t.Fatalf("wanted %v got %v", expectLabels, alerts[0].Labels)
+	}
+	if alerts[0].Annotations["description"] != "[REDACTED] description" {
+		t.Fatalf("expected annotation to be redacted but got %v", alerts[0].Annotations)
+	}
+	var forwarded api.Message
+	select {
+	case forwarded = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the transformed message to be forwarded")
+	}
+	if !reflect.DeepEqual(forwarded.Alerts, alerts) || forwarded.CommonAnnotations["description"] != "[REDACTED] description" {
+		t.Fatalf("expected the transformed message to be forwarded but got %v", forwarded)
+	}
+}
+
+func TestTransformationsSplit(t *testing.T) {
+	transforms, err := transform.Parse([]byte(`transformations: [{action: split}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := map[string][]api.Alert{}
+	srv := &server{
+		router: mux.NewRouter(),
+		logger: log.NewNopLogger(),
+		store: mockStore{
+			setFn: func(id string, alerts []api.Alert) error {
+				stored[id] = alerts
+				return nil
+			},
+		},
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+		transforms:  transforms,
+	}
+	srv.routes()
+
+	var msg api.Message
+	if err := json.NewDecoder(getSamplePayload(t)).Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	second := msg.Alerts[0]
+	second.Labels = map[string]string{"alertname": "Test", "instance": "localhost:9091", "job": "prometheus24"}
+	msg.Alerts = append(msg.Alerts, second)
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(payload)))
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 response but got %d", w.Result().StatusCode)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("expected each split to be stored under its own ID but got %v", stored)
+	}
+	for id, alerts := range stored {
+		if expect := "Test_webhook_" + lifecycle.Fingerprint(alerts[0]); len(alerts) != 1 || id != expect {
+			t.Fatalf("wanted %s got %s", expect, id)
+		}
+	}
+}
+
+func TestAlertsAPIDisabled(t *testing.T) {
+	srv := &server{
+		router:      mux.NewRouter(),
+		logger:      log.NewNopLogger(),
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+	}
+	srv.routes()
+
+	req, err := http.NewRequest(http.MethodPost, "/api/v2/alerts", strings.NewReader(`[]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 response but got %d", w.Result().StatusCode)
+	}
+}
+
 func TestGeneratedIDFromPayload(t *testing.T) {
 	samplePayload := getSamplePayload(t)
 	t.Cleanup(func() {
@@ -231,19 +953,121 @@ func getSamplePayload(t *testing.T) io.ReadCloser {
 }
 
 type mockStore struct {
-	getFn  func(id string) ([]api.Alert, error)
-	setFn  func(id string, alerts []api.Alert) error
-	listFn func() ([]api.MessageEntry, error)
+	getFn    func(id string) ([]api.Alert, error)
+	setFn    func(id string, alerts []api.Alert) error
+	listFn   func() ([]api.MessageEntry, error)
+	deleteFn func(id string) error
 }
 
-func (m mockStore) Get(id string) ([]api.Alert, error) {
+func (m mockStore) Get(ctx context.Context, id string) ([]api.Alert, error) {
 	return m.getFn(id)
 }
 
-func (m mockStore) Set(id string, alerts []api.Alert) error {
+func (m mockStore) Set(ctx context.Context, id string, alerts []api.Alert) error {
 	return m.setFn(id, alerts)
 }
 
-func (m mockStore) List() ([]api.MessageEntry, error) {
+func (m mockStore) List(ctx context.Context) ([]api.MessageEntry, error) {
 	return m.listFn()
 }
+
+func (m mockStore) Iterate(ctx context.Context, fn func(api.MessageEntry) error) error {
+	entries, err := m.listFn()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m mockStore) Delete(ctx context.Context, id string) error {
+	return m.deleteFn(id)
+}
+
+func (m mockStore) Close() error {
+	return nil
+}
+
+func TestOpenStoreDefaultBackend(t *testing.T) {
+	s, err := openStore(storeOptions{}, log.NewNopLogger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, ok := s.(*store.InMemoryStore); !ok {
+		t.Fatalf("wanted %T got %T", &store.InMemoryStore{}, s)
+	}
+
+	s, err = openStore(storeOptions{path: t.TempDir()}, log.NewNopLogger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, ok := s.(*store.KeyValueStore); !ok {
+		t.Fatalf("wanted %T got %T", &store.KeyValueStore{}, s)
+	}
+
+	if _, err := openStore(storeOptions{backend: memoryBackend, path: t.TempDir()}, log.NewNopLogger(), nil); err == nil {
+		t.Fatal("expected the memory backend to reject a path")
+	}
+}
+
+// blockingStore never completes an operation before its context is done
+type blockingStore struct {
+	store.Store
+}
+
+func (b blockingStore) Get(ctx context.Context, id string) ([]api.Alert, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (b blockingStore) Set(ctx context.Context, id string, alerts []api.Alert) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (b blockingStore) List(ctx context.Context) ([]api.MessageEntry, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (b blockingStore) Iterate(ctx context.Context, fn func(api.MessageEntry) error) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestStoreTimeout(t *testing.T) {
+	srv := &server{
+		router:       mux.NewRouter(),
+		logger:       log.NewNopLogger(),
+		store:        blockingStore{store.NewInMemStore()},
+		idGenerator:  buildIdGenerator(defaultStoreIDTemplate),
+		storeTimeout: 10 * time.Millisecond,
+	}
+	srv.routes()
+
+	for _, tc := range []struct {
+		method, path string
+		body         io.Reader
+	}{
+		{method: http.MethodGet, path: "/history/Test_webhook"},
+		{method: http.MethodGet, path: "/history"},
+		{method: http.MethodGet, path: "/history?status=firing"},
+		{method: http.MethodDelete, path: "/history"},
+		{method: http.MethodPost, path: "/webhook", body: getSamplePayload(t)},
+	} {
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, tc.body))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("%s %s: wanted %d got %d", tc.method, tc.path, http.StatusServiceUnavailable, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), "history store overloaded") || w.Header().Get("Retry-After") == "" {
+			t.Fatalf("%s %s: unexpected response %v %s", tc.method, tc.path, w.Header(), w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+)
+
+// runMigrate implements the migrate subcommand, upgrading the database of a stopped receiver to the latest schema version
+func runMigrate(args []string, out io.Writer) int {
+	var (
+		opts   storeOptions
+		dryRun bool
+	)
+	flagset := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flagset.SetOutput(out)
+	opts.registerOffline(flagset, "'badger', 'bolt', 'redis', 'sqlite'")
+	flagset.BoolVar(&dryRun, "dry-run", false, "Report the pending migrations without writing to the store")
+	if err := flagset.Parse(args); err != nil {
+		return 2
+	}
+	if opts.path == "" {
+		fmt.Fprintln(out, "-db.path is required")
+		return 2
+	}
+	if opts.backend == "" {
+		opts.backend = badgerBackend
+	}
+
+	s, err := openStore(opts, log.NewNopLogger(), nil)
+	if err != nil {
+		fmt.Fprintf(out, "failed to open store: %v\n", err)
+		return 1
+	}
+	defer s.Close()
+	m, ok := s.(store.Migrator)
+	if !ok {
+		fmt.Fprintf(out, "the %s store backend has no schema to migrate\n", opts.backend)
+		return 1
+	}
+
+	ctx := context.Background()
+	version, err := m.SchemaVersion(ctx)
+	if err != nil {
+		fmt.Fprintf(out, "failed to read schema version: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "schema version %d\n", version)
+
+	results, err := m.Migrate(ctx, dryRun)
+	for _, r := range results {
+		verb := "applied"
+		if dryRun {
+			verb = "would apply"
+		}
+		fmt.Fprintf(out, "%s migration %d: %s (%d entries)\n", verb, r.Version, r.Description, r.Entries)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "failed to migrate store: %v\n", err)
+		return 1
+	}
+	if len(results) == 0 {
+		fmt.Fprintln(out, "schema is up to date")
+	}
+	return 0
+}
+
+// migrateStore applies the pending migrations of s when it persists a schema version
+func migrateStore(ctx context.Context, s store.Store, logger log.Logger) error {
+	m, ok := s.(store.Migrator)
+	if !ok {
+		return nil
+	}
+	results, err := m.Migrate(ctx, false)
+	for _, r := range results {
+		level.Info(logger).Log("msg", "migrated history store", "version", r.Version, "migration", r.Description, "entries", r.Entries)
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+)
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	s, err := store.NewSQLStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(context.Background(), "any", []api.Alert{{Status: "firing"}}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// a database created before the schema version was recorded
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`PRAGMA user_version = 0`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	for _, tc := range []struct {
+		args   []string
+		expect string
+	}{
+		{args: []string{"-dry-run"}, expect: "would apply migration 1"},
+		{expect: "applied migration 1"},
+		{expect: "schema is up to date"},
+	} {
+		var out bytes.Buffer
+		args := append([]string{"-db.path", path, "-store.backend", sqliteBackend}, tc.args...)
+		if code := runMigrate(args, &out); code != 0 {
+			t.Fatalf("expected migrate to succeed but got exit code %d\n%s", code, out.String())
+		}
+		if !strings.Contains(out.String(), tc.expect) {
+			t.Fatalf("wanted %q in output got %q", tc.expect, out.String())
+		}
+	}
+}
+
+func TestMigrateUnsupported(t *testing.T) {
+	var out bytes.Buffer
+	if code := runMigrate([]string{}, &out); code != 2 {
+		t.Fatalf("wanted exit code 2 without -db.path got %d", code)
+	}
+	out.Reset()
+	if code := runMigrate([]string{"-db.path", "any", "-store.backend", memoryBackend}, &out); code != 1 {
+		t.Fatalf("wanted exit code 1 for the memory backend got %d\n%s", code, out.String())
+	}
+}
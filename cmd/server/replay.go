@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/recorder"
+)
+
+const defaultReplayTimeout = 10 * time.Second
+
+// runReplay implements the replay subcommand. It re-sends a recording made with -record.file to a
+// webhook URL, either preserving the original inter-arrival times or at an accelerated rate.
+// The exit code is non-zero if any request fails or is answered with a non-2xx status.
+func runReplay(args []string, out io.Writer) int {
+	var file, target string
+	var speed float64
+	var keepPath bool
+	var timeout time.Duration
+	headers := headerFlag{}
+	flagset := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flagset.SetOutput(out)
+	flagset.StringVar(&file, "file", "", "The recording to replay")
+	flagset.StringVar(&target, "url", "", "The webhook URL each recorded request is sent to")
+	flagset.BoolVar(&keepPath, "keep-path", false, "Append the recorded path and query to -url rather than sending every request to -url as is")
+	flagset.Float64Var(&speed, "speed", 1, "Scales the recorded inter-arrival times. 1 (default) preserves the original timing, 10 replays ten times faster and 0 sends requests back to back")
+	flagset.DurationVar(&timeout, "timeout", defaultReplayTimeout, "The timeout for each replayed request")
+	flagset.Var(headers, "header", "A 'Name: value' header set on every replayed request, such as the credentials redacted in the recording. May be repeated")
+	if err := flagset.Parse(args); err != nil {
+		return 2
+	}
+	if file == "" || target == "" {
+		fmt.Fprintln(out, "both -file and -url are required")
+		return 2
+	}
+	if speed < 0 {
+		fmt.Fprintln(out, "-speed must not be negative")
+		return 2
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Fprintf(out, "failed to open recording: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	replayer := &recorder.Replayer{
+		Client:   &http.Client{Timeout: timeout},
+		Target:   target,
+		KeepPath: keepPath,
+		Speed:    speed,
+		Header:   http.Header(headers),
+	}
+	return replay(ctx, replayer, recorder.NewReader(f), out)
+}
+
+// headerFlag collects repeated 'Name: value' flags into a header
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	return ""
+}
+
+func (h headerFlag) Set(value string) error {
+	kv := strings.SplitN(value, ":", 2)
+	if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+		return fmt.Errorf("expected 'Name: value' but got %q", value)
+	}
+	http.Header(h).Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	return nil
+}
+
+func replay(ctx context.Context, replayer *recorder.Replayer, entries *recorder.Reader, out io.Writer) int {
+	var sent, failed int
+	err := replayer.Replay(ctx, entries, func(res recorder.Result) {
+		sent++
+		switch {
+		case res.Err != nil:
+			failed++
+			fmt.Fprintf(out, "FAIL %s %s: %v\n", res.Entry.Method, res.Entry.Path, res.Err)
+		case res.Status < 200 || res.Status > 299:
+			failed++
+			fmt.Fprintf(out, "FAIL %s %s: %d %s\n", res.Entry.Method, res.Entry.Path, res.Status, http.StatusText(res.Status))
+		default:
+			fmt.Fprintf(out, "OK   %s %s: %d\n", res.Entry.Method, res.Entry.Path, res.Status)
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(out, "replay stopped after %d sent: %v\n", sent, err)
+		return 1
+	}
+
+	fmt.Fprintf(out, "%d sent, %d failed\n", sent, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/recorder"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/tenant"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "recording.ndjson")
+	rec, err := recorder.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recording := &server{
+		router:      mux.NewRouter(),
+		logger:      log.NewNopLogger(),
+		store:       mockStore{setFn: func(id string, alerts []api.Alert) error { return nil }},
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+		recorder:    rec,
+	}
+	recording.routes()
+	ts := httptest.NewServer(recording.router)
+	t.Cleanup(ts.Close)
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Post(ts.URL+"/webhook", "application/json", getSamplePayload(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	resp, err := http.Post(ts.URL+"/webhook", "application/json", strings.NewReader("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	rec.Close()
+
+	var stored []string
+	replayed := &server{
+		router: mux.NewRouter(),
+		logger: log.NewNopLogger(),
+		store: mockStore{setFn: func(id string, alerts []api.Alert) error {
+			stored = append(stored, id)
+			return nil
+		}},
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+	}
+	replayed.routes()
+	target := httptest.NewServer(replayed.router)
+	t.Cleanup(target.Close)
+
+	var out bytes.Buffer
+	code := runReplay([]string{"-file", file, "-url", target.URL, "-keep-path", "-speed", "0"}, &out)
+	if code != 1 {
+		t.Fatalf("expected the invalid payload to fail replay but got exit code %d\n%s", code, out.String())
+	}
+	expect := `OK   POST /webhook: 200
+OK   POST /webhook: 200
+FAIL POST /webhook: 400 Bad Request
+3 sent, 1 failed
+`
+	if out.String() != expect {
+		t.Fatalf("wanted\n%s\ngot\n%s", expect, out.String())
+	}
+	if len(stored) != 2 || stored[0] != "Test_webhook" {
+		t.Fatalf("expected replayed notifications to be stored but got %v", stored)
+	}
+}
+
+func TestReplayRedactedCredentials(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "recording.ndjson")
+	rec, err := recorder.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenants := &tenant.Config{Tenants: []tenant.Tenant{{Name: "team-a", Token: "secret"}}}
+
+	recording := &server{
+		router:      mux.NewRouter(),
+		logger:      log.NewNopLogger(),
+		store:       store.NewInMemStore(),
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+		tenants:     tenant.NewRegistry(tenants),
+		recorder:    rec,
+	}
+	recording.routes()
+	ts := httptest.NewServer(recording.router)
+	t.Cleanup(ts.Close)
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/t/team-a/webhook", getSamplePayload(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	rec.Close()
+
+	replayed := &server{
+		router:      mux.NewRouter(),
+		logger:      log.NewNopLogger(),
+		store:       store.NewInMemStore(),
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+		tenants:     tenant.NewRegistry(tenants),
+	}
+	replayed.routes()
+	target := httptest.NewServer(replayed.router)
+	t.Cleanup(target.Close)
+
+	var out bytes.Buffer
+	if code := runReplay([]string{"-file", file, "-url", target.URL, "-keep-path", "-speed", "0"}, &out); code != 1 {
+		t.Fatalf("expected the redacted token to be refused but got exit code %d\n%s", code, out.String())
+	}
+	if expect := "FAIL POST /t/team-a/webhook: 401 Unauthorized\n"; !strings.HasPrefix(out.String(), expect) {
+		t.Fatalf("wanted\n%s\ngot\n%s", expect, out.String())
+	}
+
+	out.Reset()
+	code := runReplay([]string{"-file", file, "-url", target.URL, "-keep-path", "-speed", "0", "-header", "Authorization: Bearer secret"}, &out)
+	if code != 0 {
+		t.Fatalf("expected the supplied token to be accepted but got exit code %d\n%s", code, out.String())
+	}
+	if _, err := store.Namespace(replayed.store, "team-a").Get(context.Background(), "Test_webhook"); err != nil {
+		t.Fatalf("expected the replayed notification to be stored for the tenant but got %v", err)
+	}
+}
+
+func TestReplayUsage(t *testing.T) {
+	var out bytes.Buffer
+	if code := runReplay([]string{"-file", "recording.ndjson"}, &out); code != 2 {
+		t.Fatalf("expected usage error but got exit code %d", code)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+)
+
+// runRotateKey implements the rotate-key subcommand, re-encrypting the database of a stopped receiver with a new key
+func runRotateKey(args []string, out io.Writer) int {
+	var path, backend, redisPrefix, oldKeyFile, newKeyFile string
+	flagset := flag.NewFlagSet("rotate-key", flag.ContinueOnError)
+	flagset.SetOutput(out)
+	flagset.StringVar(&path, "db.path", "", "The file path to the history store of a stopped receiver, or the server URL for the redis backend")
+	flagset.StringVar(&backend, "store.backend", defaultStoreBackend, "The history store backend used with -db.path. One of 'badger', 'bolt', 'redis'. Empty (default) uses 'badger'")
+	flagset.StringVar(&redisPrefix, "redis.prefix", store.DefaultRedisPrefix, "The prefix of every key written by the redis store backend")
+	flagset.StringVar(&oldKeyFile, "old-key-file", "", "A file holding the key the store is encrypted with. Empty (default) encrypts a plaintext store")
+	flagset.StringVar(&newKeyFile, "new-key-file", "", "A file holding the key the store is encrypted with from now on")
+	if err := flagset.Parse(args); err != nil {
+		return 2
+	}
+	if path == "" || newKeyFile == "" {
+		fmt.Fprintln(out, "-db.path and -new-key-file are required")
+		return 2
+	}
+
+	var oldKey []byte
+	if oldKeyFile != "" {
+		var err error
+		if oldKey, err = store.LoadEncryptionKey(oldKeyFile); err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+	}
+	newKey, err := store.LoadEncryptionKey(newKeyFile)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+
+	switch backend {
+	case "", badgerBackend:
+		err = store.RotateKeyValueStoreKey(path, oldKey, newKey)
+	case boltBackend:
+		err = store.RotateBoltStoreKey(path, oldKey, newKey)
+	case redisBackend:
+		err = store.RotateRedisStoreKey(context.Background(), path, redisPrefix, oldKey, newKey)
+	default:
+		fmt.Fprintf(out, "the %s store backend does not support key rotation, export and import the store instead\n", backend)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(out, "failed to rotate key: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(out, "rotated encryption key")
+	return 0
+}
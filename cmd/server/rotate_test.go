@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+)
+
+func TestRotateKey(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, newKey := filepath.Join(dir, "old.key"), filepath.Join(dir, "new.key")
+	if err := os.WriteFile(oldKey, []byte("0123456789abcdef\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(newKey, []byte("fedcba9876543210\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "history.db")
+	s, err := store.NewEncryptedBoltStore(path, []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(context.Background(), "any", []api.Alert{{Status: "firing"}}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	var out bytes.Buffer
+	args := []string{"-db.path", path, "-store.backend", boltBackend, "-old-key-file", oldKey, "-new-key-file", newKey}
+	if code := runRotateKey(args, &out); code != 0 {
+		t.Fatalf("expected rotation to succeed but got exit code %d\n%s", code, out.String())
+	}
+
+	out.Reset()
+	if code := runExport([]string{"-db.path", path, "-store.backend", boltBackend, "-encryption.key-file", newKey}, &out); code != 0 {
+		t.Fatalf("expected export with the new key to succeed but got exit code %d\n%s", code, out.String())
+	}
+	if !strings.Contains(out.String(), `"firing"`) {
+		t.Fatalf("expected export to hold the decrypted alerts but got %s", out.String())
+	}
+	out.Reset()
+	if code := runExport([]string{"-db.path", path, "-store.backend", boltBackend, "-encryption.key-file", oldKey}, &out); code != 1 {
+		t.Fatalf("expected export with the old key to fail but got exit code %d\n%s", code, out.String())
+	}
+}
+
+func TestOpenStoreEncryptionUnsupported(t *testing.T) {
+	key := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(key, []byte("0123456789abcdef"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, backend := range []string{memoryBackend, sqliteBackend} {
+		if s, err := openStore(storeOptions{backend: backend, encryptionKeyFile: key}, nil, nil); err == nil {
+			s.Close()
+			t.Fatalf("expected the %s backend to reject an encryption key", backend)
+		}
+	}
+}
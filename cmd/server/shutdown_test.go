@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/forward"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+)
+
+// heldStore holds each Set until release is closed, keeping the requests saving a notification in flight
+type heldStore struct {
+	store.Store
+	once    sync.Once
+	arrived chan struct{}
+	release chan struct{}
+}
+
+func (b *heldStore) Set(ctx context.Context, id string, alerts []api.Alert) error {
+	b.once.Do(func() { close(b.arrived) })
+	<-b.release
+	return b.Store.Set(ctx, id, alerts)
+}
+
+func TestShutdownDrainsRequestsAndClosesStore(t *testing.T) {
+	dir := t.TempDir()
+	kvStore, err := store.NewKeyValueStore(dir, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocking := &heldStore{Store: kvStore, arrived: make(chan struct{}), release: make(chan struct{})}
+	srv := &server{
+		router:      mux.NewRouter(),
+		logger:      log.NewNopLogger(),
+		store:       blocking,
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.serve(l) }()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	url := "http://" + l.Addr().String() + "/webhook"
+	inFlight := make(chan int, 1)
+	go func() {
+		resp, err := client.Post(url, "application/json", getSamplePayload(t))
+		if err != nil {
+			inFlight <- 0
+			return
+		}
+		resp.Body.Close()
+		inFlight <- resp.StatusCode
+	}()
+	<-blocking.arrived
+
+	closed := make(chan error, 1)
+	go func() { closed <- srv.close(context.Background()) }()
+
+	// requests accepted before the listener closes are held by the store too, so probe from another goroutine
+	refused := make(chan struct{})
+	go func() {
+		defer close(refused)
+		for {
+			resp, err := client.Post(url, "application/json", getSamplePayload(t))
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	select {
+	case <-refused:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected new requests to be refused during shutdown")
+	}
+
+	close(blocking.release)
+	if status := <-inFlight; status != http.StatusOK {
+		t.Fatalf("expected in-flight request to complete but got %d", status)
+	}
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != http.ErrServerClosed {
+		t.Fatalf("wanted %v got %v", http.ErrServerClosed, err)
+	}
+
+	reopened, err := store.NewKeyValueStore(dir, log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("expected database to reopen after shutdown but got %v", err)
+	}
+	defer reopened.Close()
+	if _, err := reopened.Get(context.Background(), "Test_webhook"); err != nil {
+		t.Fatalf("expected the in-flight notification to be persisted but got %v", err)
+	}
+}
+
+func TestShutdownCancelsForwarding(t *testing.T) {
+	// a downstream that never responds keeps the forward in progress until it is cancelled
+	arrived := make(chan struct{})
+	var once sync.Once
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the connection is only watched for the client going away once the body is read
+		io.Copy(io.Discard, r.Body)
+		once.Do(func() { close(arrived) })
+		<-r.Context().Done()
+	}))
+	t.Cleanup(downstream.Close)
+
+	cfg, err := forward.Parse([]byte(fmt.Sprintf("targets: [{url: '%s'}]", downstream.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := store.NewInMemStore()
+	srv := &server{
+		router:      mux.NewRouter(),
+		logger:      log.NewNopLogger(),
+		store:       history,
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+		forwards:    forward.NewQueue(forward.NewForwarder(cfg), 1, 1),
+	}
+	srv.routes()
+
+	// the response does not wait for the downstream
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook", getSamplePayload(t)))
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 response but got %d", w.Result().StatusCode)
+	}
+	<-arrived
+
+	closed := make(chan error, 1)
+	go func() { closed <- srv.close(context.Background()) }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected shutdown to cancel the forward in progress")
+	}
+
+	outcomes, err := history.Forwards(context.Background(), "Test_webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outcomes) != 1 || !strings.Contains(outcomes[0].Error, context.Canceled.Error()) {
+		t.Fatalf("expected the cancelled forward to be saved but got %v", outcomes)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/dedup"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/lifecycle"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/tenant"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/timing"
+)
+
+func TestTenants(t *testing.T) {
+	payload, err := os.ReadFile("testdata/request.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &server{
+		router:      mux.NewRouter(),
+		logger:      log.NewNopLogger(),
+		store:       store.NewInMemStore(),
+		idGenerator: buildIdGenerator(defaultStoreIDTemplate),
+		tenants:     tenant.NewRegistry(&tenant.Config{Tenants: []tenant.Tenant{{Name: "team-a", Token: "secret"}}}),
+		lifecycle:   lifecycle.NewTracker(0),
+		timing:      timing.NewAnalyzer(timing.Expectations{}, 0),
+		dedup:       dedup.NewDetector(time.Minute, nil),
+	}
+	srv.routes()
+
+	do := func(method, path string, header map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		header map[string]string
+		expect int
+	}{
+		{name: "default", path: "/webhook", expect: http.StatusOK},
+		{name: "path", path: "/t/team-a/webhook", header: map[string]string{"Authorization": "Bearer secret"}, expect: http.StatusOK},
+		{name: "header", path: "/webhook", header: map[string]string{tenant.Header: "team-b"}, expect: http.StatusOK},
+		{name: "missing token", path: "/t/team-a/webhook", expect: http.StatusUnauthorized},
+		{name: "wrong token", path: "/webhook", header: map[string]string{tenant.Header: "team-a", "Authorization": "Bearer wrong"}, expect: http.StatusUnauthorized},
+		{name: "invalid tenant", path: "/webhook", header: map[string]string{tenant.Header: "team/a"}, expect: http.StatusBadRequest},
+	} {
+		if w := do(http.MethodPost, tc.path, tc.header); w.Code != tc.expect {
+			t.Fatalf("%s: wanted %d got %d: %s", tc.name, tc.expect, w.Code, w.Body.String())
+		}
+	}
+
+	list := func(path string, header map[string]string) []api.MessageEntry {
+		t.Helper()
+		w := do(http.MethodGet, path, header)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: wanted %d got %d", path, http.StatusOK, w.Code)
+		}
+		var entries []api.MessageEntry
+		if err := json.NewDecoder(w.Body).Decode(&entries); err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+	// each tenant holds its own copy of the same ID
+	for _, tc := range []struct {
+		path   string
+		header map[string]string
+	}{
+		{path: "/history"},
+		{path: "/t/team-a/history", header: map[string]string{"Authorization": "Bearer secret"}},
+		{path: "/history", header: map[string]string{tenant.Header: "team-b"}},
+		{path: "/t/team-b/history"},
+	} {
+		if entries := list(tc.path, tc.header); len(entries) != 1 || entries[0].ID != "Test_webhook" {
+			t.Fatalf("%s %v: expected a single entry but got %v", tc.path, tc.header, entries)
+		}
+	}
+	if w := do(http.MethodGet, "/t/team-a/history/Test_webhook", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wanted %d got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := do(http.MethodGet, "/t/team-c/history/Test_webhook", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("wanted %d got %d", http.StatusNotFound, w.Code)
+	}
+
+	// reports only hold the notifications of the tenant
+	timelines := func(path string, header map[string]string) []lifecycle.Timeline {
+		t.Helper()
+		w := do(http.MethodGet, path, header)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: wanted %d got %d", path, http.StatusOK, w.Code)
+		}
+		var out []lifecycle.Timeline
+		if err := json.NewDecoder(w.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	if tl := timelines("/t/team-b/alerts", nil); len(tl) == 0 {
+		t.Fatal("expected the tenant to have alert timelines")
+	}
+	if tl := timelines("/t/team-c/alerts", nil); len(tl) != 0 {
+		t.Fatalf("expected a tenant without notifications to have no timelines but got %v", tl)
+	}
+	if w := do(http.MethodGet, "/t/team-a/alerts", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wanted %d got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	// the admin endpoints span every tenant so need the admin token once any tenant is authenticated
+	if w := do(http.MethodGet, "/admin/export", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("wanted %d got %d", http.StatusForbidden, w.Code)
+	}
+	srv.adminToken = "admin"
+	if w := do(http.MethodGet, "/admin/export", map[string]string{"Authorization": "Bearer secret"}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wanted %d got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := do(http.MethodGet, "/admin/export", map[string]string{"Authorization": "Bearer admin"}); w.Code != http.StatusOK {
+		t.Fatalf("wanted %d got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// an ID holding the tenant separator must not address the keys of another tenant, nor one holding a NUL
+	// the keys a store keeps for itself
+	for _, path := range []string{
+		"/history/%1Fteam-a%1FTest_webhook",
+		"/t/team-b/history/%1Fteam-a%1FTest_webhook",
+		"/history/%00forwards%00Test_webhook",
+	} {
+		if w := do(http.MethodGet, path, nil); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: wanted %d got %d: %s", path, http.StatusBadRequest, w.Code, w.Body.String())
+		}
+	}
+	forged := &server{
+		router:      mux.NewRouter(),
+		logger:      log.NewNopLogger(),
+		store:       srv.store,
+		idGenerator: buildIdGenerator("\x1fteam-a\x1f{{ .Receiver }}"),
+		tenants:     srv.tenants,
+	}
+	forged.routes()
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(payload))
+	w := httptest.NewRecorder()
+	forged.router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("wanted %d got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+	if entries := list("/t/team-a/history", map[string]string{"Authorization": "Bearer secret"}); len(entries) != 1 {
+		t.Fatalf("expected the other tenant to be left unchanged but got %v", entries)
+	}
+
+	w = do(http.MethodDelete, "/t/team-b/history", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "{\"deleted\":1}\n" {
+		t.Fatalf("unexpected reset response %d %s", w.Code, w.Body.String())
+	}
+	if entries := list("/t/team-b/history", nil); len(entries) != 0 {
+		t.Fatalf("expected the reset tenant to be empty but got %v", entries)
+	}
+	if entries := list("/history", nil); len(entries) != 1 {
+		t.Fatalf("expected the default tenant to be kept but got %v", entries)
+	}
+
+	// the reports of the reset tenant are cleared too
+	for _, path := range []string{"/alerts", "/timing/groups"} {
+		var reset, kept []json.RawMessage
+		for _, into := range []struct {
+			path   string
+			values *[]json.RawMessage
+		}{{"/t/team-b" + path, &reset}, {path, &kept}} {
+			if err := json.NewDecoder(do(http.MethodGet, into.path, nil).Body).Decode(into.values); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(reset) != 0 || len(kept) == 0 {
+			t.Fatalf("%s: expected only the reset tenant to be cleared but got %d and %d", path, len(reset), len(kept))
+		}
+	}
+}
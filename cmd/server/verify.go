@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/lifecycle"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/routing"
+)
+
+const defaultVerifyURL = "http://localhost:8080"
+
+// runVerify implements the verify subcommand. It computes the receivers and group keys each test alert
+// is expected to be notified with from an Alertmanager configuration and compares them against what
+// a running receiver recorded. The exit code is non-zero if any alert fails verification.
+func runVerify(args []string, out io.Writer) int {
+	var configFile, alertsFile, receiverURL string
+	flagset := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flagset.SetOutput(out)
+	flagset.StringVar(&configFile, "config.file", "", "The Alertmanager configuration file")
+	flagset.StringVar(&alertsFile, "alerts.file", "", "A YAML or JSON file holding a list of test alert label sets")
+	flagset.StringVar(&receiverURL, "url", defaultVerifyURL, "The base URL of the receiver that recorded the notifications")
+	if err := flagset.Parse(args); err != nil {
+		return 2
+	}
+	if configFile == "" || alertsFile == "" {
+		fmt.Fprintln(out, "both -config.file and -alerts.file are required")
+		return 2
+	}
+
+	b, err := os.ReadFile(configFile)
+	if err != nil {
+		fmt.Fprintf(out, "failed to read configuration: %v\n", err)
+		return 2
+	}
+	root, err := routing.Parse(b)
+	if err != nil {
+		fmt.Fprintf(out, "failed to load configuration: %v\n", err)
+		return 2
+	}
+
+	b, err = os.ReadFile(alertsFile)
+	if err != nil {
+		fmt.Fprintf(out, "failed to read test alerts: %v\n", err)
+		return 2
+	}
+	var alerts []map[string]string
+	if err := yaml.Unmarshal(b, &alerts); err != nil {
+		fmt.Fprintf(out, "failed to parse test alerts: %v\n", err)
+		return 2
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	results, err := verify(root, alerts, func(lset map[string]string) ([]lifecycle.Timeline, error) {
+		return fetchTimelines(client, receiverURL, lset)
+	})
+	if err != nil {
+		fmt.Fprintf(out, "failed to verify: %v\n", err)
+		return 2
+	}
+
+	failed := 0
+	for _, r := range results {
+		fmt.Fprintln(out, r)
+		if !r.pass() {
+			failed++
+		}
+	}
+	fmt.Fprintf(out, "%d passed, %d failed\n", len(results)-failed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// verifyResult compares the expected and recorded destinations of a single test alert
+type verifyResult struct {
+	labels     map[string]string
+	missing    []routing.Destination
+	unexpected []routing.Destination
+}
+
+func (v verifyResult) pass() bool {
+	return len(v.missing) == 0 && len(v.unexpected) == 0
+}
+
+func (v verifyResult) String() string {
+	var b strings.Builder
+	status := "PASS"
+	if !v.pass() {
+		status = "FAIL"
+	}
+	fmt.Fprintf(&b, "%s %s", status, labelSelector(v.labels))
+	for _, d := range v.missing {
+		fmt.Fprintf(&b, "\n  missing:    %s", d)
+	}
+	for _, d := range v.unexpected {
+		fmt.Fprintf(&b, "\n  unexpected: %s", d)
+	}
+	return b.String()
+}
+
+func verify(root *routing.Route, alerts []map[string]string, timelines func(map[string]string) ([]lifecycle.Timeline, error)) ([]verifyResult, error) {
+	var results []verifyResult
+	for _, lset := range alerts {
+		expected := make(map[routing.Destination]bool)
+		for _, d := range root.Destinations(lset) {
+			expected[d] = true
+		}
+
+		recorded, err := timelines(lset)
+		if err != nil {
+			return nil, err
+		}
+		actual := make(map[routing.Destination]bool)
+		for _, tl := range recorded {
+			// the matchers also select alerts with more labels than the test alert, which are routed on their own
+			if !reflect.DeepEqual(tl.Labels, lset) {
+				continue
+			}
+			for _, n := range tl.Notifications {
+				// notifications without a group key were not sent by an Alertmanager webhook receiver
+				if n.GroupKey == "" {
+					continue
+				}
+				actual[routing.Destination{Receiver: n.Receiver, GroupKey: n.GroupKey}] = true
+			}
+		}
+
+		result := verifyResult{labels: lset}
+		for d := range expected {
+			if !actual[d] {
+				result.missing = append(result.missing, d)
+			}
+		}
+		for d := range actual {
+			if !expected[d] {
+				result.unexpected = append(result.unexpected, d)
+			}
+		}
+		sortDestinations(result.missing)
+		sortDestinations(result.unexpected)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// fetchTimelines reads the timelines of alerts carrying at least the labels in lset from the receiver
+func fetchTimelines(client *http.Client, base string, lset map[string]string) ([]lifecycle.Timeline, error) {
+	u := strings.TrimSuffix(base, "/") + "/alerts?matchers=" + url.QueryEscape(labelSelector(lset))
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s from %s", resp.Status, u)
+	}
+	var timelines []lifecycle.Timeline
+	if err := json.NewDecoder(resp.Body).Decode(&timelines); err != nil {
+		return nil, fmt.Errorf("failed to decode alert timelines: %w", err)
+	}
+	return timelines, nil
+}
+
+func labelSelector(lset map[string]string) string {
+	names := make([]string, 0, len(lset))
+	for name := range lset {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+strconv.Quote(lset[name]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortDestinations(ds []routing.Destination) {
+	sort.Slice(ds, func(i, j int) bool { return ds[i].String() < ds[j].String() })
+}
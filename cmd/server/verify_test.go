@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/lifecycle"
+)
+
+func TestVerify(t *testing.T) {
+	srv := &server{
+		router: mux.NewRouter(),
+		logger: log.NewNopLogger(),
+		store: mockStore{
+			setFn: func(id string, alerts []api.Alert) error { return nil },
+		},
+		idGenerator:  buildIdGenerator(defaultStoreIDTemplate),
+		lifecycle:    lifecycle.NewTracker(0),
+		apiV2Enabled: true,
+	}
+	srv.routes()
+	ts := httptest.NewServer(srv.router)
+	t.Cleanup(ts.Close)
+
+	resp, err := http.Post(ts.URL+"/webhook", "application/json", getSamplePayload(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// an alert with more labels than a test alert is not one of its notifications
+	var msg api.Message
+	if err := json.NewDecoder(getSamplePayload(t)).Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	msg.Receiver = "other"
+	msg.Alerts[0].Labels["extra"] = "label"
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.Post(ts.URL+"/webhook", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// nor is the same alert pushed by a ruler
+	resp, err = http.Post(ts.URL+"/api/v2/alerts", "application/json", strings.NewReader(`[{"labels":{"alertname":"Test","job":"prometheus24","severity":"critical"}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	var out bytes.Buffer
+	code := runVerify([]string{
+		"-config.file", "testdata/alertmanager.yml",
+		"-alerts.file", "testdata/alerts.yml",
+		"-url", ts.URL,
+	}, &out)
+
+	if code != 1 {
+		t.Fatalf("expected verification to fail but got exit code %d\n%s", code, out.String())
+	}
+	expect := `PASS {alertname="Test",dc="eu-west-1",instance="localhost:9090",job="prometheus24"}
+FAIL {alertname="Test",job="prometheus24",severity="critical"}
+  missing:    critical {}/{severity="critical"}:{alertname="Test", job="prometheus24"}
+1 passed, 1 failed
+`
+	if out.String() != expect {
+		t.Fatalf("wanted\n%s\ngot\n%s", expect, out.String())
+	}
+}
+
+func TestVerifyUsage(t *testing.T) {
+	var out bytes.Buffer
+	if code := runVerify(nil, &out); code != 2 {
+		t.Fatalf("expected usage error but got exit code %d", code)
+	}
+	if !strings.Contains(out.String(), "-config.file and -alerts.file are required") {
+		t.Fatalf("unexpected output %s", out.String())
+	}
+}
@@ -47,6 +47,25 @@ type MessageResponse struct {
 type MessageEntry struct {
 	ID     string  `json:"id"`
 	Alerts []Alert `json:"alerts"`
+	// Forwards are the outcomes of forwarding the notifications saved under ID, only set in exports
+	Forwards []ForwardOutcome `json:"forwards,omitempty"`
+}
+
+// ForwardOutcome is the result of forwarding a notification to a single downstream webhook
+type ForwardOutcome struct {
+	Time     time.Time `json:"time"`
+	Target   string    `json:"target"`
+	URL      string    `json:"url"`
+	Attempts int       `json:"attempts"`
+	// Status is the status code of the final attempt, zero if no response was received
+	Status   int    `json:"status,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Success reports whether the target accepted the notification
+func (o ForwardOutcome) Success() bool {
+	return o.Error == ""
 }
 
 func (m Message) String() string {
@@ -56,3 +75,13 @@ func (m Message) String() string {
 	}
 	return string(b)
 }
+
+// PostableAlert is a single entry of the POST request body for the Alertmanager API v2 /api/v2/alerts endpoint and maps to
+// https://github.com/prometheus/alertmanager/blob/c0a7b75c9cfb0772bdf5ec7362775f5f7798a3a0/api/v2/models/postable_alert.go#L34
+type PostableAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt,omitempty"`
+	EndsAt       time.Time         `json:"endsAt,omitempty"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
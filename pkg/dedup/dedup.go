@@ -0,0 +1,196 @@
+// Package dedup detects duplicate notifications, which is how a broken gossip mesh between
+// Alertmanager peers in HA mode shows up to a receiver.
+package dedup
+
+import (
+	"container/list"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/lifecycle"
+)
+
+const (
+	// maxDuplicates bounds the number of duplicated notifications kept for the API
+	maxDuplicates = 1000
+	// maxSeen bounds the number of notifications remembered within the window, forgetting the oldest first
+	maxSeen = 100000
+)
+
+// Delivery is a single receipt of a notification
+type Delivery struct {
+	Source     string    `json:"source"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// Duplicate is a notification that was delivered more than once within the window
+type Duplicate struct {
+	GroupKey string `json:"groupKey"`
+	Receiver string `json:"receiver"`
+	// Alerts are the fingerprint and status of each alert in the notification
+	Alerts     []string   `json:"alerts"`
+	Deliveries []Delivery `json:"deliveries"`
+}
+
+type entry struct {
+	tenant    string
+	key       string
+	first     time.Time
+	duplicate *Duplicate
+}
+
+// tenantDuplicate is a duplicate kept for the API of a tenant
+type tenantDuplicate struct {
+	tenant    string
+	duplicate *Duplicate
+}
+
+// Detector flags notifications whose group key and set of alerts were already received within a window,
+// separately for each tenant. The status of each alert is part of the set so that a resolved notification
+// is not a duplicate of the firing notification for the same alerts. It is safe for concurrent use.
+type Detector struct {
+	mu     sync.Mutex
+	window time.Duration
+	seen   map[string]*list.Element
+	// order holds the seen entries in the order they were first received, so that expired ones are at the front
+	order      *list.List
+	duplicates []tenantDuplicate
+	totals     map[string]int
+
+	notifications *prometheus.CounterVec
+	duplicated    *prometheus.CounterVec
+}
+
+// NewDetector returns a Detector and registers its metrics with reg when it is not nil
+func NewDetector(window time.Duration, reg prometheus.Registerer) *Detector {
+	d := &Detector{
+		window: window,
+		seen:   make(map[string]*list.Element),
+		order:  list.New(),
+		totals: make(map[string]int),
+		notifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webhook_notifications_total",
+			Help: "Total number of Alertmanager notifications received.",
+		}, []string{"receiver"}),
+		duplicated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webhook_duplicate_notifications_total",
+			Help: "Total number of Alertmanager notifications received more than once within the duplicate detection window.",
+		}, []string{"receiver"}),
+	}
+	if reg != nil {
+		reg.MustRegister(d.notifications, d.duplicated)
+	}
+	return d
+}
+
+// Observe records a notification for tenant delivered from source and reports whether it is a duplicate
+func (d *Detector) Observe(tenant string, msg api.Message, source string, receivedAt time.Time) bool {
+	alerts := make([]string, 0, len(msg.Alerts))
+	for _, a := range msg.Alerts {
+		alerts = append(alerts, lifecycle.Fingerprint(a)+"="+a.Status)
+	}
+	sort.Strings(alerts)
+	key := tenant + "\x00" + msg.Receiver + "\x00" + msg.GroupKey + "\x00" + strings.Join(alerts, ",")
+	delivery := Delivery{Source: source, ReceivedAt: receivedAt}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.notifications.WithLabelValues(msg.Receiver).Inc()
+	d.expire(receivedAt)
+
+	el, ok := d.seen[key]
+	if !ok {
+		d.seen[key] = d.order.PushBack(&entry{
+			tenant: tenant,
+			key:    key,
+			first:  receivedAt,
+			duplicate: &Duplicate{
+				GroupKey:   msg.GroupKey,
+				Receiver:   msg.Receiver,
+				Alerts:     alerts,
+				Deliveries: []Delivery{delivery},
+			},
+		})
+		if d.order.Len() > maxSeen {
+			d.forget(d.order.Front())
+		}
+		return false
+	}
+	e := el.Value.(*entry)
+
+	if len(e.duplicate.Deliveries) == 1 {
+		d.duplicates = append(d.duplicates, tenantDuplicate{tenant: tenant, duplicate: e.duplicate})
+		if len(d.duplicates) > maxDuplicates {
+			d.duplicates = d.duplicates[len(d.duplicates)-maxDuplicates:]
+		}
+	}
+	e.duplicate.Deliveries = append(e.duplicate.Deliveries, delivery)
+	d.totals[tenant]++
+	d.duplicated.WithLabelValues(msg.Receiver).Inc()
+	return true
+}
+
+// expire forgets notifications first received before the window. Notifications are observed in the
+// order they are received, so only the expired ones are visited.
+func (d *Detector) expire(now time.Time) {
+	for el := d.order.Front(); el != nil && now.Sub(el.Value.(*entry).first) > d.window; el = d.order.Front() {
+		d.forget(el)
+	}
+}
+
+func (d *Detector) forget(el *list.Element) {
+	d.order.Remove(el)
+	delete(d.seen, el.Value.(*entry).key)
+}
+
+// Reset forgets the notifications and duplicates of tenant
+func (d *Detector) Reset(tenant string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for el := d.order.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*entry).tenant == tenant {
+			d.forget(el)
+		}
+		el = next
+	}
+	kept := d.duplicates[:0]
+	for _, dup := range d.duplicates {
+		if dup.tenant != tenant {
+			kept = append(kept, dup)
+		}
+	}
+	d.duplicates = kept
+	delete(d.totals, tenant)
+}
+
+// Total returns the number of duplicate deliveries seen for tenant
+func (d *Detector) Total(tenant string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.totals[tenant]
+}
+
+// Duplicates returns the most recent duplicated notifications of tenant with every delivery of each
+func (d *Detector) Duplicates(tenant string) []Duplicate {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	out := []Duplicate{}
+	for _, dup := range d.duplicates {
+		if dup.tenant != tenant {
+			continue
+		}
+		c := *dup.duplicate
+		c.Deliveries = append([]Delivery(nil), dup.duplicate.Deliveries...)
+		out = append(out, c)
+	}
+	return out
+}
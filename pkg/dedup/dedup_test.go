@@ -0,0 +1,96 @@
+package dedup
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+func TestDetector(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	d := NewDetector(30*time.Second, reg)
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	firing := api.Message{
+		Receiver: "webhook",
+		GroupKey: `{}:{alertname="Test"}`,
+		Alerts: []api.Alert{
+			{Status: "firing", Labels: map[string]string{"alertname": "Test", "instance": "a"}},
+			{Status: "firing", Labels: map[string]string{"alertname": "Test", "instance": "b"}},
+		},
+	}
+	reordered := firing
+	reordered.Alerts = []api.Alert{firing.Alerts[1], firing.Alerts[0]}
+	resolved := firing
+	resolved.Alerts = []api.Alert{firing.Alerts[0], {Status: "resolved", Labels: firing.Alerts[1].Labels}}
+
+	if d.Observe("", firing, "10.0.0.1:1234", start) {
+		t.Fatal("expected first delivery not to be a duplicate")
+	}
+	if !d.Observe("", reordered, "10.0.0.2:1234", start.Add(time.Second)) {
+		t.Fatal("expected delivery from a second peer to be a duplicate")
+	}
+	if d.Observe("", resolved, "10.0.0.1:1234", start.Add(2*time.Second)) {
+		t.Fatal("expected change in status not to be a duplicate")
+	}
+	if d.Observe("", firing, "10.0.0.1:1234", start.Add(time.Minute)) {
+		t.Fatal("expected delivery outside the window not to be a duplicate")
+	}
+
+	if d.Total("") != 1 {
+		t.Fatalf("expected 1 duplicate but got %d", d.Total(""))
+	}
+	dups := d.Duplicates("")
+	if len(dups) != 1 || len(dups[0].Deliveries) != 2 || dups[0].Deliveries[1].Source != "10.0.0.2:1234" {
+		t.Fatalf("unexpected duplicates %v", dups)
+	}
+	if d.Observe("team-a", firing, "10.0.0.2:1234", start.Add(time.Minute+time.Second)) {
+		t.Fatal("expected delivery to another tenant not to be a duplicate")
+	}
+	if d.Total("team-a") != 0 || len(d.Duplicates("team-a")) != 0 {
+		t.Fatalf("expected another tenant to have no duplicates but got %v", d.Duplicates("team-a"))
+	}
+
+	if got := testutil.ToFloat64(d.duplicated.WithLabelValues("webhook")); got != 1 {
+		t.Fatalf("expected duplicate metric to be 1 but got %v", got)
+	}
+	if got := testutil.ToFloat64(d.notifications.WithLabelValues("webhook")); got != 5 {
+		t.Fatalf("expected notifications metric to be 5 but got %v", got)
+	}
+
+	d.Reset("")
+	if d.Total("") != 0 || len(d.Duplicates("")) != 0 {
+		t.Fatalf("expected reset duplicates to be forgotten but got %v", d.Duplicates(""))
+	}
+	if d.Observe("", firing, "10.0.0.2:1234", start.Add(time.Minute+2*time.Second)) {
+		t.Fatal("expected delivery after a reset not to be a duplicate")
+	}
+	if !d.Observe("team-a", firing, "10.0.0.1:1234", start.Add(time.Minute+3*time.Second)) {
+		t.Fatal("expected another tenant to be kept over a reset")
+	}
+}
+
+func TestDetector_Forgets(t *testing.T) {
+	d := NewDetector(30*time.Second, nil)
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i <= maxSeen; i++ {
+		d.Observe("", api.Message{Receiver: "webhook", GroupKey: fmt.Sprint(i)}, "10.0.0.1", start)
+	}
+	if len(d.seen) != maxSeen || d.order.Len() != maxSeen {
+		t.Fatalf("wanted %v got %v", maxSeen, len(d.seen))
+	}
+	if d.Observe("", api.Message{Receiver: "webhook", GroupKey: "0"}, "10.0.0.2", start) {
+		t.Fatal("expected the oldest notification to be forgotten over the limit")
+	}
+
+	d.Observe("", api.Message{Receiver: "webhook", GroupKey: "later"}, "10.0.0.1", start.Add(time.Minute))
+	if len(d.seen) != 1 || d.order.Len() != 1 {
+		t.Fatalf("expected notifications outside the window to be forgotten but %d are remembered", len(d.seen))
+	}
+}
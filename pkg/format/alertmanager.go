@@ -0,0 +1,84 @@
+package format
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+// AlertmanagerName is the name of the Alertmanager webhook format
+const AlertmanagerName = "alertmanager"
+
+// DefaultMaxSkew is the clock difference between Alertmanager and the receiver allowed by strict validation
+const DefaultMaxSkew = 5 * time.Second
+
+// Alertmanager is the format of the Alertmanager webhook_configs integration.
+// The store ID of each message is built with the provided IDGenerator.
+// In strict mode unknown fields are rejected and each message is checked with ValidateMessage.
+type Alertmanager struct {
+	// MaxSkew is the allowed difference between the end time of an alert and now when checking it is consistent with its status
+	MaxSkew     time.Duration
+	idGenerator IDGenerator
+	strict      bool
+	now         func() time.Time
+}
+
+// NewAlertmanager returns the Alertmanager webhook format allowing DefaultMaxSkew
+func NewAlertmanager(idGenerator IDGenerator, strict bool) *Alertmanager {
+	return &Alertmanager{MaxSkew: DefaultMaxSkew, idGenerator: idGenerator, strict: strict, now: time.Now}
+}
+
+func (a *Alertmanager) Name() string { return AlertmanagerName }
+
+func (a *Alertmanager) Decode(r *http.Request) (Notification, error) {
+	var into api.Message
+	dec := json.NewDecoder(r.Body)
+	if a.strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(&into); err != nil {
+		return nil, Errorf(http.StatusBadRequest, "failed to decode JSON body: %w", err)
+	}
+	return &into, nil
+}
+
+func (a *Alertmanager) Validate(n Notification) error {
+	msg, ok := n.(*api.Message)
+	if !ok {
+		return Errorf(http.StatusInternalServerError, "unexpected notification type %T", n)
+	}
+	if !a.strict {
+		return nil
+	}
+	if errs := ValidateMessage(*msg, a.now(), a.MaxSkew); len(errs) > 0 {
+		return &Error{Status: http.StatusBadRequest, Err: errs}
+	}
+	return nil
+}
+
+func (a *Alertmanager) Normalize(n Notification) ([]Record, error) {
+	msg := n.(*api.Message)
+	id, err := a.idGenerator(*msg)
+	if err != nil {
+		return nil, Errorf(http.StatusInternalServerError, "failed to generate ID from request body: %w", err)
+	}
+	return []Record{{ID: id, Alerts: msg.Alerts, Message: msg}}, nil
+}
+
+func (a *Alertmanager) WriteResponse(w http.ResponseWriter, records []Record, err error) {
+	if err != nil {
+		writeTextError(w, err, "failed to save webhook info")
+		return
+	}
+
+	resp, err := json.Marshal(api.MessageResponse{ID: records[0].ID})
+	if err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
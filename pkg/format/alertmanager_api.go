@@ -0,0 +1,161 @@
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+// AlertmanagerAPIName is the name of the Alertmanager API v2 alerts format
+const AlertmanagerAPIName = "alertmanager-api-v2"
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// AlertmanagerAPI stands in for the Alertmanager API v2 /api/v2/alerts endpoint that Prometheus and
+// Thanos Ruler push to. Alerts in a request are grouped by alertname and each group is converted into an
+// api.Message for the configured receiver so that it can be stored using the same IDGenerator as webhook notifications.
+type AlertmanagerAPI struct {
+	idGenerator IDGenerator
+	receiver    string
+	now         func() time.Time
+}
+
+// NewAlertmanagerAPI returns the Alertmanager API v2 alerts format
+func NewAlertmanagerAPI(idGenerator IDGenerator, receiver string) *AlertmanagerAPI {
+	return &AlertmanagerAPI{idGenerator: idGenerator, receiver: receiver, now: time.Now}
+}
+
+func (a *AlertmanagerAPI) Name() string { return AlertmanagerAPIName }
+
+func (a *AlertmanagerAPI) Decode(r *http.Request) (Notification, error) {
+	var into []api.PostableAlert
+	if err := json.NewDecoder(r.Body).Decode(&into); err != nil {
+		return nil, Errorf(http.StatusBadRequest, "failed to decode JSON body: %w", err)
+	}
+	return into, nil
+}
+
+// Validate applies the same checks as the Alertmanager API
+func (a *AlertmanagerAPI) Validate(n Notification) error {
+	for i, alert := range n.([]api.PostableAlert) {
+		if err := validatePostableAlert(alert); err != nil {
+			return Errorf(http.StatusBadRequest, "invalid alert at index %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (a *AlertmanagerAPI) Normalize(n Notification) ([]Record, error) {
+	var records []Record
+	for _, msg := range messagesFromPostableAlerts(n.([]api.PostableAlert), a.receiver, a.now().UTC()) {
+		msg := msg
+		id, err := a.idGenerator(msg)
+		if err != nil {
+			return nil, Errorf(http.StatusInternalServerError, "failed to generate ID from request body: %w", err)
+		}
+		records = append(records, Record{ID: id, Alerts: msg.Alerts, Message: &msg})
+	}
+	return records, nil
+}
+
+func (a *AlertmanagerAPI) WriteResponse(w http.ResponseWriter, records []Record, err error) {
+	if err != nil {
+		writeTextError(w, err, "failed to save alerts")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func validatePostableAlert(a api.PostableAlert) error {
+	if len(a.Labels) == 0 {
+		return fmt.Errorf("at least one label pair required")
+	}
+	for name, value := range a.Labels {
+		if !labelNameRE.MatchString(name) {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+		if value == "" {
+			return fmt.Errorf("empty value for label %q", name)
+		}
+	}
+	if !a.StartsAt.IsZero() && !a.EndsAt.IsZero() && a.EndsAt.Before(a.StartsAt) {
+		return fmt.Errorf("start time must be before end time")
+	}
+	return nil
+}
+
+// messagesFromPostableAlerts groups alerts by alertname into messages that look like a webhook notification
+// for the provided receiver. Start and end times are defaulted in the same way Alertmanager does on ingest.
+func messagesFromPostableAlerts(alerts []api.PostableAlert, receiver string, now time.Time) []api.Message {
+	groups := make(map[string][]api.Alert)
+	for _, a := range alerts {
+		alert := api.Alert{
+			Status:       "firing",
+			Labels:       a.Labels,
+			Annotations:  a.Annotations,
+			StartsAt:     a.StartsAt,
+			EndsAt:       a.EndsAt,
+			GeneratorURL: a.GeneratorURL,
+		}
+		if alert.StartsAt.IsZero() {
+			alert.StartsAt = now
+		}
+		if !alert.EndsAt.IsZero() && !alert.EndsAt.After(now) {
+			alert.Status = "resolved"
+		}
+		name := a.Labels["alertname"]
+		groups[name] = append(groups[name], alert)
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	messages := make([]api.Message, 0, len(groups))
+	for _, name := range names {
+		msg := api.Message{
+			Version:           "4",
+			Receiver:          receiver,
+			Status:            "resolved",
+			Alerts:            groups[name],
+			GroupLabels:       map[string]string{"alertname": name},
+			CommonLabels:      commonKV(groups[name], func(a api.Alert) map[string]string { return a.Labels }),
+			CommonAnnotations: commonKV(groups[name], func(a api.Alert) map[string]string { return a.Annotations }),
+		}
+		for _, a := range msg.Alerts {
+			if a.Status == "firing" {
+				msg.Status = "firing"
+				break
+			}
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// commonKV returns the key/value pairs shared by all alerts
+func commonKV(alerts []api.Alert, kv func(api.Alert) map[string]string) map[string]string {
+	common := make(map[string]string)
+	if len(alerts) == 0 {
+		return common
+	}
+	for k, v := range kv(alerts[0]) {
+		common[k] = v
+	}
+	for _, a := range alerts[1:] {
+		m := kv(a)
+		for k, v := range common {
+			if m[k] != v {
+				delete(common, k)
+			}
+		}
+	}
+	return common
+}
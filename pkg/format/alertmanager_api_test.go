@@ -0,0 +1,65 @@
+package format
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+func testIDGenerator(t *testing.T, tmpl string) IDGenerator {
+	t.Helper()
+	parsed := template.Must(template.New("test").Parse(tmpl))
+	return func(payload api.Message) (string, error) {
+		var b strings.Builder
+		err := parsed.Execute(&b, payload)
+		return b.String(), err
+	}
+}
+
+func TestAlertmanagerAPI(t *testing.T) {
+	f := NewAlertmanagerAPI(testIDGenerator(t, `{{ .GroupLabels.alertname }}_{{ .Receiver }}`), "api-v2")
+	body := `[
+  {"labels":{"alertname":"Test","job":"prometheus24"},"annotations":{"description":"some description"},"startsAt":"2018-08-03T09:52:26.739266876+02:00"},
+  {"labels":{"alertname":"Test","job":"node"},"startsAt":"2018-08-03T09:52:26.739266876+02:00","endsAt":"2018-08-03T10:52:26.739266876+02:00"},
+  {"labels":{"alertname":"Other"}}
+]`
+	req := httptest.NewRequest(http.MethodPost, "/api/v2/alerts", strings.NewReader(body))
+	w, records := serve(t, f, "/api/v2/alerts", req)
+
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 response but got %d", w.Result().StatusCode)
+	}
+	if len(records) != 2 || records[0].ID != "Other_api-v2" || records[1].ID != "Test_api-v2" {
+		t.Fatalf("unexpected records %v", records)
+	}
+	if records[0].Alerts[0].StartsAt.IsZero() {
+		t.Fatal("expected start time to be defaulted")
+	}
+
+	test := records[1]
+	if len(test.Alerts) != 2 || test.Alerts[0].Status != "firing" || test.Alerts[1].Status != "resolved" {
+		t.Fatalf("unexpected alerts %v", test.Alerts)
+	}
+	if test.Message.Status != "firing" || len(test.Message.CommonLabels) != 1 || test.Message.CommonLabels["alertname"] != "Test" {
+		t.Fatalf("unexpected message %v", test.Message)
+	}
+}
+
+func TestAlertmanagerAPIValidation(t *testing.T) {
+	f := NewAlertmanagerAPI(testIDGenerator(t, `{{ .Receiver }}`), "api-v2")
+	for _, body := range []string{
+		`[{"labels":{}}]`,
+		`[{"labels":{"0invalid":"x"}}]`,
+		`[{"labels":{"alertname":"Test"},"startsAt":"2018-08-03T10:00:00Z","endsAt":"2018-08-03T09:00:00Z"}]`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v2/alerts", strings.NewReader(body))
+		w, _ := serve(t, f, "/api/v2/alerts", req)
+		if w.Result().StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected 400 response for %s but got %d", body, w.Result().StatusCode)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package format
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAlertmanagerStrict(t *testing.T) {
+	path := "/webhook"
+	f := NewAlertmanager(testIDGenerator(t, `{{ .Receiver }}`), true)
+
+	body := `{"version":"4","groupKey":"{}:{}","receiver":"webhook","status":"firing","alerts":[
+  {"status":"firing","labels":{"alertname":"Test"},"startsAt":"2018-08-03T09:52:26.739266876+02:00","fingerprint":"abc"}
+]}`
+	w, records := serve(t, f, path, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 response but got %d %s", w.Result().StatusCode, w.Body.String())
+	}
+	if len(records) != 1 || records[0].ID != "webhook" {
+		t.Fatalf("unexpected records %v", records)
+	}
+
+	unknown := strings.Replace(body, `"version":"4"`, `"version":"4","unknown":true`, 1)
+	w, _ = serve(t, f, path, httptest.NewRequest(http.MethodPost, path, strings.NewReader(unknown)))
+	if w.Result().StatusCode != http.StatusBadRequest || !strings.Contains(w.Body.String(), "unknown field") {
+		t.Fatalf("expected unknown field to be rejected but got %d %s", w.Result().StatusCode, w.Body.String())
+	}
+
+	invalid := `{"version":"3","receiver":"webhook","status":"firing"}`
+	w, _ = serve(t, f, path, httptest.NewRequest(http.MethodPost, path, strings.NewReader(invalid)))
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 response but got %d", w.Result().StatusCode)
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 4 || lines[0] != "invalid payload:" {
+		t.Fatalf("expected each validation error on its own line but got %q", w.Body.String())
+	}
+
+	lenient := NewAlertmanager(testIDGenerator(t, `{{ .Receiver }}`), false)
+	w, _ = serve(t, lenient, path, httptest.NewRequest(http.MethodPost, path, strings.NewReader(invalid)))
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected non strict mode to accept the payload but got %d", w.Result().StatusCode)
+	}
+}
@@ -0,0 +1,180 @@
+// Package format defines the inbound notification formats accepted by the receiver.
+// A Format decodes a request, validates it, normalizes it into records for the store and
+// writes the response the sender expects.
+package format
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+// Format is implemented by each inbound notification format
+type Format interface {
+	// Name identifies the format in configuration
+	Name() string
+	// Decode reads a notification from the request
+	Decode(r *http.Request) (Notification, error)
+	// Validate checks a decoded notification before it is normalized
+	Validate(n Notification) error
+	// Normalize converts a valid notification into one or more records to be stored
+	Normalize(n Notification) ([]Record, error)
+	// WriteResponse writes the response for the stored records or, when err is not nil, the error response
+	WriteResponse(w http.ResponseWriter, records []Record, err error)
+}
+
+// Notification is a decoded request body. Its concrete type is specific to the Format that decoded it.
+type Notification interface{}
+
+// Record is the normalized form of a notification that is saved to the store
+type Record struct {
+	ID     string
+	Alerts []api.Alert
+	// Message is the Alertmanager message the record was built from, if any
+	Message *api.Message
+}
+
+// IDGenerator builds the store ID for an Alertmanager message
+type IDGenerator func(payload api.Message) (string, error)
+
+// Error carries the HTTP status a Format should respond with along with a format specific code
+type Error struct {
+	Status int
+	Code   string
+	Err    error
+}
+
+// Errorf returns an Error with the provided status and a formatted message
+func Errorf(status int, format string, args ...interface{}) *Error {
+	return &Error{Status: status, Err: fmt.Errorf(format, args...)}
+}
+
+// WithCode sets the format specific error code
+func (e *Error) WithCode(code string) *Error {
+	e.Code = code
+	return e
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// StatusCode returns the HTTP status for err defaulting to 500 for errors that are not an Error
+func StatusCode(err error) int {
+	var fe *Error
+	if errors.As(err, &fe) {
+		return fe.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// Route binds a Format to a path
+type Route struct {
+	Path   string
+	Format Format
+}
+
+// Registry holds the available formats and the paths they are served on
+type Registry struct {
+	formats map[string]Format
+	routes  []Route
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{formats: make(map[string]Format)}
+}
+
+// Register adds f to the registry and serves it on each of the provided paths
+func (r *Registry) Register(f Format, paths ...string) error {
+	if _, ok := r.formats[f.Name()]; ok {
+		return fmt.Errorf("format %q is already registered", f.Name())
+	}
+	r.formats[f.Name()] = f
+	for _, p := range paths {
+		if err := r.Bind(p, f.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bind serves the named format on path
+func (r *Registry) Bind(path, name string) error {
+	f, ok := r.formats[name]
+	if !ok {
+		return fmt.Errorf("unknown format %q, must be one of %v", name, r.Names())
+	}
+	for _, route := range r.routes {
+		if route.Path == path {
+			return fmt.Errorf("path %q is already bound to format %q", path, route.Format.Name())
+		}
+	}
+	r.routes = append(r.routes, Route{Path: path, Format: f})
+	return nil
+}
+
+// Get returns the named format
+func (r *Registry) Get(name string) (Format, bool) {
+	f, ok := r.formats[name]
+	return f, ok
+}
+
+// Names returns the sorted names of all registered formats
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.formats))
+	for name := range r.formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Routes returns the bound paths in the order they were bound
+func (r *Registry) Routes() []Route {
+	return r.routes
+}
+
+func validToken(expect, got string) bool {
+	if got == "" {
+		return false
+	}
+	return expect == "" || subtle.ConstantTimeCompare([]byte(expect), []byte(got)) == 1
+}
+
+// writeTextError responds with the message of an Error, or fallback for any other error.
+// Each problem in a ValidationError is written on its own line.
+func writeTextError(w http.ResponseWriter, err error, fallback string) {
+	msg := fallback
+	if fe, ok := err.(*Error); ok {
+		msg = fe.Error()
+		if v, ok := fe.Err.(ValidationError); ok {
+			msg = "invalid payload:\n" + strings.Join(v, "\n")
+		}
+	}
+	http.Error(w, msg, StatusCode(err))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,71 @@
+package format
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// serve runs req through f in the same order as the server and returns the response along with the normalized records
+func serve(t *testing.T, f Format, path string, req *http.Request) (*httptest.ResponseRecorder, []Record) {
+	t.Helper()
+	var records []Record
+	router := mux.NewRouter()
+	router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		n, err := f.Decode(r)
+		if err == nil {
+			err = f.Validate(n)
+		}
+		if err == nil {
+			records, err = f.Normalize(n)
+		}
+		f.WriteResponse(w, records, err)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w, records
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	if err := r.Register(NewAlertmanager(nil, false), "/webhook"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(NewWebex(""), "/v1/messages"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Register(NewAlertmanager(nil, false)); err == nil {
+		t.Fatal("expected duplicate format name to be rejected")
+	}
+	if err := r.Bind("/webhook", WebexName); err == nil {
+		t.Fatal("expected duplicate path to be rejected")
+	}
+	if err := r.Bind("/other", "unknown"); err == nil {
+		t.Fatal("expected unknown format to be rejected")
+	}
+	if err := r.Bind("/other", WebexName); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := r.Get(WebexName); !ok {
+		t.Fatal("expected webex format to be registered")
+	}
+	if names := r.Names(); len(names) != 2 || names[0] != AlertmanagerName || names[1] != WebexName {
+		t.Fatalf("unexpected names %v", names)
+	}
+
+	routes := r.Routes()
+	expect := []string{"/webhook", "/v1/messages", "/other"}
+	if len(routes) != len(expect) {
+		t.Fatalf("wanted %d routes got %d", len(expect), len(routes))
+	}
+	for i, route := range routes {
+		if route.Path != expect[i] {
+			t.Fatalf("wanted route %s got %s", expect[i], route.Path)
+		}
+	}
+}
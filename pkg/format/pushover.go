@@ -0,0 +1,144 @@
+package format
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+// PushoverName is the name of the Pushover messages API format
+const PushoverName = "pushover"
+
+// Pushover stands in for the Pushover messages API used by Alertmanager pushover_configs.
+// Alertmanager sends the parameters in the query string while other clients use a form body.
+// The rendered message is saved as a single api.Alert under the ID pushover_{user}.
+type Pushover struct {
+	token string
+}
+
+// NewPushover returns the Pushover format accepting the provided application token.
+// An empty token accepts any non-empty token.
+func NewPushover(token string) *Pushover {
+	return &Pushover{token: token}
+}
+
+type pushoverRequest struct {
+	request string
+	form    url.Values
+}
+
+type pushoverResponse struct {
+	Status  int      `json:"status"`
+	Request string   `json:"request"`
+	Receipt string   `json:"receipt,omitempty"`
+	Errors  []string `json:"errors,omitempty"`
+	Token   string   `json:"token,omitempty"`
+	User    string   `json:"user,omitempty"`
+}
+
+// pushoverError collects every parameter error so they can all be reported in the response
+type pushoverError struct {
+	pushoverResponse
+}
+
+func (e *pushoverError) Error() string { return strings.Join(e.Errors, ", ") }
+
+func (p *Pushover) Name() string { return PushoverName }
+
+func (p *Pushover) Decode(r *http.Request) (Notification, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Err: &pushoverError{pushoverResponse{
+			Request: randomID(),
+			Errors:  []string{"request is invalid"},
+		}}}
+	}
+	return &pushoverRequest{request: randomID(), form: r.Form}, nil
+}
+
+func (p *Pushover) Validate(n Notification) error {
+	req := n.(*pushoverRequest)
+	resp := pushoverResponse{Request: req.request}
+	if !validToken(p.token, req.form.Get("token")) {
+		resp.Token = "invalid"
+		resp.Errors = append(resp.Errors, "application token is invalid")
+	}
+	if req.form.Get("user") == "" {
+		resp.User = "invalid"
+		resp.Errors = append(resp.Errors, "user identifier is invalid")
+	}
+	if req.form.Get("message") == "" {
+		resp.Errors = append(resp.Errors, "message cannot be blank")
+	}
+
+	priority, err := pushoverPriority(req.form)
+	if err != nil || priority < -2 || priority > 2 {
+		resp.Errors = append(resp.Errors, "priority is invalid")
+	}
+	if priority == 2 {
+		if retry, err := strconv.Atoi(req.form.Get("retry")); err != nil || retry < 30 {
+			resp.Errors = append(resp.Errors, "retry must be at least 30 seconds for emergency priority")
+		}
+		if expire, err := strconv.Atoi(req.form.Get("expire")); err != nil || expire > 10800 {
+			resp.Errors = append(resp.Errors, "expire must be at most 10800 seconds for emergency priority")
+		}
+	}
+
+	if len(resp.Errors) > 0 {
+		return &Error{Status: http.StatusBadRequest, Err: &pushoverError{resp}}
+	}
+	return nil
+}
+
+func (p *Pushover) Normalize(n Notification) ([]Record, error) {
+	req := n.(*pushoverRequest)
+	priority, _ := pushoverPriority(req.form)
+	alert := api.Alert{
+		Labels: map[string]string{
+			IntegrationLabel: PushoverName,
+			"user":           req.form.Get("user"),
+			"priority":       strconv.Itoa(priority),
+			"request":        req.request,
+		},
+		Annotations: map[string]string{MessageAnnotation: req.form.Get("message")},
+		StartsAt:    time.Now().UTC(),
+	}
+	for _, name := range []string{"title", "url", "url_title", "sound", "html", "retry", "expire", "ttl", "device"} {
+		if v := req.form.Get(name); v != "" {
+			alert.Labels[name] = v
+		}
+	}
+	return []Record{{ID: "pushover_" + req.form.Get("user"), Alerts: []api.Alert{alert}}}, nil
+}
+
+func (p *Pushover) WriteResponse(w http.ResponseWriter, records []Record, err error) {
+	if err != nil {
+		var pe *pushoverError
+		if fe, ok := err.(*Error); ok {
+			pe, _ = fe.Err.(*pushoverError)
+		}
+		if pe == nil {
+			pe = &pushoverError{pushoverResponse{Request: randomID(), Errors: []string{err.Error()}}}
+		}
+		writeJSON(w, StatusCode(err), pe.pushoverResponse)
+		return
+	}
+
+	alert := records[0].Alerts[0]
+	resp := pushoverResponse{Status: 1, Request: alert.Labels["request"]}
+	if alert.Labels["priority"] == "2" {
+		resp.Receipt = randomID()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func pushoverPriority(form url.Values) (int, error) {
+	p := form.Get("priority")
+	if p == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(p)
+}
@@ -0,0 +1,47 @@
+package format
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPushover(t *testing.T) {
+	f := NewPushover("app")
+	path := "/1/messages.json"
+
+	params := url.Values{}
+	params.Set("token", "app")
+	params.Set("user", "user")
+	params.Set("message", "disk full")
+	params.Set("priority", "2")
+	req := httptest.NewRequest(http.MethodPost, path+"?"+params.Encode(), nil)
+	w, _ := serve(t, f, path, req)
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected emergency priority without retry to be rejected but got %d", w.Result().StatusCode)
+	}
+	if !strings.Contains(w.Body.String(), "retry must be at least 30 seconds") {
+		t.Fatalf("unexpected json response %s", w.Body.String())
+	}
+
+	params.Set("retry", "30")
+	params.Set("expire", "3600")
+	req = httptest.NewRequest(http.MethodPost, path, strings.NewReader(params.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w, records := serve(t, f, path, req)
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 response but got %d", w.Result().StatusCode)
+	}
+	if !strings.Contains(w.Body.String(), `"status":1`) || !strings.Contains(w.Body.String(), `"receipt"`) {
+		t.Fatalf("expected receipt for emergency priority %s", w.Body.String())
+	}
+	if len(records) != 1 || records[0].ID != "pushover_user" {
+		t.Fatalf("unexpected records %v", records)
+	}
+	alert := records[0].Alerts[0]
+	if alert.Labels["priority"] != "2" || alert.Annotations[MessageAnnotation] != "disk full" {
+		t.Fatalf("unexpected alert %v", alert)
+	}
+}
@@ -0,0 +1,193 @@
+package format
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/sigv4"
+)
+
+// SNSName is the name of the AWS SNS query API format
+const SNSName = "sns"
+
+const snsXMLNamespace = "https://sns.amazonaws.com/doc/2010-03-31/"
+
+// SNS stands in for the AWS SNS query API used by Alertmanager sns_configs.
+// Only the Publish action is supported. The message is saved as a single api.Alert under the ID
+// sns_{topic name} with the subject and message as annotations and each message attribute as an
+// "attribute_" prefixed label.
+type SNS struct {
+	verifier *sigv4.Verifier
+}
+
+// NewSNS returns the SNS format. When verifier is not nil requests must carry a valid SigV4 signature.
+func NewSNS(verifier *sigv4.Verifier) *SNS {
+	return &SNS{verifier: verifier}
+}
+
+type snsRequest struct {
+	requestID string
+	form      url.Values
+}
+
+type snsPublishResponse struct {
+	XMLName   xml.Name `xml:"PublishResponse"`
+	Namespace string   `xml:"xmlns,attr"`
+	MessageID string   `xml:"PublishResult>MessageId"`
+	RequestID string   `xml:"ResponseMetadata>RequestId"`
+}
+
+type snsErrorResponse struct {
+	XMLName   xml.Name `xml:"ErrorResponse"`
+	Namespace string   `xml:"xmlns,attr"`
+	Type      string   `xml:"Error>Type"`
+	Code      string   `xml:"Error>Code"`
+	Message   string   `xml:"Error>Message"`
+	RequestID string   `xml:"RequestId"`
+}
+
+func (s *SNS) Name() string { return SNSName }
+
+func (s *SNS) Decode(r *http.Request) (Notification, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, Errorf(http.StatusBadRequest, "failed to read request body: %w", err).WithCode("MalformedQueryString")
+	}
+
+	if s.verifier != nil {
+		if err := s.verifier.Verify(r, body); err != nil {
+			return nil, Errorf(http.StatusForbidden, "%w", err).WithCode("SignatureDoesNotMatch")
+		}
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err := r.ParseForm(); err != nil {
+		return nil, Errorf(http.StatusBadRequest, "%w", err).WithCode("MalformedQueryString")
+	}
+	return &snsRequest{requestID: randomID(), form: r.Form}, nil
+}
+
+func (s *SNS) Validate(n Notification) error {
+	form := n.(*snsRequest).form
+	if action := form.Get("Action"); action != "Publish" {
+		return Errorf(http.StatusBadRequest, "The action %s is not valid for this web service.", action).WithCode("InvalidAction")
+	}
+
+	target, _ := snsTarget(form)
+	switch {
+	case target == "":
+		return Errorf(http.StatusBadRequest, "Invalid parameter: TopicArn or TargetArn Reason: no value for required parameter").WithCode("InvalidParameter")
+	case form.Get("Message") == "":
+		return Errorf(http.StatusBadRequest, "Invalid parameter: Empty message").WithCode("InvalidParameter")
+	case len(form.Get("Subject")) > 100:
+		return Errorf(http.StatusBadRequest, "Invalid parameter: Subject Reason: must be less than 100 characters long").WithCode("InvalidParameter")
+	}
+
+	if _, err := snsMessageAttributes(form); err != nil {
+		return Errorf(http.StatusBadRequest, "%w", err).WithCode("ParameterValueInvalid")
+	}
+	return nil
+}
+
+func (s *SNS) Normalize(n Notification) ([]Record, error) {
+	req := n.(*snsRequest)
+	target, targetLabel := snsTarget(req.form)
+	attributes, err := snsMessageAttributes(req.form)
+	if err != nil {
+		return nil, err
+	}
+
+	alert := api.Alert{
+		Labels: map[string]string{
+			IntegrationLabel: SNSName,
+			targetLabel:      target,
+			"request_id":     req.requestID,
+		},
+		Annotations: map[string]string{MessageAnnotation: req.form.Get("Message")},
+		StartsAt:    time.Now().UTC(),
+	}
+	if subject := req.form.Get("Subject"); subject != "" {
+		alert.Annotations["subject"] = subject
+	}
+	for name, value := range attributes {
+		alert.Labels["attribute_"+name] = value
+	}
+
+	id := "sns_" + target[strings.LastIndex(target, ":")+1:]
+	return []Record{{ID: id, Alerts: []api.Alert{alert}}}, nil
+}
+
+func (s *SNS) WriteResponse(w http.ResponseWriter, records []Record, err error) {
+	if err != nil {
+		resp := snsErrorResponse{
+			Namespace: snsXMLNamespace,
+			Type:      "Sender",
+			Code:      "InternalError",
+			Message:   "failed to save message",
+			RequestID: randomID(),
+		}
+		status := StatusCode(err)
+		if fe, ok := err.(*Error); ok {
+			resp.Code, resp.Message = fe.Code, fe.Error()
+		}
+		if status >= http.StatusInternalServerError {
+			resp.Type = "Receiver"
+		}
+		writeXML(w, status, resp)
+		return
+	}
+
+	writeXML(w, http.StatusOK, snsPublishResponse{
+		Namespace: snsXMLNamespace,
+		MessageID: randomID(),
+		RequestID: records[0].Alerts[0].Labels["request_id"],
+	})
+}
+
+// snsTarget returns the destination of a Publish request and the label used to record it
+func snsTarget(form url.Values) (string, string) {
+	if v := form.Get("TopicArn"); v != "" {
+		return v, "topic_arn"
+	}
+	if v := form.Get("TargetArn"); v != "" {
+		return v, "target_arn"
+	}
+	return form.Get("PhoneNumber"), "phone_number"
+}
+
+// snsMessageAttributes reads the MessageAttributes.entry.N.* parameters of a Publish request
+func snsMessageAttributes(form url.Values) (map[string]string, error) {
+	attributes := make(map[string]string)
+	for i := 1; i <= len(form); i++ {
+		prefix := fmt.Sprintf("MessageAttributes.entry.%d.", i)
+		name := form.Get(prefix + "Name")
+		if name == "" {
+			break
+		}
+
+		dataType := form.Get(prefix + "Value.DataType")
+		switch {
+		case strings.HasPrefix(dataType, "String"), strings.HasPrefix(dataType, "Number"):
+			attributes[name] = form.Get(prefix + "Value.StringValue")
+		case strings.HasPrefix(dataType, "Binary"):
+			attributes[name] = form.Get(prefix + "Value.BinaryValue")
+		default:
+			return nil, fmt.Errorf("The message attribute '%s' has an invalid message attribute type %q", name, dataType)
+		}
+	}
+	return attributes, nil
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
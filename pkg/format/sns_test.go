@@ -0,0 +1,87 @@
+package format
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/sigv4"
+)
+
+func snsPublishBody() string {
+	params := url.Values{}
+	params.Set("Action", "Publish")
+	params.Set("Version", "2010-03-31")
+	params.Set("TopicArn", "arn:aws:sns:us-east-1:123456789012:critical")
+	params.Set("Subject", "[FIRING:1] Test")
+	params.Set("Message", "some description")
+	params.Set("MessageAttributes.entry.1.Name", "severity")
+	params.Set("MessageAttributes.entry.1.Value.DataType", "String")
+	params.Set("MessageAttributes.entry.1.Value.StringValue", "critical")
+	return params.Encode()
+}
+
+func TestSNS(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(snsPublishBody()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	w, records := serve(t, NewSNS(nil), "/", req)
+
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 response but got %d %s", w.Result().StatusCode, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "<PublishResponse") || !strings.Contains(w.Body.String(), "<MessageId>") {
+		t.Fatalf("unexpected xml response %s", w.Body.String())
+	}
+	if len(records) != 1 || records[0].ID != "sns_critical" {
+		t.Fatalf("unexpected records %v", records)
+	}
+
+	alert := records[0].Alerts[0]
+	expect := api.Alert{
+		Labels: map[string]string{
+			IntegrationLabel:     SNSName,
+			"topic_arn":          "arn:aws:sns:us-east-1:123456789012:critical",
+			"attribute_severity": "critical",
+			"request_id":         alert.Labels["request_id"],
+		},
+		Annotations: map[string]string{
+			MessageAnnotation: "some description",
+			"subject":         "[FIRING:1] Test",
+		},
+		StartsAt: alert.StartsAt,
+	}
+	if !reflect.DeepEqual(alert, expect) {
+		t.Fatalf("wanted %v got %v", expect, alert)
+	}
+}
+
+func TestSNSRejectsUnsignedRequests(t *testing.T) {
+	f := NewSNS(sigv4.NewVerifier(sigv4.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}))
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(snsPublishBody()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	w, _ := serve(t, f, "/", req)
+
+	if w.Result().StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 response but got %d", w.Result().StatusCode)
+	}
+	if !strings.Contains(w.Body.String(), "<Code>SignatureDoesNotMatch</Code>") {
+		t.Fatalf("unexpected xml response %s", w.Body.String())
+	}
+}
+
+func TestSNSRejectsUnknownActions(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("Action=Subscribe"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w, _ := serve(t, NewSNS(nil), "/", req)
+
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 response but got %d", w.Result().StatusCode)
+	}
+	if !strings.Contains(w.Body.String(), "<Code>InvalidAction</Code>") {
+		t.Fatalf("unexpected xml response %s", w.Body.String())
+	}
+}
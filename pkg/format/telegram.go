@@ -0,0 +1,158 @@
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+const (
+	// IntegrationLabel is set on alerts recorded by the integration stand-ins to the name of the integration
+	IntegrationLabel = "integration"
+	// MessageAnnotation holds the rendered message text on alerts recorded by the integration stand-ins
+	MessageAnnotation = "message"
+)
+
+// TelegramName is the name of the Telegram Bot API format
+const TelegramName = "telegram"
+
+// Telegram stands in for the Telegram Bot API sendMessage method used by Alertmanager telegram_configs.
+// It must be served on a path with a {token} variable such as /bot{token}/sendMessage.
+// The rendered message is saved as a single api.Alert under the ID telegram_{chat_id}.
+type Telegram struct {
+	token     string
+	messageID int64
+}
+
+// NewTelegram returns the Telegram format accepting the provided bot token.
+// An empty token accepts any non-empty token.
+func NewTelegram(token string) *Telegram {
+	return &Telegram{token: token}
+}
+
+type telegramRequest struct {
+	token  string
+	params map[string]string
+}
+
+type telegramResponse struct {
+	OK          bool             `json:"ok"`
+	ErrorCode   int              `json:"error_code,omitempty"`
+	Description string           `json:"description,omitempty"`
+	Result      *telegramMessage `json:"result,omitempty"`
+}
+
+type telegramMessage struct {
+	MessageID int64        `json:"message_id"`
+	Date      int64        `json:"date"`
+	Chat      telegramChat `json:"chat"`
+	Text      string       `json:"text"`
+}
+
+type telegramChat struct {
+	ID       int64  `json:"id,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+func (t *Telegram) Name() string { return TelegramName }
+
+// Decode reads the method parameters from either a JSON or a form encoded body.
+// The Bot API accepts both and chat_id may be sent as either a string or a number.
+func (t *Telegram) Decode(r *http.Request) (Notification, error) {
+	req := &telegramRequest{token: mux.Vars(r)["token"], params: make(map[string]string)}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := r.ParseForm(); err != nil {
+			return nil, Errorf(http.StatusBadRequest, "failed to parse form: %w", err)
+		}
+		for k := range r.Form {
+			req.params[k] = r.Form.Get(k)
+		}
+		return req, nil
+	}
+
+	var raw map[string]interface{}
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, Errorf(http.StatusBadRequest, "failed to decode JSON body: %w", err)
+	}
+	for k, v := range raw {
+		if v != nil {
+			req.params[k] = fmt.Sprint(v)
+		}
+	}
+	return req, nil
+}
+
+func (t *Telegram) Validate(n Notification) error {
+	req := n.(*telegramRequest)
+	if !validToken(t.token, req.token) {
+		return Errorf(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	switch {
+	case req.params["chat_id"] == "":
+		return Errorf(http.StatusBadRequest, "Bad Request: chat_id is empty")
+	case req.params["text"] == "":
+		return Errorf(http.StatusBadRequest, "Bad Request: message text is empty")
+	}
+	switch mode := req.params["parse_mode"]; mode {
+	case "", "Markdown", "MarkdownV2", "HTML":
+	default:
+		return Errorf(http.StatusBadRequest, "Bad Request: unsupported parse_mode %q", mode)
+	}
+	return nil
+}
+
+func (t *Telegram) Normalize(n Notification) ([]Record, error) {
+	params := n.(*telegramRequest).params
+	alert := api.Alert{
+		Labels: map[string]string{
+			IntegrationLabel: TelegramName,
+			"chat_id":        params["chat_id"],
+		},
+		Annotations: map[string]string{MessageAnnotation: params["text"]},
+		StartsAt:    time.Now().UTC(),
+	}
+	for _, name := range []string{"parse_mode", "message_thread_id", "disable_notification"} {
+		if v := params[name]; v != "" {
+			alert.Labels[name] = v
+		}
+	}
+	return []Record{{ID: "telegram_" + params["chat_id"], Alerts: []api.Alert{alert}}}, nil
+}
+
+func (t *Telegram) WriteResponse(w http.ResponseWriter, records []Record, err error) {
+	if err != nil {
+		status, description := StatusCode(err), "Internal Server Error"
+		if _, ok := err.(*Error); ok {
+			description = err.Error()
+		}
+		writeJSON(w, status, telegramResponse{ErrorCode: status, Description: description})
+		return
+	}
+
+	alert := records[0].Alerts[0]
+	chatID := alert.Labels["chat_id"]
+	chat := telegramChat{Username: chatID}
+	if id, err := strconv.ParseInt(chatID, 10, 64); err == nil {
+		chat = telegramChat{ID: id}
+	}
+	writeJSON(w, http.StatusOK, telegramResponse{
+		OK: true,
+		Result: &telegramMessage{
+			MessageID: atomic.AddInt64(&t.messageID, 1),
+			Date:      alert.StartsAt.Unix(),
+			Chat:      chat,
+			Text:      alert.Annotations[MessageAnnotation],
+		},
+	})
+}
@@ -0,0 +1,45 @@
+package format
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTelegram(t *testing.T) {
+	f := NewTelegram("secret")
+	path := "/bot{token}/sendMessage"
+	body := `{"chat_id":"1234","text":"<b>firing</b>","parse_mode":"HTML"}`
+
+	req := httptest.NewRequest(http.MethodPost, "/botwrong/sendMessage", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w, _ := serve(t, f, path, req)
+	if w.Result().StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 response but got %d", w.Result().StatusCode)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/botsecret/sendMessage", strings.NewReader(`{"chat_id":1234,"text":"x","parse_mode":"Plain"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w, _ = serve(t, f, path, req)
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 response but got %d", w.Result().StatusCode)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/botsecret/sendMessage", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w, records := serve(t, f, path, req)
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 response but got %d", w.Result().StatusCode)
+	}
+	if !strings.Contains(w.Body.String(), `"ok":true`) || !strings.Contains(w.Body.String(), `"chat":{"id":1234}`) {
+		t.Fatalf("unexpected json response %s", w.Body.String())
+	}
+	if len(records) != 1 || records[0].ID != "telegram_1234" {
+		t.Fatalf("unexpected records %v", records)
+	}
+	alert := records[0].Alerts[0]
+	if alert.Annotations[MessageAnnotation] != "<b>firing</b>" || alert.Labels["parse_mode"] != "HTML" {
+		t.Fatalf("unexpected alert %v", alert)
+	}
+}
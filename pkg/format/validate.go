@@ -0,0 +1,161 @@
+package format
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+// WebhookVersion is the only version of the Alertmanager webhook payload
+const WebhookVersion = "4"
+
+const (
+	statusFiring   = "firing"
+	statusResolved = "resolved"
+)
+
+// ValidationError lists every problem found with a notification
+type ValidationError []string
+
+func (v ValidationError) Error() string { return strings.Join(v, "; ") }
+
+// ValidationErrors returns the individual problems described by err
+func ValidationErrors(err error) []string {
+	var v ValidationError
+	if errors.As(err, &v) {
+		return v
+	}
+	return []string{err.Error()}
+}
+
+// ValidateMessage checks m against the Alertmanager webhook schema and returns every violation found.
+// now is used to check that the status of each alert is consistent with its end time, allowing end times
+// to be up to skew either side of now to absorb clock differences between Alertmanager and the receiver.
+func ValidateMessage(m api.Message, now time.Time, skew time.Duration) ValidationError {
+	var errs ValidationError
+	if m.Version != WebhookVersion {
+		errs = append(errs, fmt.Sprintf("version must be %q but got %q", WebhookVersion, m.Version))
+	}
+	if m.Status != statusFiring && m.Status != statusResolved {
+		errs = append(errs, fmt.Sprintf("status must be one of %q or %q but got %q", statusFiring, statusResolved, m.Status))
+	}
+	if m.Receiver == "" {
+		errs = append(errs, "receiver must not be empty")
+	}
+	if m.GroupKey == "" {
+		errs = append(errs, "groupKey must not be empty")
+	}
+	if len(m.Alerts) == 0 {
+		errs = append(errs, "alerts must not be empty")
+	}
+
+	anyFiring := false
+	for i, a := range m.Alerts {
+		prefix := fmt.Sprintf("alerts[%d]: ", i)
+		switch a.Status {
+		case statusFiring:
+			anyFiring = true
+			if !a.EndsAt.IsZero() && !a.EndsAt.After(now.Add(-skew)) {
+				errs = append(errs, prefix+"firing alert must not have endsAt in the past")
+			}
+		case statusResolved:
+			if a.EndsAt.IsZero() {
+				errs = append(errs, prefix+"resolved alert must have endsAt set")
+			} else if a.EndsAt.After(now.Add(skew)) {
+				errs = append(errs, prefix+"resolved alert must not have endsAt in the future")
+			}
+		default:
+			errs = append(errs, prefix+fmt.Sprintf("status must be one of %q or %q but got %q", statusFiring, statusResolved, a.Status))
+		}
+		if a.Fingerprint == "" {
+			errs = append(errs, prefix+"fingerprint must not be empty")
+		}
+		if a.StartsAt.IsZero() {
+			errs = append(errs, prefix+"startsAt must be set")
+		} else if !a.EndsAt.IsZero() && a.EndsAt.Before(a.StartsAt) {
+			errs = append(errs, prefix+"endsAt must not be before startsAt")
+		}
+		if len(a.Labels) == 0 {
+			errs = append(errs, prefix+"labels must not be empty")
+		}
+	}
+
+	if len(m.Alerts) > 0 && (m.Status == statusFiring) != anyFiring {
+		errs = append(errs, fmt.Sprintf("status %q is inconsistent with the status of the alerts", m.Status))
+	}
+	return errs
+}
+
+// Rejection describes a notification that failed validation
+type Rejection struct {
+	Time       time.Time `json:"time"`
+	Format     string    `json:"format"`
+	Path       string    `json:"path"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Errors     []string  `json:"errors"`
+
+	tenant string
+}
+
+// RejectionLog keeps the most recent rejections in memory
+type RejectionLog struct {
+	mu         sync.Mutex
+	max        int
+	rejections []Rejection
+}
+
+// NewRejectionLog returns a RejectionLog holding at most max rejections
+func NewRejectionLog(max int) *RejectionLog {
+	return &RejectionLog{max: max}
+}
+
+// Add records a rejection of the request r for tenant by format f, dropping the oldest entry when full
+func (l *RejectionLog) Add(tenant string, f Format, r *http.Request, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rejections = append(l.rejections, Rejection{
+		Time:       time.Now().UTC(),
+		Format:     f.Name(),
+		Path:       r.URL.Path,
+		RemoteAddr: r.RemoteAddr,
+		Errors:     ValidationErrors(err),
+		tenant:     tenant,
+	})
+	if len(l.rejections) > l.max {
+		l.rejections = l.rejections[len(l.rejections)-l.max:]
+	}
+}
+
+// Reset forgets the rejections of tenant
+func (l *RejectionLog) Reset(tenant string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	kept := l.rejections[:0]
+	for _, r := range l.rejections {
+		if r.tenant != tenant {
+			kept = append(kept, r)
+		}
+	}
+	l.rejections = kept
+}
+
+// List returns the recorded rejections of tenant, oldest first
+func (l *RejectionLog) List(tenant string) []Rejection {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := []Rejection{}
+	for _, r := range l.rejections {
+		if r.tenant == tenant {
+			out = append(out, r)
+		}
+	}
+	return out
+}
@@ -0,0 +1,181 @@
+package format
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+func validMessage(now time.Time) api.Message {
+	return api.Message{
+		Version:  WebhookVersion,
+		GroupKey: `{}:{alertname="Test"}`,
+		Receiver: "webhook",
+		Status:   statusFiring,
+		Alerts: []api.Alert{
+			{
+				Status:      statusFiring,
+				Labels:      map[string]string{"alertname": "Test"},
+				StartsAt:    now.Add(-time.Hour),
+				Fingerprint: "a",
+			},
+			{
+				Status:      statusResolved,
+				Labels:      map[string]string{"alertname": "Test", "instance": "b"},
+				StartsAt:    now.Add(-time.Hour),
+				EndsAt:      now.Add(-time.Minute),
+				Fingerprint: "b",
+			},
+		},
+	}
+}
+
+func TestValidateMessage(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	if errs := ValidateMessage(validMessage(now), now, 0); len(errs) != 0 {
+		t.Fatalf("expected message to be valid but got %v", errs)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(m *api.Message)
+		expect string
+	}{
+		{
+			name:   "version",
+			modify: func(m *api.Message) { m.Version = "3" },
+			expect: `version must be "4"`,
+		},
+		{
+			name:   "message status",
+			modify: func(m *api.Message) { m.Status = "" },
+			expect: "status must be one of",
+		},
+		{
+			name:   "missing alerts",
+			modify: func(m *api.Message) { m.Alerts = nil },
+			expect: "alerts must not be empty",
+		},
+		{
+			name:   "alert status",
+			modify: func(m *api.Message) { m.Alerts[0].Status = "pending" },
+			expect: "alerts[0]: status must be one of",
+		},
+		{
+			name:   "fingerprint",
+			modify: func(m *api.Message) { m.Alerts[1].Fingerprint = "" },
+			expect: "alerts[1]: fingerprint must not be empty",
+		},
+		{
+			name:   "zero startsAt",
+			modify: func(m *api.Message) { m.Alerts[0].StartsAt = time.Time{} },
+			expect: "alerts[0]: startsAt must be set",
+		},
+		{
+			name:   "resolved without endsAt",
+			modify: func(m *api.Message) { m.Alerts[1].EndsAt = time.Time{} },
+			expect: "alerts[1]: resolved alert must have endsAt set",
+		},
+		{
+			name:   "firing with past endsAt",
+			modify: func(m *api.Message) { m.Alerts[0].EndsAt = now.Add(-time.Second) },
+			expect: "alerts[0]: firing alert must not have endsAt in the past",
+		},
+		{
+			name:   "inconsistent message status",
+			modify: func(m *api.Message) { m.Status = statusResolved },
+			expect: `status "resolved" is inconsistent`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			m := validMessage(now)
+			tc.modify(&m)
+			errs := ValidateMessage(m, now, 0)
+			if !strings.Contains(errs.Error(), tc.expect) {
+				t.Fatalf("expected %q in %v", tc.expect, errs)
+			}
+		})
+	}
+}
+
+func TestValidateMessageSkew(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	skew := 5 * time.Second
+	tests := []struct {
+		name   string
+		modify func(m *api.Message)
+		valid  bool
+	}{
+		{
+			name:   "firing endsAt within skew",
+			modify: func(m *api.Message) { m.Alerts[0].EndsAt = now.Add(-skew + time.Nanosecond) },
+			valid:  true,
+		},
+		{
+			name:   "firing endsAt beyond skew",
+			modify: func(m *api.Message) { m.Alerts[0].EndsAt = now.Add(-skew) },
+		},
+		{
+			name:   "resolved endsAt within skew",
+			modify: func(m *api.Message) { m.Alerts[1].EndsAt = now.Add(skew) },
+			valid:  true,
+		},
+		{
+			name:   "resolved endsAt beyond skew",
+			modify: func(m *api.Message) { m.Alerts[1].EndsAt = now.Add(skew + time.Nanosecond) },
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			m := validMessage(now)
+			tc.modify(&m)
+			if errs := ValidateMessage(m, now, skew); (len(errs) == 0) != tc.valid {
+				t.Fatalf("wanted valid %v got %v", tc.valid, errs)
+			}
+		})
+	}
+}
+
+func TestRejectionLog(t *testing.T) {
+	log := NewRejectionLog(2)
+	f := NewAlertmanager(nil, true)
+	for i, err := range []error{
+		errors.New("first"),
+		&Error{Err: ValidationError{"second", "third"}},
+		errors.New("fourth"),
+	} {
+		r := httptest.NewRequest("POST", "/webhook", nil)
+		r.RemoteAddr = string(rune('a' + i))
+		log.Add("", f, r, err)
+	}
+
+	got := log.List("")
+	if len(got) != 2 {
+		t.Fatalf("expected log to be bounded to 2 entries but got %d", len(got))
+	}
+	if strings.Join(got[0].Errors, ",") != "second,third" || got[1].Errors[0] != "fourth" {
+		t.Fatalf("unexpected rejections %v", got)
+	}
+	if got[0].Format != AlertmanagerName || got[0].Path != "/webhook" || got[0].RemoteAddr != "b" {
+		t.Fatalf("unexpected rejection %v", got[0])
+	}
+	if got := log.List("team-a"); len(got) != 0 {
+		t.Fatalf("expected another tenant to have no rejections but got %v", got)
+	}
+
+	log.Add("team-a", f, httptest.NewRequest("POST", "/t/team-a/webhook", nil), errors.New("fifth"))
+	log.Reset("")
+	if got := log.List(""); len(got) != 0 {
+		t.Fatalf("expected reset rejections to be forgotten but got %v", got)
+	}
+	if got := log.List("team-a"); len(got) != 1 {
+		t.Fatalf("expected another tenant to keep its rejections but got %v", got)
+	}
+}
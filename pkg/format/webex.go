@@ -0,0 +1,111 @@
+package format
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+// WebexName is the name of the Webex messages API format
+const WebexName = "webex"
+
+// Webex stands in for the Webex create message API used by Alertmanager webex_configs.
+// The rendered message is saved as a single api.Alert under the ID webex_{roomId}.
+type Webex struct {
+	token string
+}
+
+// NewWebex returns the Webex format accepting the provided bearer token.
+// An empty token accepts any non-empty token.
+func NewWebex(token string) *Webex {
+	return &Webex{token: token}
+}
+
+type webexRequest struct {
+	token   string
+	message webexMessage
+}
+
+type webexMessage struct {
+	ID       string `json:"id,omitempty"`
+	RoomID   string `json:"roomId"`
+	Text     string `json:"text,omitempty"`
+	Markdown string `json:"markdown,omitempty"`
+	Created  string `json:"created,omitempty"`
+}
+
+type webexError struct {
+	Message    string `json:"message"`
+	TrackingID string `json:"trackingId"`
+}
+
+func (x *Webex) Name() string { return WebexName }
+
+func (x *Webex) Decode(r *http.Request) (Notification, error) {
+	req := &webexRequest{}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		req.token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req.message); err != nil {
+		return nil, Errorf(http.StatusBadRequest, "failed to decode JSON body: %w", err)
+	}
+	return req, nil
+}
+
+func (x *Webex) Validate(n Notification) error {
+	req := n.(*webexRequest)
+	switch {
+	case !validToken(x.token, req.token):
+		return Errorf(http.StatusUnauthorized, "The request requires a valid access token set in the Authorization request header.")
+	case req.message.RoomID == "":
+		return Errorf(http.StatusBadRequest, "roomId is required")
+	case req.message.Markdown == "" && req.message.Text == "":
+		return Errorf(http.StatusBadRequest, "one of text or markdown is required")
+	}
+	return nil
+}
+
+func (x *Webex) Normalize(n Notification) ([]Record, error) {
+	msg := n.(*webexRequest).message
+	text, format := msg.Markdown, "markdown"
+	if text == "" {
+		text, format = msg.Text, "text"
+	}
+	alert := api.Alert{
+		Labels: map[string]string{
+			IntegrationLabel: WebexName,
+			"room_id":        msg.RoomID,
+			"format":         format,
+		},
+		Annotations: map[string]string{MessageAnnotation: text},
+		StartsAt:    time.Now().UTC(),
+	}
+	return []Record{{ID: "webex_" + msg.RoomID, Alerts: []api.Alert{alert}}}, nil
+}
+
+func (x *Webex) WriteResponse(w http.ResponseWriter, records []Record, err error) {
+	if err != nil {
+		msg := "failed to save message"
+		if _, ok := err.(*Error); ok {
+			msg = err.Error()
+		}
+		writeJSON(w, StatusCode(err), webexError{Message: msg, TrackingID: randomID()})
+		return
+	}
+
+	alert := records[0].Alerts[0]
+	resp := webexMessage{
+		ID:      randomID(),
+		RoomID:  alert.Labels["room_id"],
+		Created: alert.StartsAt.Format(time.RFC3339Nano),
+	}
+	if alert.Labels["format"] == "markdown" {
+		resp.Markdown = alert.Annotations[MessageAnnotation]
+	} else {
+		resp.Text = alert.Annotations[MessageAnnotation]
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,37 @@
+package format
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebex(t *testing.T) {
+	f := NewWebex("")
+	path := "/v1/messages"
+	body := `{"roomId":"room","markdown":"**firing**"}`
+
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	w, _ := serve(t, f, path, req)
+	if w.Result().StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 response but got %d", w.Result().StatusCode)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer any")
+	w, records := serve(t, f, path, req)
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 response but got %d", w.Result().StatusCode)
+	}
+	if !strings.Contains(w.Body.String(), `"markdown":"**firing**"`) {
+		t.Fatalf("unexpected json response %s", w.Body.String())
+	}
+	if len(records) != 1 || records[0].ID != "webex_room" {
+		t.Fatalf("unexpected records %v", records)
+	}
+	alert := records[0].Alerts[0]
+	if alert.Annotations[MessageAnnotation] != "**firing**" || alert.Labels["format"] != "markdown" {
+		t.Fatalf("unexpected alert %v", alert)
+	}
+}
@@ -0,0 +1,196 @@
+// Package forward relays inbound notifications to downstream webhooks in the background.
+package forward
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+const (
+	defaultTimeout      = 10 * time.Second
+	defaultRetryBackoff = time.Second
+)
+
+// Config is the forwarding configuration file
+type Config struct {
+	Targets []Target `yaml:"targets"`
+}
+
+// Target is a downstream webhook notifications are forwarded to
+type Target struct {
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	// Timeout bounds each attempt
+	Timeout time.Duration `yaml:"timeout"`
+	// Retries is the number of additional attempts made after a failed one
+	Retries int `yaml:"retries"`
+	// RetryBackoff is the wait before the first retry, doubling after each attempt
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+}
+
+// Parse parses and validates a forwarding configuration, filling in defaults
+func Parse(b []byte) (*Config, error) {
+	var cfg Config
+	if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse forwarding config: %w", err)
+	}
+	if len(cfg.Targets) == 0 {
+		return nil, fmt.Errorf("forwarding config has no targets")
+	}
+
+	names := map[string]bool{}
+	for i := range cfg.Targets {
+		t := &cfg.Targets[i]
+		u, err := url.Parse(t.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("target %d has invalid url %q", i, t.URL)
+		}
+		if t.Name == "" {
+			t.Name = u.Host
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("duplicate target name %q", t.Name)
+		}
+		names[t.Name] = true
+		if t.Retries < 0 {
+			return nil, fmt.Errorf("target %q has negative retries", t.Name)
+		}
+		if t.Timeout <= 0 {
+			t.Timeout = defaultTimeout
+		}
+		if t.RetryBackoff <= 0 {
+			t.RetryBackoff = defaultRetryBackoff
+		}
+	}
+	return &cfg, nil
+}
+
+// LoadFile parses the forwarding configuration at path
+func LoadFile(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Outcome is the result of forwarding a notification to a single target
+type Outcome = api.ForwardOutcome
+
+// Forwarder sends notifications to each configured target
+type Forwarder struct {
+	client  *http.Client
+	targets []Target
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+// NewForwarder returns a Forwarder for the targets in cfg
+func NewForwarder(cfg *Config) *Forwarder {
+	return &Forwarder{
+		client:  &http.Client{},
+		targets: cfg.Targets,
+		sleep:   sleepContext,
+	}
+}
+
+// Forward sends body to every target concurrently and returns an outcome per target in configuration order.
+// The inbound Content-Type and User-Agent are passed on, and each target's own headers take precedence.
+func (f *Forwarder) Forward(ctx context.Context, body []byte, header http.Header) []Outcome {
+	outcomes := make([]Outcome, len(f.targets))
+	var wg sync.WaitGroup
+	for i, t := range f.targets {
+		wg.Add(1)
+		go func(i int, t Target) {
+			defer wg.Done()
+			outcomes[i] = f.forward(ctx, t, body, header)
+		}(i, t)
+	}
+	wg.Wait()
+	return outcomes
+}
+
+func (f *Forwarder) forward(ctx context.Context, t Target, body []byte, header http.Header) Outcome {
+	start := time.Now()
+	o := Outcome{Time: start.UTC(), Target: t.Name, URL: t.URL}
+
+	backoff := t.RetryBackoff
+	for attempt := 0; attempt <= t.Retries; attempt++ {
+		if attempt > 0 {
+			if err := f.sleep(ctx, backoff); err != nil {
+				o.Error = err.Error()
+				break
+			}
+			backoff *= 2
+		}
+
+		o.Attempts++
+		status, retry, err := f.send(ctx, t, body, header)
+		o.Status = status
+		o.Error = ""
+		if err == nil {
+			break
+		}
+		o.Error = err.Error()
+		if !retry {
+			break
+		}
+	}
+
+	o.Duration = time.Since(start).String()
+	return o
+}
+
+// send makes a single attempt, reporting whether a failure is worth retrying
+func (f *Forwarder) send(ctx context.Context, t Target, body []byte, header http.Header) (int, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	for _, name := range []string{"Content-Type", "User-Agent"} {
+		if v := header.Get(name); v != "" {
+			req.Header.Set(name, v)
+		}
+	}
+	for name, v := range t.Headers {
+		req.Header.Set(name, v)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 == 2 {
+		return resp.StatusCode, false, nil
+	}
+	retry := resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests
+	return resp.StatusCode, retry, fmt.Errorf("unexpected status %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
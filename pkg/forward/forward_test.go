@@ -0,0 +1,99 @@
+package forward
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(`
+targets:
+- url: http://example.com/hook
+  retries: 2
+  headers:
+    Authorization: Bearer secret
+- name: slack
+  url: https://hooks.slack.com/x
+  timeout: 2s
+  retry_backoff: 100ms
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := cfg.Targets[0], cfg.Targets[1]
+	if first.Name != "example.com" || first.Timeout != defaultTimeout || first.RetryBackoff != defaultRetryBackoff || first.Retries != 2 {
+		t.Fatalf("unexpected target %v", first)
+	}
+	if second.Name != "slack" || second.Timeout != 2*time.Second || second.RetryBackoff != 100*time.Millisecond {
+		t.Fatalf("unexpected target %v", second)
+	}
+
+	for _, invalid := range []string{
+		``,
+		`targets: [{url: "ftp://example.com"}]`,
+		`targets: [{url: "http://a"}, {url: "http://a"}]`,
+		`targets: [{url: "http://a", retries: -1}]`,
+		`targets: [{url: "http://a", unknown: true}]`,
+	} {
+		if _, err := Parse([]byte(invalid)); err == nil {
+			t.Fatalf("expected error parsing %q", invalid)
+		}
+	}
+}
+
+func TestForwarder_Forward(t *testing.T) {
+	var calls int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		if string(b) != "payload" || r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %v %s", r.Header, b)
+		}
+	}))
+	t.Cleanup(flaky.Close)
+
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	t.Cleanup(rejecting.Close)
+
+	f := NewForwarder(&Config{Targets: []Target{
+		{Name: "flaky", URL: flaky.URL, Retries: 3, Timeout: time.Second, RetryBackoff: time.Second, Headers: map[string]string{"Authorization": "Bearer secret"}},
+		{Name: "rejecting", URL: rejecting.URL, Retries: 3, Timeout: time.Second, RetryBackoff: time.Second},
+		{Name: "down", URL: "http://127.0.0.1:1", Retries: 1, Timeout: time.Second, RetryBackoff: time.Second},
+	}})
+	var backoffs int32
+	f.sleep = func(ctx context.Context, d time.Duration) error {
+		atomic.AddInt32(&backoffs, 1)
+		return nil
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	outcomes := f.Forward(context.Background(), []byte("payload"), header)
+
+	if len(outcomes) != 3 {
+		t.Fatalf("expected an outcome per target but got %v", outcomes)
+	}
+	if o := outcomes[0]; !o.Success() || o.Attempts != 3 || o.Status != http.StatusOK {
+		t.Fatalf("expected flaky target to succeed after retries but got %v", o)
+	}
+	if o := outcomes[1]; o.Success() || o.Attempts != 1 || o.Status != http.StatusBadRequest {
+		t.Fatalf("expected client errors not to be retried but got %v", o)
+	}
+	if o := outcomes[2]; o.Success() || o.Attempts != 2 || o.Status != 0 || !strings.Contains(o.Error, "connection refused") {
+		t.Fatalf("expected connection errors to be retried but got %v", o)
+	}
+	if backoffs != 3 {
+		t.Fatalf("wanted %v got %v", 3, backoffs)
+	}
+}
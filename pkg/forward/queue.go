@@ -0,0 +1,78 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+var (
+	// ErrQueueFull is returned by Enqueue when every worker is busy and the queue holds its maximum of jobs
+	ErrQueueFull = errors.New("forwarding queue is full")
+	// ErrQueueClosed is returned by Enqueue once the queue is closed
+	ErrQueueClosed = errors.New("forwarding queue is closed")
+)
+
+// Queue forwards notifications in the background with a fixed number of workers, so that slow or
+// failing targets do not delay the response to the sender. It is safe for concurrent use.
+type Queue struct {
+	forwarder *Forwarder
+	jobs      chan job
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+type job struct {
+	body   []byte
+	header http.Header
+	done   func([]Outcome)
+}
+
+// NewQueue starts workers forwarding with f, holding at most size notifications waiting for a worker
+func NewQueue(f *Forwarder, workers, size int) *Queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{forwarder: f, jobs: make(chan job, size), ctx: ctx, cancel: cancel}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Enqueue forwards body to every target in the background without blocking. done is called from a worker
+// with the outcomes, including those of a forward cut short by Close.
+// The header is copied, so the caller may reuse it once Enqueue returns.
+func (q *Queue) Enqueue(body []byte, header http.Header, done func([]Outcome)) error {
+	if q.ctx.Err() != nil {
+		return ErrQueueClosed
+	}
+	select {
+	case q.jobs <- job{body: body, header: header.Clone(), done: done}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	// select picks at random, so check for Close first to leave waiting notifications alone
+	for q.ctx.Err() == nil {
+		select {
+		case <-q.ctx.Done():
+			return
+		case j := <-q.jobs:
+			j.done(q.forwarder.Forward(q.ctx, j.body, j.header))
+		}
+	}
+}
+
+// Close cancels the forwards in progress, waits for their done functions to return and drops the
+// notifications still waiting for a worker, returning how many were dropped
+func (q *Queue) Close() int {
+	q.cancel()
+	q.wg.Wait()
+	return len(q.jobs)
+}
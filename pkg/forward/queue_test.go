@@ -0,0 +1,68 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(downstream.Close)
+
+	q := NewQueue(NewForwarder(&Config{Targets: []Target{{Name: "downstream", URL: downstream.URL, Timeout: time.Second}}}), 1, 1)
+	defer q.Close()
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	done := make(chan []Outcome)
+	if err := q.Enqueue([]byte("payload"), header, func(outcomes []Outcome) { done <- outcomes }); err != nil {
+		t.Fatal(err)
+	}
+	header.Del("Content-Type")
+
+	select {
+	case outcomes := <-done:
+		if len(outcomes) != 1 || !outcomes[0].Success() || outcomes[0].Status != http.StatusOK {
+			t.Fatalf("unexpected outcomes %v", outcomes)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the notification to be forwarded")
+	}
+}
+
+func TestQueue_FullAndClose(t *testing.T) {
+	arrived := make(chan struct{}, 1)
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(downstream.Close)
+
+	q := NewQueue(NewForwarder(&Config{Targets: []Target{{Name: "slow", URL: downstream.URL, Timeout: time.Minute}}}), 1, 1)
+	var outcomes []Outcome
+	if err := q.Enqueue(nil, http.Header{}, func(o []Outcome) { outcomes = o }); err != nil {
+		t.Fatal(err)
+	}
+	<-arrived
+	// the worker is busy, so one notification waits and the next is refused
+	if err := q.Enqueue(nil, http.Header{}, func([]Outcome) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(nil, http.Header{}, func([]Outcome) {}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("wanted %v got %v", ErrQueueFull, err)
+	}
+
+	if dropped := q.Close(); dropped != 1 {
+		t.Fatalf("wanted %v got %v", 1, dropped)
+	}
+	if len(outcomes) != 1 || !strings.Contains(outcomes[0].Error, context.Canceled.Error()) {
+		t.Fatalf("expected the forward in progress to be canceled but got %v", outcomes)
+	}
+	if err := q.Enqueue(nil, http.Header{}, func([]Outcome) {}); !errors.Is(err, ErrQueueClosed) {
+		t.Fatalf("wanted %v got %v", ErrQueueClosed, err)
+	}
+}
@@ -0,0 +1,188 @@
+// Package labels implements the label matching and fingerprinting used by Alertmanager
+// without depending on the Prometheus libraries.
+package labels
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MatchType is the comparison a Matcher performs
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher matches the value of a single label
+type Matcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+	re    *regexp.Regexp
+}
+
+// NewMatcher returns a Matcher, compiling the value for regular expression match types.
+// As in Alertmanager, regular expressions are fully anchored.
+func NewMatcher(t MatchType, name, value string) (*Matcher, error) {
+	m := &Matcher{Type: t, Name: name, Value: value}
+	switch t {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", value, err)
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("unknown match type %q", t)
+	}
+	return m, nil
+}
+
+// Matches returns true if the value of the label satisfies the matcher. A missing label has an empty value.
+func (m *Matcher) Matches(v string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	case MatchNotRegexp:
+		return !m.re.MatchString(v)
+	}
+	return false
+}
+
+func (m *Matcher) String() string {
+	return fmt.Sprintf("%s%s%s", m.Name, m.Type, strconv.Quote(m.Value))
+}
+
+// Matchers is a set of matchers that must all match
+type Matchers []*Matcher
+
+// Matches returns true if every matcher matches the label set
+func (ms Matchers) Matches(lset map[string]string) bool {
+	for _, m := range ms {
+		if !m.Matches(lset[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ms Matchers) String() string {
+	s := make([]string, 0, len(ms))
+	for _, m := range ms {
+		s = append(s, m.String())
+	}
+	return "{" + strings.Join(s, ",") + "}"
+}
+
+var matcherRE = regexp.MustCompile(`^\s*([a-zA-Z_:][a-zA-Z0-9_:]*)\s*(=~|!~|!=|=)\s*(.*?)\s*$`)
+
+// ParseMatcher parses a single matcher such as job="prometheus" or instance=~"host.*".
+// The value may be unquoted.
+func ParseMatcher(s string) (*Matcher, error) {
+	parts := matcherRE.FindStringSubmatch(s)
+	if parts == nil {
+		return nil, fmt.Errorf("bad matcher format: %s", s)
+	}
+
+	value := parts[3]
+	if strings.HasPrefix(value, `"`) {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("bad matcher value %s: %w", value, err)
+		}
+		value = unquoted
+	}
+	return NewMatcher(MatchType(parts[2]), parts[1], value)
+}
+
+// ParseMatchers parses a comma separated list of matchers optionally enclosed in braces,
+// such as {alertname="Test",job=~"prom.*"}
+func ParseMatchers(s string) (Matchers, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "{") {
+		if !strings.HasSuffix(s, "}") {
+			return nil, fmt.Errorf("missing closing brace: %s", s)
+		}
+		s = s[1 : len(s)-1]
+	}
+
+	var ms Matchers
+	for _, part := range splitMatchers(s) {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		m, err := ParseMatcher(part)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+// splitMatchers splits on commas that are not within a quoted value
+func splitMatchers(s string) []string {
+	var (
+		parts   []string
+		start   int
+		quoted  bool
+		escaped bool
+	)
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+const (
+	offset64      = 14695981039346656037
+	prime64       = 1099511628211
+	separatorByte = 255
+)
+
+// Fingerprint returns the fingerprint Alertmanager computes for a label set,
+// the FNV-1a hash of the sorted label names and values
+func Fingerprint(lset map[string]string) string {
+	names := make([]string, 0, len(lset))
+	for name := range lset {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sum uint64 = offset64
+	add := func(s string) {
+		for i := 0; i < len(s); i++ {
+			sum ^= uint64(s[i])
+			sum *= prime64
+		}
+		sum ^= separatorByte
+		sum *= prime64
+	}
+	for _, name := range names {
+		add(name)
+		add(lset[name])
+	}
+	return fmt.Sprintf("%016x", sum)
+}
@@ -0,0 +1,51 @@
+package labels
+
+import "testing"
+
+func TestParseMatchers(t *testing.T) {
+	ms, err := ParseMatchers(`{alertname="Test", job=~"prom.*",instance!="a,b", severity!~critical}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 4 {
+		t.Fatalf("expected 4 matchers but got %v", ms)
+	}
+	expect := `{alertname="Test",job=~"prom.*",instance!="a,b",severity!~"critical"}`
+	if ms.String() != expect {
+		t.Fatalf("wanted %s got %s", expect, ms.String())
+	}
+
+	lset := map[string]string{"alertname": "Test", "job": "prometheus24", "severity": "warning"}
+	if !ms.Matches(lset) {
+		t.Fatalf("expected %s to match %v", ms, lset)
+	}
+	lset["job"] = "node"
+	if ms.Matches(lset) {
+		t.Fatalf("expected %s not to match %v", ms, lset)
+	}
+
+	for _, invalid := range []string{`{alertname="Test"`, `alertname`, `job=~"("`, `0job="x"`} {
+		if _, err := ParseMatchers(invalid); err == nil {
+			t.Fatalf("expected %s to fail to parse", invalid)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	// values match model.LabelSet.Fingerprint from github.com/prometheus/common
+	if got := Fingerprint(map[string]string{}); got != "cbf29ce484222325" {
+		t.Fatalf("unexpected fingerprint for empty label set %s", got)
+	}
+
+	a := Fingerprint(map[string]string{"alertname": "Test", "job": "prometheus24"})
+	if a != "2ad87485aa3a8adb" {
+		t.Fatalf("unexpected fingerprint %s", a)
+	}
+	b := Fingerprint(map[string]string{"job": "prometheus24", "alertname": "Test"})
+	if a != b {
+		t.Fatalf("expected fingerprint to be independent of map order, %s != %s", a, b)
+	}
+	if a == Fingerprint(map[string]string{"alertname": "Test", "job": "node"}) {
+		t.Fatal("expected different label sets to have different fingerprints")
+	}
+}
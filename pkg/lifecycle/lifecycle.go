@@ -0,0 +1,220 @@
+// Package lifecycle tracks the life of individual alerts across notifications.
+// Alerts are keyed by fingerprint, which is computed from the labels when a sender does not provide one.
+package lifecycle
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/labels"
+)
+
+const (
+	// maxTimelines bounds the number of timelines tracked, forgetting the least recently notified first
+	maxTimelines = 10000
+	// maxNotifications bounds the notifications kept in each timeline, dropping the oldest first
+	maxNotifications = 1000
+)
+
+// Notification is a single receipt of an alert
+type Notification struct {
+	ReceivedAt time.Time `json:"receivedAt"`
+	Status     string    `json:"status"`
+	Receiver   string    `json:"receiver"`
+	GroupKey   string    `json:"groupKey"`
+}
+
+// Timeline is the history of a single alert
+type Timeline struct {
+	Fingerprint string            `json:"fingerprint"`
+	Labels      map[string]string `json:"labels"`
+	Status      string            `json:"status"`
+	// StartsAt is the start time of the alert as reported by the sender
+	StartsAt time.Time `json:"startsAt"`
+	// FirstFiringAt is when the first firing notification was received
+	FirstFiringAt time.Time `json:"firstFiringAt,omitempty"`
+	// RepeatNotifications counts the firing notifications received after the first
+	RepeatNotifications int `json:"repeatNotifications"`
+	// ResolvedAt is the end time of the most recent resolution, if any
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+	// TimeToResolve is the time from StartsAt to ResolvedAt
+	TimeToResolve string         `json:"timeToResolve,omitempty"`
+	Receivers     []string       `json:"receivers"`
+	Notifications []Notification `json:"notifications"`
+}
+
+// key identifies the timeline of an alert of a tenant
+type key struct {
+	tenant      string
+	fingerprint string
+}
+
+// entry is a tracked timeline and when it was last notified
+type entry struct {
+	key    key
+	lastAt time.Time
+	tl     *Timeline
+}
+
+// Tracker builds a Timeline for each alert it observes, separately for each tenant. It is safe for concurrent use.
+type Tracker struct {
+	mu        sync.RWMutex
+	retention time.Duration
+	timelines map[key]*list.Element
+	// order holds the timelines from the least to the most recently notified
+	order *list.List
+}
+
+// NewTracker returns an empty Tracker. A timeline is forgotten when its alert is not notified for retention,
+// or never if retention is zero.
+func NewTracker(retention time.Duration) *Tracker {
+	return &Tracker{retention: retention, timelines: make(map[key]*list.Element), order: list.New()}
+}
+
+// Observe records every alert in a message for tenant received at the provided time
+func (t *Tracker) Observe(tenant string, msg api.Message, receivedAt time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.expire(receivedAt)
+	for _, a := range msg.Alerts {
+		fp := Fingerprint(a)
+		k := key{tenant: tenant, fingerprint: fp}
+		el, ok := t.timelines[k]
+		if ok {
+			t.order.MoveToBack(el)
+		} else {
+			el = t.order.PushBack(&entry{key: k, tl: &Timeline{Fingerprint: fp, Labels: a.Labels, StartsAt: a.StartsAt}})
+			t.timelines[k] = el
+			if t.order.Len() > maxTimelines {
+				t.forget(t.order.Front())
+			}
+		}
+		e := el.Value.(*entry)
+		e.lastAt = receivedAt
+		tl := e.tl
+
+		tl.Status = a.Status
+		tl.Notifications = append(tl.Notifications, Notification{
+			ReceivedAt: receivedAt,
+			Status:     a.Status,
+			Receiver:   msg.Receiver,
+			GroupKey:   msg.GroupKey,
+		})
+		if len(tl.Notifications) > maxNotifications {
+			tl.Notifications = tl.Notifications[len(tl.Notifications)-maxNotifications:]
+		}
+		tl.addReceiver(msg.Receiver)
+
+		switch a.Status {
+		case "firing":
+			if tl.ResolvedAt != nil && a.StartsAt.After(*tl.ResolvedAt) {
+				// the alert fired again after it was resolved, so its firing is counted afresh
+				tl.StartsAt, tl.ResolvedAt, tl.TimeToResolve = a.StartsAt, nil, ""
+				tl.FirstFiringAt, tl.RepeatNotifications = time.Time{}, 0
+			}
+			if tl.FirstFiringAt.IsZero() {
+				tl.FirstFiringAt = receivedAt
+			} else {
+				tl.RepeatNotifications++
+			}
+		case "resolved":
+			resolvedAt := a.EndsAt
+			if resolvedAt.IsZero() {
+				resolvedAt = receivedAt
+			}
+			tl.ResolvedAt = &resolvedAt
+			if !tl.StartsAt.IsZero() {
+				tl.TimeToResolve = resolvedAt.Sub(tl.StartsAt).String()
+			}
+		}
+	}
+}
+
+// expire forgets the timelines not notified for the retention. Timelines are kept in the order they were last
+// notified, so only the expired ones are visited.
+func (t *Tracker) expire(now time.Time) {
+	if t.retention <= 0 {
+		return
+	}
+	for el := t.order.Front(); el != nil && now.Sub(el.Value.(*entry).lastAt) > t.retention; el = t.order.Front() {
+		t.forget(el)
+	}
+}
+
+func (t *Tracker) forget(el *list.Element) {
+	delete(t.timelines, t.order.Remove(el).(*entry).key)
+}
+
+func (tl *Timeline) addReceiver(receiver string) {
+	i := sort.SearchStrings(tl.Receivers, receiver)
+	if i < len(tl.Receivers) && tl.Receivers[i] == receiver {
+		return
+	}
+	tl.Receivers = append(tl.Receivers, "")
+	copy(tl.Receivers[i+1:], tl.Receivers[i:])
+	tl.Receivers[i] = receiver
+}
+
+// Get returns a copy of the timeline of tenant for the fingerprint
+func (t *Tracker) Get(tenant, fingerprint string) (Timeline, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	el, ok := t.timelines[key{tenant: tenant, fingerprint: fingerprint}]
+	if !ok {
+		return Timeline{}, false
+	}
+	return el.Value.(*entry).tl.copy(), true
+}
+
+// List returns copies of the timelines of every alert of tenant whose labels satisfy all matchers, sorted by fingerprint
+func (t *Tracker) List(tenant string, matchers labels.Matchers) []Timeline {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	out := []Timeline{}
+	for k, el := range t.timelines {
+		if tl := el.Value.(*entry).tl; k.tenant == tenant && matchers.Matches(tl.Labels) {
+			out = append(out, tl.copy())
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Fingerprint < out[j].Fingerprint })
+	return out
+}
+
+// Reset forgets the timelines of tenant
+func (t *Tracker) Reset(tenant string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for el := t.order.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*entry).key.tenant == tenant {
+			t.forget(el)
+		}
+		el = next
+	}
+}
+
+func (tl *Timeline) copy() Timeline {
+	c := *tl
+	c.Receivers = append([]string(nil), tl.Receivers...)
+	c.Notifications = append([]Notification(nil), tl.Notifications...)
+	if tl.ResolvedAt != nil {
+		resolvedAt := *tl.ResolvedAt
+		c.ResolvedAt = &resolvedAt
+	}
+	return c
+}
+
+// Fingerprint returns the fingerprint of the alert, computing it from the labels when it is not set
+func Fingerprint(a api.Alert) string {
+	if a.Fingerprint != "" {
+		return a.Fingerprint
+	}
+	return labels.Fingerprint(a.Labels)
+}
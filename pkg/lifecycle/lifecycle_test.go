@@ -0,0 +1,127 @@
+package lifecycle
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/labels"
+)
+
+func TestTracker(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	lset := map[string]string{"alertname": "Test", "job": "prometheus24"}
+	firing := api.Alert{Status: "firing", Labels: lset, StartsAt: start}
+	resolved := api.Alert{Status: "resolved", Labels: lset, StartsAt: start, EndsAt: start.Add(10 * time.Minute)}
+
+	tracker := NewTracker(0)
+	tracker.Observe("", api.Message{Receiver: "team-a", Alerts: []api.Alert{firing}}, start.Add(30*time.Second))
+	tracker.Observe("", api.Message{Receiver: "team-b", Alerts: []api.Alert{firing}}, start.Add(30*time.Second))
+	tracker.Observe("", api.Message{Receiver: "team-a", Alerts: []api.Alert{firing}}, start.Add(5*time.Minute))
+	tracker.Observe("", api.Message{Receiver: "team-a", Alerts: []api.Alert{resolved}}, start.Add(11*time.Minute))
+
+	fp := labels.Fingerprint(lset)
+	tl, ok := tracker.Get("", fp)
+	if !ok {
+		t.Fatalf("expected timeline for %s", fp)
+	}
+
+	if tl.Status != "resolved" || tl.ResolvedAt == nil || !tl.ResolvedAt.Equal(start.Add(10*time.Minute)) {
+		t.Fatalf("expected alert to be resolved at end time but got %v", tl)
+	}
+	if tl.TimeToResolve != "10m0s" {
+		t.Fatalf("unexpected time to resolve %s", tl.TimeToResolve)
+	}
+	if !tl.FirstFiringAt.Equal(start.Add(30 * time.Second)) {
+		t.Fatalf("unexpected first firing time %s", tl.FirstFiringAt)
+	}
+	if tl.RepeatNotifications != 2 || len(tl.Notifications) != 4 {
+		t.Fatalf("unexpected notifications %v", tl.Notifications)
+	}
+	if len(tl.Receivers) != 2 || tl.Receivers[0] != "team-a" || tl.Receivers[1] != "team-b" {
+		t.Fatalf("unexpected receivers %v", tl.Receivers)
+	}
+
+	refire := api.Alert{Status: "firing", Labels: lset, StartsAt: start.Add(time.Hour)}
+	tracker.Observe("", api.Message{Receiver: "team-a", Alerts: []api.Alert{refire}}, start.Add(time.Hour))
+	tl, _ = tracker.Get("", fp)
+	if tl.ResolvedAt != nil || !tl.StartsAt.Equal(refire.StartsAt) || tl.Status != "firing" {
+		t.Fatalf("expected alert firing again to reset resolution but got %v", tl)
+	}
+	if !tl.FirstFiringAt.Equal(start.Add(time.Hour)) || tl.RepeatNotifications != 0 {
+		t.Fatalf("expected alert firing again to reset its firing but got %v", tl)
+	}
+}
+
+func TestTracker_List(t *testing.T) {
+	tracker := NewTracker(0)
+	tracker.Observe("", api.Message{
+		Receiver: "webhook",
+		Alerts: []api.Alert{
+			{Status: "firing", Labels: map[string]string{"alertname": "A", "severity": "critical"}},
+			{Status: "firing", Labels: map[string]string{"alertname": "B", "severity": "warning"}, Fingerprint: "given"},
+		},
+	}, time.Now())
+
+	matchers, err := labels.ParseMatchers(`severity=~"crit.*"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := tracker.List("", matchers)
+	if len(got) != 1 || got[0].Labels["alertname"] != "A" {
+		t.Fatalf("unexpected timelines %v", got)
+	}
+
+	if all := tracker.List("", nil); len(all) != 2 {
+		t.Fatalf("expected all timelines but got %v", all)
+	}
+	if _, ok := tracker.Get("", "given"); !ok {
+		t.Fatal("expected the provided fingerprint to be used")
+	}
+	if all := tracker.List("team-a", nil); len(all) != 0 {
+		t.Fatalf("expected another tenant to have no timelines but got %v", all)
+	}
+	if _, ok := tracker.Get("team-a", "given"); ok {
+		t.Fatal("expected another tenant not to read the timeline")
+	}
+
+	tracker.Reset("team-a")
+	if all := tracker.List("", nil); len(all) != 2 {
+		t.Fatalf("expected resetting another tenant to keep the timelines but got %v", all)
+	}
+	tracker.Reset("")
+	if all := tracker.List("", nil); len(all) != 0 {
+		t.Fatalf("expected reset timelines to be forgotten but got %v", all)
+	}
+}
+
+func TestTracker_Forgets(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	msg := func(alerts ...api.Alert) api.Message {
+		return api.Message{Receiver: "webhook", Alerts: alerts}
+	}
+
+	tracker := NewTracker(time.Hour)
+	for i := 0; i <= maxTimelines; i++ {
+		tracker.Observe("", msg(api.Alert{Status: "firing", Fingerprint: fmt.Sprint(i)}), start)
+	}
+	if all := tracker.List("", nil); len(all) != maxTimelines {
+		t.Fatalf("wanted %v got %v", maxTimelines, len(all))
+	}
+	if _, ok := tracker.Get("", "0"); ok {
+		t.Fatal("expected the least recently notified timeline to be forgotten over the limit")
+	}
+
+	repeated := api.Alert{Status: "firing", Fingerprint: "repeated"}
+	for i := 0; i <= maxNotifications; i++ {
+		tracker.Observe("", msg(repeated), start.Add(2*time.Hour+time.Duration(i)*time.Second))
+	}
+	tl, ok := tracker.Get("", "repeated")
+	if !ok || len(tl.Notifications) != maxNotifications || tl.RepeatNotifications != maxNotifications {
+		t.Fatalf("expected the oldest notifications to be dropped over the limit but got %v", tl)
+	}
+	if all := tracker.List("", nil); len(all) != 1 {
+		t.Fatalf("expected timelines not notified within the retention to be forgotten but got %d timelines", len(all))
+	}
+}
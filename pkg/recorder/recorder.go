@@ -0,0 +1,291 @@
+// Package recorder records inbound requests to an NDJSON file and replays recordings against a webhook URL.
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a single recorded request
+type Entry struct {
+	ReceivedAt time.Time           `json:"receivedAt"`
+	Method     string              `json:"method"`
+	Path       string              `json:"path"`
+	Query      string              `json:"query,omitempty"`
+	RemoteAddr string              `json:"remoteAddr"`
+	Headers    map[string][]string `json:"headers"`
+	Body       string              `json:"body"`
+	// Status is the status code the receiver responded with
+	Status int `json:"status"`
+	// Duration is how long the receiver took to respond
+	Duration string `json:"duration"`
+}
+
+// Recorder appends an Entry for each request to a file. It is safe for concurrent use.
+type Recorder struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+	c   io.Closer
+}
+
+// New returns a Recorder writing to w
+func New(w io.Writer) *Recorder {
+	r := &Recorder{w: w, enc: json.NewEncoder(w)}
+	if c, ok := w.(io.Closer); ok {
+		r.c = c
+	}
+	return r
+}
+
+// Open returns a Recorder appending to the file at path, creating it readable only by its owner if needed
+func Open(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	return New(f), nil
+}
+
+// Record writes an entry
+func (r *Recorder) Record(e Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(e)
+}
+
+// Close closes the underlying file
+func (r *Recorder) Close() error {
+	if r.c == nil {
+		return nil
+	}
+	return r.c.Close()
+}
+
+// Redacted replaces the value of each credential header in a recording
+const Redacted = "REDACTED"
+
+// credentialHeaders are redacted before a request is recorded. Authorization holds tenant tokens and SigV4 signatures.
+var credentialHeaders = map[string]bool{
+	"Authorization":        true,
+	"Cookie":               true,
+	"Proxy-Authorization":  true,
+	"X-Amz-Security-Token": true,
+}
+
+// redactHeaders returns a copy of header with the value of every credential header redacted
+func redactHeaders(header http.Header) http.Header {
+	out := make(http.Header, len(header))
+	for name, values := range header {
+		if credentialHeaders[http.CanonicalHeaderKey(name)] {
+			redacted := make([]string, len(values))
+			for i := range redacted {
+				redacted[i] = Redacted
+			}
+			out[name] = redacted
+			continue
+		}
+		out[name] = append([]string(nil), values...)
+	}
+	return out
+}
+
+// Middleware records every request handled by next along with its response status and duration.
+// Credential headers are redacted in the recording.
+func (r *Recorder) Middleware(next http.Handler, onError func(error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			onError(fmt.Errorf("failed to read request body: %w", err))
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, req)
+
+		err = r.Record(Entry{
+			ReceivedAt: start.UTC(),
+			Method:     req.Method,
+			Path:       req.URL.Path,
+			Query:      req.URL.RawQuery,
+			RemoteAddr: req.RemoteAddr,
+			Headers:    redactHeaders(req.Header),
+			Body:       string(body),
+			Status:     sw.status,
+			Duration:   time.Since(start).String(),
+		})
+		if err != nil {
+			onError(fmt.Errorf("failed to record request: %w", err))
+		}
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Reader decodes the entries of a recording one at a time, so that a recording is never held in memory
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewReader returns a Reader decoding the recording in r
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	return &Reader{scanner: scanner}
+}
+
+// Next returns the next entry of the recording, or io.EOF once every entry was read
+func (r *Reader) Next() (Entry, error) {
+	for r.scanner.Scan() {
+		r.line++
+		if len(bytes.TrimSpace(r.scanner.Bytes())) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(r.scanner.Bytes(), &e); err != nil {
+			return Entry{}, fmt.Errorf("invalid entry on line %d: %w", r.line, err)
+		}
+		return e, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Entry{}, err
+	}
+	return Entry{}, io.EOF
+}
+
+// Result is the outcome of replaying a single entry
+type Result struct {
+	Entry  Entry
+	Status int
+	Err    error
+}
+
+// Replayer re-sends recorded entries to a target URL
+type Replayer struct {
+	Client *http.Client
+	// Target is the URL every entry is sent to
+	Target string
+	// KeepPath appends the recorded path and query to Target
+	KeepPath bool
+	// Speed scales the recorded inter-arrival times. 1 preserves the original timing,
+	// 10 replays ten times faster and 0 sends each entry as soon as the previous completes.
+	Speed float64
+	// Header is set on every request, replacing the recorded values. It supplies the credentials that
+	// were redacted in the recording.
+	Header http.Header
+
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// hopHeaders are not copied to replayed requests
+var hopHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Host":              true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// Replay sends every entry read from entries in order, calling onResult after each
+func (r *Replayer) Replay(ctx context.Context, entries *Reader, onResult func(Result)) error {
+	sleep := r.sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var previous *Entry
+	for {
+		e, err := entries.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read recording: %w", err)
+		}
+		if previous != nil && r.Speed > 0 {
+			gap := e.ReceivedAt.Sub(previous.ReceivedAt)
+			if gap > 0 {
+				if err := sleep(ctx, time.Duration(float64(gap)/r.Speed)); err != nil {
+					return err
+				}
+			}
+		}
+
+		status, err := r.send(ctx, client, e)
+		onResult(Result{Entry: e, Status: status, Err: err})
+		previous = &e
+	}
+}
+
+func (r *Replayer) send(ctx context.Context, client *http.Client, e Entry) (int, error) {
+	target := r.Target
+	if r.KeepPath {
+		target = strings.TrimSuffix(target, "/") + e.Path
+		if e.Query != "" {
+			target += "?" + e.Query
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, e.Method, target, strings.NewReader(e.Body))
+	if err != nil {
+		return 0, err
+	}
+	for name, values := range e.Headers {
+		if hopHeaders[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+		for _, v := range values {
+			// a redacted credential would only be refused, so it is left for Header to supply
+			if v != Redacted {
+				req.Header.Add(name, v)
+			}
+		}
+	}
+	for name, values := range r.Header {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
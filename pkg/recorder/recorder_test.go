@@ -0,0 +1,123 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorder_Middleware(t *testing.T) {
+	var buf bytes.Buffer
+	rec := New(&buf)
+	handler := rec.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if string(b) != `{"status":"firing"}` {
+			t.Fatalf("expected handler to see the original body but got %s", b)
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Fatalf("expected handler to see the original headers but got %v", r.Header)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}), func(err error) { t.Fatal(err) })
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook?x=1", strings.NewReader(`{"status":"firing"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := NewReader(&buf)
+	e, err := entries.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entries.Next(); err != io.EOF {
+		t.Fatalf("expected a single entry but got %v", err)
+	}
+	if e.Path != "/webhook" || e.Query != "x=1" || e.Body != `{"status":"firing"}` || e.Status != http.StatusAccepted {
+		t.Fatalf("unexpected entry %v", e)
+	}
+	if e.Headers["Content-Type"][0] != "application/json" || e.ReceivedAt.IsZero() {
+		t.Fatalf("unexpected entry %v", e)
+	}
+	if got := e.Headers["Authorization"]; len(got) != 1 || got[0] != Redacted {
+		t.Fatalf("expected the credentials to be redacted but got %v", got)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.ndjson")
+	rec, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("wanted %v got %v", os.FileMode(0o600), info.Mode().Perm())
+	}
+}
+
+func TestReplayer_Replay(t *testing.T) {
+	var received, credentials []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = append(received, r.URL.Path+" "+r.Header.Get("Content-Type")+" "+string(b))
+		credentials = append(credentials, strings.Join(r.Header.Values("Authorization"), ",")+" "+r.Header.Get("Cookie"))
+	}))
+	t.Cleanup(ts.Close)
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	var recording bytes.Buffer
+	for _, e := range []Entry{
+		{ReceivedAt: start, Method: http.MethodPost, Path: "/webhook", Body: "a", Headers: map[string][]string{"Content-Type": {"application/json"}, "Content-Length": {"1"}, "Authorization": {Redacted}}},
+		{ReceivedAt: start.Add(10 * time.Second), Method: http.MethodPost, Path: "/other", Body: "b"},
+		{ReceivedAt: start.Add(40 * time.Second), Method: http.MethodPost, Path: "/webhook", Body: "c", Headers: map[string][]string{"Cookie": {Redacted}}},
+	} {
+		if err := New(&recording).Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var slept []time.Duration
+	r := &Replayer{
+		Target:   ts.URL,
+		KeepPath: true,
+		Speed:    10,
+		Header:   http.Header{"Authorization": {"Bearer fresh"}},
+		sleep: func(ctx context.Context, d time.Duration) error {
+			slept = append(slept, d)
+			return nil
+		},
+	}
+
+	var results []Result
+	if err := r.Replay(context.Background(), NewReader(&recording), func(res Result) { results = append(results, res) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(slept) != 2 || slept[0] != time.Second || slept[1] != 3*time.Second {
+		t.Fatalf("expected inter-arrival times to be scaled but got %v", slept)
+	}
+	if len(results) != 3 || results[0].Status != http.StatusOK || results[0].Err != nil {
+		t.Fatalf("unexpected results %v", results)
+	}
+	expect := []string{"/webhook application/json a", "/other  b", "/webhook  c"}
+	if strings.Join(received, "|") != strings.Join(expect, "|") {
+		t.Fatalf("wanted %v got %v", expect, received)
+	}
+	// redacted credentials are dropped and the supplied ones are sent instead
+	expect = []string{"Bearer fresh ", "Bearer fresh ", "Bearer fresh "}
+	if strings.Join(credentials, "|") != strings.Join(expect, "|") {
+		t.Fatalf("wanted %v got %v", expect, credentials)
+	}
+}
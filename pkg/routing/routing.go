@@ -0,0 +1,196 @@
+// Package routing reimplements the Alertmanager routing tree so that the receivers and group keys
+// an alert is expected to be notified with can be computed from an Alertmanager configuration file.
+// Only the fields that affect routing and grouping are read. Time intervals are not taken into account.
+package routing
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/labels"
+)
+
+const groupByAll = "..."
+
+// config is the subset of the Alertmanager configuration file used for routing
+type config struct {
+	Route *routeConfig `yaml:"route"`
+}
+
+type routeConfig struct {
+	Receiver string            `yaml:"receiver"`
+	GroupBy  []string          `yaml:"group_by"`
+	Match    map[string]string `yaml:"match"`
+	MatchRE  map[string]string `yaml:"match_re"`
+	Matchers []string          `yaml:"matchers"`
+	Continue bool              `yaml:"continue"`
+	Routes   []*routeConfig    `yaml:"routes"`
+}
+
+// Route is a node of the routing tree
+type Route struct {
+	Receiver string
+	Continue bool
+	Matchers labels.Matchers
+	Routes   []*Route
+
+	groupBy    map[string]bool
+	groupByAll bool
+	parent     *Route
+}
+
+// Destination is a receiver and group key a notification is sent with
+type Destination struct {
+	Receiver string `json:"receiver"`
+	GroupKey string `json:"groupKey"`
+}
+
+func (d Destination) String() string {
+	return d.Receiver + " " + d.GroupKey
+}
+
+// Parse builds the routing tree from the contents of an Alertmanager configuration file
+func Parse(b []byte) (*Route, error) {
+	var cfg config
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration: %w", err)
+	}
+	if cfg.Route == nil {
+		return nil, fmt.Errorf("no route provided in configuration")
+	}
+	if cfg.Route.Receiver == "" {
+		return nil, fmt.Errorf("root route must specify a default receiver")
+	}
+	if len(cfg.Route.Match) > 0 || len(cfg.Route.MatchRE) > 0 || len(cfg.Route.Matchers) > 0 {
+		return nil, fmt.Errorf("root route must not have any matchers")
+	}
+	return newRoute(cfg.Route, nil)
+}
+
+func newRoute(cfg *routeConfig, parent *Route) (*Route, error) {
+	r := &Route{Receiver: cfg.Receiver, Continue: cfg.Continue, parent: parent}
+	if parent != nil {
+		if r.Receiver == "" {
+			r.Receiver = parent.Receiver
+		}
+		r.groupBy, r.groupByAll = parent.groupBy, parent.groupByAll
+	}
+	if cfg.GroupBy != nil {
+		r.groupBy, r.groupByAll = make(map[string]bool), false
+		for _, name := range cfg.GroupBy {
+			if name == groupByAll {
+				r.groupByAll = true
+				continue
+			}
+			r.groupBy[name] = true
+		}
+	}
+
+	for name, value := range cfg.Match {
+		m, err := labels.NewMatcher(labels.MatchEqual, name, value)
+		if err != nil {
+			return nil, err
+		}
+		r.Matchers = append(r.Matchers, m)
+	}
+	for name, value := range cfg.MatchRE {
+		m, err := labels.NewMatcher(labels.MatchRegexp, name, value)
+		if err != nil {
+			return nil, err
+		}
+		r.Matchers = append(r.Matchers, m)
+	}
+	for _, s := range cfg.Matchers {
+		ms, err := labels.ParseMatchers(s)
+		if err != nil {
+			return nil, err
+		}
+		r.Matchers = append(r.Matchers, ms...)
+	}
+	sortMatchers(r.Matchers)
+
+	for _, child := range cfg.Routes {
+		cr, err := newRoute(child, r)
+		if err != nil {
+			return nil, err
+		}
+		r.Routes = append(r.Routes, cr)
+	}
+	return r, nil
+}
+
+// sortMatchers orders matchers by name, value and type as Alertmanager does before computing route keys
+func sortMatchers(ms labels.Matchers) {
+	sort.SliceStable(ms, func(i, j int) bool {
+		if ms[i].Name != ms[j].Name {
+			return ms[i].Name < ms[j].Name
+		}
+		if ms[i].Value != ms[j].Value {
+			return ms[i].Value < ms[j].Value
+		}
+		return ms[i].Type < ms[j].Type
+	})
+}
+
+// Match returns the routes that an alert with the label set is sent to.
+// The first matching child stops the search unless it is marked as continue,
+// and a route with no matching children matches itself.
+func (r *Route) Match(lset map[string]string) []*Route {
+	if !r.Matchers.Matches(lset) {
+		return nil
+	}
+
+	var all []*Route
+	for _, child := range r.Routes {
+		matches := child.Match(lset)
+		all = append(all, matches...)
+		if matches != nil && !child.Continue {
+			break
+		}
+	}
+	if len(all) == 0 {
+		all = append(all, r)
+	}
+	return all
+}
+
+// Key identifies the route by the matchers of itself and each of its parents
+func (r *Route) Key() string {
+	var b strings.Builder
+	if r.parent != nil {
+		b.WriteString(r.parent.Key())
+		b.WriteRune('/')
+	}
+	b.WriteString(r.Matchers.String())
+	return b.String()
+}
+
+// GroupKey returns the key of the aggregation group an alert with the label set belongs to on this route
+func (r *Route) GroupKey(lset map[string]string) string {
+	var names []string
+	for name := range lset {
+		if r.groupByAll || r.groupBy[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+strconv.Quote(lset[name]))
+	}
+	return r.Key() + ":{" + strings.Join(pairs, ", ") + "}"
+}
+
+// Destinations returns the receiver and group key for each route the label set matches
+func (r *Route) Destinations(lset map[string]string) []Destination {
+	var out []Destination
+	for _, route := range r.Match(lset) {
+		out = append(out, Destination{Receiver: route.Receiver, GroupKey: route.GroupKey(lset)})
+	}
+	return out
+}
@@ -0,0 +1,99 @@
+package routing
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testConfig = `
+global:
+  resolve_timeout: 5m
+route:
+  receiver: default
+  group_by: [alertname]
+  routes:
+    - receiver: database
+      match:
+        service: database
+      group_by: [alertname, instance]
+      continue: true
+    - receiver: critical
+      matchers:
+        - severity=~"critical|page"
+        - env!="dev"
+      routes:
+        - receiver: prod-critical
+          matchers: ['env="prod"']
+          group_by: ['...']
+    - receiver: never
+      match_re:
+        service: '.+'
+receivers:
+  - name: default
+  - name: database
+  - name: critical
+  - name: prod-critical
+  - name: never
+`
+
+func TestRoute_Destinations(t *testing.T) {
+	root, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		lset   map[string]string
+		expect []Destination
+	}{
+		{
+			name:   "default",
+			lset:   map[string]string{"alertname": "Test", "job": "prometheus24"},
+			expect: []Destination{{Receiver: "default", GroupKey: `{}:{alertname="Test"}`}},
+		},
+		{
+			name: "continue",
+			lset: map[string]string{"alertname": "DBDown", "service": "database", "instance": "db-1"},
+			expect: []Destination{
+				{Receiver: "database", GroupKey: `{}/{service="database"}:{alertname="DBDown", instance="db-1"}`},
+				{Receiver: "never", GroupKey: `{}/{service=~".+"}:{alertname="DBDown"}`},
+			},
+		},
+		{
+			name:   "inherited receiver and grouping",
+			lset:   map[string]string{"alertname": "Down", "severity": "page", "env": "staging"},
+			expect: []Destination{{Receiver: "critical", GroupKey: `{}/{env!="dev",severity=~"critical|page"}:{alertname="Down"}`}},
+		},
+		{
+			name: "nested group by all",
+			lset: map[string]string{"alertname": "Down", "severity": "critical", "env": "prod"},
+			expect: []Destination{{
+				Receiver: "prod-critical",
+				GroupKey: `{}/{env!="dev",severity=~"critical|page"}/{env="prod"}:{alertname="Down", env="prod", severity="critical"}`,
+			}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := root.Destinations(tc.lset)
+			if !reflect.DeepEqual(got, tc.expect) {
+				t.Fatalf("wanted %v got %v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, cfg := range []string{
+		`receivers: []`,
+		`route: {group_by: [alertname]}`,
+		`route: {receiver: default, match: {a: b}}`,
+		`route: {receiver: default, routes: [{matchers: ['a=~"("']}]}`,
+	} {
+		if _, err := Parse([]byte(cfg)); err == nil {
+			t.Fatalf("expected %s to fail to parse", cfg)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Package sigv4 verifies requests signed with AWS Signature Version 4 using static credentials.
+// See https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm  = "AWS4-HMAC-SHA256"
+	timeFormat = "20060102T150405Z"
+)
+
+const (
+	ErrMissingSignature = Error("missing signature")
+	ErrMalformed        = Error("malformed authorization header")
+	ErrUnknownAccessKey = Error("unknown access key")
+	ErrSignatureExpired = Error("signature expired")
+	ErrMismatch         = Error("signature does not match")
+	ErrPayloadMismatch  = Error("payload hash does not match the body")
+)
+
+// unsignedPayload is the X-Amz-Content-Sha256 of a request whose body is not part of the signature
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// Error is returned when a request fails verification
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// Credentials are the static credentials requests are expected to be signed with
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// Verifier checks the SigV4 signature of incoming requests
+type Verifier struct {
+	Credentials Credentials
+	// MaxSkew is the allowed difference between the signing time and now. Zero disables the check.
+	MaxSkew time.Duration
+	now     func() time.Time
+}
+
+// NewVerifier returns a Verifier for the provided credentials allowing the same 15 minute skew as AWS
+func NewVerifier(creds Credentials) *Verifier {
+	return &Verifier{Credentials: creds, MaxSkew: 15 * time.Minute, now: time.Now}
+}
+
+type authorization struct {
+	accessKey     string
+	date          string
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+}
+
+// Verify checks the signature of r against body, which must be the full request payload
+func (v *Verifier) Verify(r *http.Request, body []byte) error {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return ErrMissingSignature
+	}
+	auth, err := parseAuthorization(header)
+	if err != nil {
+		return err
+	}
+	if auth.accessKey != v.Credentials.AccessKeyID {
+		return ErrUnknownAccessKey
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if amzDate == "" {
+		amzDate = r.Header.Get("Date")
+	}
+	signedAt, err := time.Parse(timeFormat, amzDate)
+	if err != nil {
+		return fmt.Errorf("invalid X-Amz-Date %q: %w", amzDate, ErrMalformed)
+	}
+	if !strings.HasPrefix(amzDate, auth.date) {
+		return fmt.Errorf("credential date does not match X-Amz-Date: %w", ErrMalformed)
+	}
+	if v.MaxSkew > 0 {
+		now := time.Now
+		if v.now != nil {
+			now = v.now
+		}
+		if d := now().Sub(signedAt); d > v.MaxSkew || d < -v.MaxSkew {
+			return ErrSignatureExpired
+		}
+	}
+
+	hash, err := payloadHash(r, body)
+	if err != nil {
+		return err
+	}
+	scope := strings.Join([]string{auth.date, auth.region, auth.service, "aws4_request"}, "/")
+	canonical := canonicalRequest(r, auth.signedHeaders, hash)
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := strings.Join([]string{algorithm, amzDate, scope, hex.EncodeToString(sum[:])}, "\n")
+
+	key := signingKey(v.Credentials.SecretAccessKey, auth.date, auth.region, auth.service)
+	expect := hex.EncodeToString(hmacSHA256(key, []byte(toSign)))
+	if !hmac.Equal([]byte(expect), []byte(auth.signature)) {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func parseAuthorization(header string) (authorization, error) {
+	var auth authorization
+	if !strings.HasPrefix(header, algorithm+" ") {
+		return auth, ErrMalformed
+	}
+
+	for _, part := range strings.Split(strings.TrimPrefix(header, algorithm+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return auth, ErrMalformed
+		}
+		switch kv[0] {
+		case "Credential":
+			scope := strings.Split(kv[1], "/")
+			if len(scope) != 5 || scope[4] != "aws4_request" {
+				return auth, fmt.Errorf("invalid credential scope %q: %w", kv[1], ErrMalformed)
+			}
+			auth.accessKey, auth.date, auth.region, auth.service = scope[0], scope[1], scope[2], scope[3]
+		case "SignedHeaders":
+			auth.signedHeaders = strings.Split(kv[1], ";")
+		case "Signature":
+			auth.signature = kv[1]
+		}
+	}
+
+	if auth.accessKey == "" || auth.signature == "" || len(auth.signedHeaders) == 0 {
+		return auth, ErrMalformed
+	}
+	return auth, nil
+}
+
+func canonicalRequest(r *http.Request, signedHeaders []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, name := range signedHeaders {
+		values := r.Header.Values(name)
+		if name == "host" {
+			values = []string{r.Host}
+		}
+		// the values are copied as trimming them in place would modify the request
+		trimmed := make([]string, 0, len(values))
+		for _, v := range values {
+			trimmed = append(trimmed, strings.Join(strings.Fields(v), " "))
+		}
+		headers.WriteString(name + ":" + strings.Join(trimmed, ",") + "\n")
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		canonicalURI(r.URL),
+		canonicalQuery(r.URL.Query()),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func canonicalURI(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if unescaped, err := url.PathUnescape(s); err == nil {
+			s = unescaped
+		}
+		segments[i] = escape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(values url.Values) string {
+	var pairs []string
+	for k, vs := range values {
+		for _, v := range vs {
+			pairs = append(pairs, escape(k)+"="+escape(v))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// payloadHash returns the hash of body the request was signed with. A hash sent in X-Amz-Content-Sha256
+// must match body unless the payload is unsigned.
+func payloadHash(r *http.Request, body []byte) (string, error) {
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	h := r.Header.Get("X-Amz-Content-Sha256")
+	switch {
+	case h == "":
+		return hash, nil
+	case h == unsignedPayload:
+		return h, nil
+	case !strings.EqualFold(h, hash):
+		return "", ErrPayloadMismatch
+	}
+	return h, nil
+}
+
+// escape encodes s as described by RFC 3986 leaving only unreserved characters as is
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func signingKey(secret, date, region, service string) []byte {
+	k := hmacSHA256([]byte("AWS4"+secret), []byte(date))
+	k = hmacSHA256(k, []byte(region))
+	k = hmacSHA256(k, []byte(service))
+	return hmacSHA256(k, []byte("aws4_request"))
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
@@ -0,0 +1,103 @@
+package sigv4
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The request and signature are the get-vanilla case from the AWS SigV4 test suite
+func getVanillaRequest(t *testing.T) *http.Request {
+	t.Helper()
+	r, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("X-Amz-Date", "20150830T123600Z")
+	r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31")
+	return r
+}
+
+func testVerifier() *Verifier {
+	v := NewVerifier(Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"})
+	v.now = func() time.Time {
+		return time.Date(2015, 8, 30, 12, 40, 0, 0, time.UTC)
+	}
+	return v
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	if err := testVerifier().Verify(getVanillaRequest(t), nil); err != nil {
+		t.Fatalf("expected signature to verify but got %v", err)
+	}
+}
+
+func TestVerifier_VerifyFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(r *http.Request, v *Verifier)
+		expect error
+	}{
+		{
+			name:   "missing authorization",
+			modify: func(r *http.Request, v *Verifier) { r.Header.Del("Authorization") },
+			expect: ErrMissingSignature,
+		},
+		{
+			name:   "wrong secret",
+			modify: func(r *http.Request, v *Verifier) { v.Credentials.SecretAccessKey = "other" },
+			expect: ErrMismatch,
+		},
+		{
+			name:   "unknown access key",
+			modify: func(r *http.Request, v *Verifier) { v.Credentials.AccessKeyID = "other" },
+			expect: ErrUnknownAccessKey,
+		},
+		{
+			name:   "tampered request",
+			modify: func(r *http.Request, v *Verifier) { r.URL.RawQuery = "Action=Publish" },
+			expect: ErrMismatch,
+		},
+		{
+			name: "payload hash mismatch",
+			modify: func(r *http.Request, v *Verifier) {
+				r.Header.Set("X-Amz-Content-Sha256", strings.Repeat("0", 64))
+			},
+			expect: ErrPayloadMismatch,
+		},
+		{
+			name: "expired",
+			modify: func(r *http.Request, v *Verifier) {
+				v.now = func() time.Time { return time.Date(2015, 8, 31, 0, 0, 0, 0, time.UTC) }
+			},
+			expect: ErrSignatureExpired,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, v := getVanillaRequest(t), testVerifier()
+			tc.modify(r, v)
+			if err := v.Verify(r, nil); !errors.Is(err, tc.expect) {
+				t.Fatalf("wanted %v got %v", tc.expect, err)
+			}
+		})
+	}
+}
+
+func TestVerifier_VerifyKeepsHeaders(t *testing.T) {
+	r := getVanillaRequest(t)
+	r.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	r.Header.Set("My-Header1", "  value   with  spaces ")
+	r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;my-header1;x-amz-date, Signature=0000")
+	if err := testVerifier().Verify(r, nil); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("wanted %v got %v", ErrMismatch, err)
+	}
+	if got := r.Header.Get("My-Header1"); got != "  value   with  spaces " {
+		t.Fatalf("expected verification to leave the header unchanged but got %q", got)
+	}
+}
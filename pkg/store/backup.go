@@ -0,0 +1,178 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// Backuper is implemented by stores that support consistent online backups
+type Backuper interface {
+	// Backup writes every entry written after the version since to w and
+	// returns the version to pass as since for the next incremental backup
+	Backup(w io.Writer, since uint64) (uint64, error)
+	// Restore loads a backup written by Backup
+	Restore(r io.Reader) error
+	// Empty reports whether the store holds no entries to restore over
+	Empty() (bool, error)
+}
+
+const backupExt = ".bak"
+
+// BackupDir writes incremental backups to a local directory. Each file holds the entries written since the
+// previous one, so restoring requires every file in the directory to be loaded in order.
+type BackupDir struct {
+	mu     sync.Mutex
+	dir    string
+	b      Backuper
+	since  uint64
+	logger log.Logger
+	now    func() time.Time
+}
+
+// NewBackupDir returns a BackupDir writing backups of b to dir, creating it if needed.
+// The incremental chain continues from the most recent backup already in dir.
+func NewBackupDir(dir string, b Backuper, logger log.Logger) (*BackupDir, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create backup dir: %w", err)
+	}
+	files, err := backupFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	bd := &BackupDir{dir: dir, b: b, logger: logger, now: time.Now}
+	if len(files) > 0 {
+		bd.since = backupVersion(files[len(files)-1])
+	}
+	return bd, nil
+}
+
+// Backup writes the entries changed since the previous backup to a new file and returns its path.
+// No file is written and an empty path is returned if nothing changed.
+func (bd *BackupDir) Backup() (string, error) {
+	bd.mu.Lock()
+	defer bd.mu.Unlock()
+
+	tmp, err := os.CreateTemp(bd.dir, ".backup-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	next, err := bd.b.Backup(tmp, bd.since)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+	if next == bd.since {
+		return "", nil
+	}
+
+	// the version suffix allows the chain to continue after a restart
+	name := fmt.Sprintf("backup-%s-%020d%s", bd.now().UTC().Format("20060102T150405Z"), next, backupExt)
+	path := filepath.Join(bd.dir, name)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+	bd.since = next
+	return path, nil
+}
+
+// Run takes a backup every interval until ctx is cancelled
+func (bd *BackupDir) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			path, err := bd.Backup()
+			if err != nil {
+				level.Error(bd.logger).Log("msg", "scheduled backup failed", "err", err)
+				continue
+			}
+			if path != "" {
+				level.Info(bd.logger).Log("msg", "backup written", "path", path)
+			}
+		}
+	}
+}
+
+// RestorePath restores b from path, which is either a single backup file or
+// a directory of backups written by BackupDir. It returns the files loaded.
+func RestorePath(b Backuper, path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		if files, err = backupFiles(path); err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no backups found in %s", path)
+		}
+	}
+
+	for _, file := range files {
+		if err := restoreFile(b, file); err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", file, err)
+		}
+	}
+	return files, nil
+}
+
+func restoreFile(b Backuper, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return b.Restore(f)
+}
+
+// backupFiles returns the backups in dir, oldest first
+func backupFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), "backup-") && strings.HasSuffix(e.Name(), backupExt) {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// backupVersion returns the version a backup file name ends with, zero if there is none
+func backupVersion(file string) uint64 {
+	name := strings.TrimSuffix(filepath.Base(file), backupExt)
+	i := strings.LastIndex(name, "-")
+	v, err := strconv.ParseUint(name[i+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
@@ -0,0 +1,94 @@
+package store_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/log"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store/storetest"
+)
+
+func TestBackupDir(t *testing.T) {
+	ctx := context.Background()
+	from, err := store.NewKeyValueStore("", log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	bd, err := store.NewBackupDir(dir, from, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := from.Set(ctx, "a", storetest.Alerts()); err != nil {
+		t.Fatal(err)
+	}
+	full, err := bd.Backup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full == "" {
+		t.Fatal("expected a full backup to be written")
+	}
+
+	unchanged, err := bd.Backup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged != "" {
+		t.Fatalf("expected no backup when nothing changed but got %s", unchanged)
+	}
+
+	if err := from.Set(ctx, "b", storetest.Alerts()[:1]); err != nil {
+		t.Fatal(err)
+	}
+	incremental, err := bd.Backup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// backups are named by time and version, so that they sort in the order they were written
+	if incremental == "" || incremental <= full {
+		t.Fatalf("expected an incremental backup after %s but got %q", full, incremental)
+	}
+
+	resumed, err := store.NewBackupDir(dir, from, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next, err := resumed.Backup(); err != nil || next != "" {
+		t.Fatalf("expected a resumed backup directory to continue from the latest backup but got %q, %v", next, err)
+	}
+
+	to, err := store.NewKeyValueStore("", log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := store.RestorePath(to, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, []string{full, incremental}) {
+		t.Fatalf("wanted %v got %v", []string{full, incremental}, files)
+	}
+
+	want, _ := from.List(ctx)
+	got, _ := to.List(ctx)
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("wanted %v got %v", want, got)
+	}
+	if empty, err := to.Empty(); err != nil || empty {
+		t.Fatalf("expected restored store not to be empty: %v", err)
+	}
+}
+
+func TestRestorePathEmptyDir(t *testing.T) {
+	s, err := store.NewKeyValueStore("", log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.RestorePath(s, t.TempDir()); err == nil {
+		t.Fatal("expected error restoring from a directory without backups")
+	}
+}
@@ -1,8 +1,13 @@
 package store
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"strconv"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -11,19 +16,59 @@ import (
 	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
 )
 
+// maxPendingRestoreWrites bounds the number of pending writes while restoring a backup
+const maxPendingRestoreWrites = 256
+
+// schemaKey holds the schema version of the database. The leading NUL keeps it apart from the IDs of notifications.
+var schemaKey = []byte("\x00schema_version")
+
+// forwardsPrefix starts the keys holding the forwarding outcomes of each ID
+var forwardsPrefix = []byte(forwardsID(""))
+
+// internalKey reports whether key holds something other than the notifications of an ID
+func internalKey(key []byte) bool {
+	return bytes.Equal(key, schemaKey) || bytes.HasPrefix(key, forwardsPrefix)
+}
+
 type KeyValueStore struct {
 	db *badger.DB
 }
 
+// encryptedIndexCacheSize is the cache badger requires for the indexes of an encrypted database
+const encryptedIndexCacheSize = 100 << 20
+
+// EncryptionOptions encrypt a KeyValueStore at rest. A zero value leaves it in plaintext.
+type EncryptionOptions struct {
+	// Key is the master key of 16, 24 or 32 bytes, selecting AES-128, AES-192 or AES-256
+	Key []byte
+	// DataKeyRotation is how often a new data key is generated for new data. Zero uses the badger default of 10 days.
+	DataKeyRotation time.Duration
+}
+
 // NewKeyValueStore creates a new Store at the provided path
 // If path is empty an in-memory database is used
 func NewKeyValueStore(path string, logger log.Logger) (*KeyValueStore, error) {
+	return NewEncryptedKeyValueStore(path, EncryptionOptions{}, logger)
+}
+
+// NewEncryptedKeyValueStore creates a new Store at the provided path encrypted with enc
+// If path is empty an in-memory database is used
+func NewEncryptedKeyValueStore(path string, enc EncryptionOptions, logger log.Logger) (*KeyValueStore, error) {
 	var (
 		db  *badger.DB
 		err error
 	)
 	opts := badger.DefaultOptions(path)
 	opts.Logger = &wrappedLogger{logger: logger}
+	if len(enc.Key) > 0 {
+		if err := ValidateEncryptionKey(enc.Key); err != nil {
+			return nil, err
+		}
+		opts = opts.WithEncryptionKey(enc.Key).WithIndexCacheSize(encryptedIndexCacheSize)
+		if enc.DataKeyRotation > 0 {
+			opts = opts.WithEncryptionKeyRotationDuration(enc.DataKeyRotation)
+		}
+	}
 
 	if path != "" {
 		db, err = badger.Open(opts)
@@ -35,14 +80,164 @@ func NewKeyValueStore(path string, logger log.Logger) (*KeyValueStore, error) {
 		return nil, fmt.Errorf("failed to open db: %w", err)
 	}
 
-	return &KeyValueStore{db: db}, nil
+	k := &KeyValueStore{db: db}
+	if err := k.initSchema(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open db: %w", err)
+	}
+	return k, nil
+}
+
+// RotateKeyValueStoreKey re-encrypts the data keys of the database at path, which must not be open, from oldKey
+// to newKey. An empty oldKey encrypts a plaintext database, though entries written before stay in plaintext.
+func RotateKeyValueStoreKey(path string, oldKey, newKey []byte) error {
+	if err := ValidateEncryptionKey(newKey); err != nil {
+		return err
+	}
+	opts := badger.KeyRegistryOptions{
+		Dir:                           path,
+		ReadOnly:                      true,
+		EncryptionKey:                 oldKey,
+		EncryptionKeyRotationDuration: badger.DefaultOptions(path).EncryptionKeyRotationDuration,
+	}
+	registry, err := badger.OpenKeyRegistry(opts)
+	if err != nil {
+		return fmt.Errorf("failed to open key registry: %w", err)
+	}
+	defer registry.Close()
+
+	opts.EncryptionKey = newKey
+	return badger.WriteKeyRegistry(registry, opts)
+}
+
+// initSchema records the latest schema version in a new database and rejects a database written by a newer receiver
+func (k *KeyValueStore) initSchema() error {
+	version, err := k.SchemaVersion(context.Background())
+	if err != nil {
+		return err
+	}
+	if version > 0 {
+		return nil
+	}
+	empty, err := k.Empty()
+	if err != nil || !empty {
+		return err
+	}
+	return k.setSchemaVersion(RecordVersion)
+}
+
+// SchemaVersion returns the schema version of the database, which is zero for databases written before it was recorded
+func (k *KeyValueStore) SchemaVersion(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var version int
+	err := k.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(schemaKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			version, err = parseSchemaVersion(v, RecordVersion)
+			return err
+		})
+	})
+	return version, err
+}
+
+func (k *KeyValueStore) setSchemaVersion(version int) error {
+	return k.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(schemaKey, []byte(strconv.Itoa(version)))
+	})
+}
+
+// Migrate rewrites every value written before the latest schema version. Values are written in batches
+// so it must not run concurrently with other writes.
+func (k *KeyValueStore) Migrate(ctx context.Context, dryRun bool) ([]MigrationResult, error) {
+	version, err := k.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []MigrationResult
+	for _, m := range pendingRecordMigrations(version) {
+		n, err := k.migrateValues(ctx, m, dryRun)
+		if err != nil {
+			return results, fmt.Errorf("migration %d: %w", m.Version, err)
+		}
+		results = append(results, MigrationResult{Migration: m.Migration, Entries: n})
+		if dryRun {
+			continue
+		}
+		if err := k.setSchemaVersion(m.Version); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+func (k *KeyValueStore) migrateValues(ctx context.Context, m valueMigration, dryRun bool) (int, error) {
+	wb := k.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	var changed int
+	err := k.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			item := it.Item()
+			if internalKey(item.Key()) {
+				continue
+			}
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			out, ok, err := m.apply(v)
+			if err != nil {
+				return fmt.Errorf("%q: %w", item.Key(), err)
+			}
+			if !ok {
+				continue
+			}
+			changed++
+			if dryRun {
+				continue
+			}
+			if err := wb.Set(item.KeyCopy(nil), out); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if dryRun {
+		return changed, nil
+	}
+	return changed, wb.Flush()
 }
 
-func (k *KeyValueStore) Get(id string) ([]api.Alert, error) {
+func (k *KeyValueStore) Get(ctx context.Context, id string) ([]api.Alert, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !validKey(id) {
+		return nil, ErrInvalidID
+	}
 	var out []api.Alert
 	err := k.db.View(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte(id))
-		if err == badger.ErrKeyNotFound {
+		// an empty ID can never have been saved
+		if err == badger.ErrKeyNotFound || err == badger.ErrEmptyKey {
 			return ErrNotFound
 		}
 		if err != nil {
@@ -50,10 +245,8 @@ func (k *KeyValueStore) Get(id string) ([]api.Alert, error) {
 		}
 
 		err = item.Value(func(val []byte) error {
-			if err := json.Unmarshal(val, &out); err != nil {
-				return err
-			}
-			return nil
+			out, err = decodeAlerts(val)
+			return err
 		})
 		if err != nil {
 			return err
@@ -68,53 +261,187 @@ func (k *KeyValueStore) Get(id string) ([]api.Alert, error) {
 	return out, err
 }
 
-func (k *KeyValueStore) Set(id string, alerts []api.Alert) error {
-	b, err := json.Marshal(alerts)
+func (k *KeyValueStore) Set(ctx context.Context, id string, alerts []api.Alert) error {
+	if !validKey(id) {
+		return ErrInvalidID
+	}
+	b, err := encodeAlerts(alerts)
 	if err != nil {
 		return err
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	return k.db.Update(func(txn *badger.Txn) error {
 		err := txn.Set([]byte(id), b)
 		return err
 	})
 }
 
-func (k *KeyValueStore) List() ([]api.MessageEntry, error) {
-	var entries []api.MessageEntry
-	err := k.db.View(func(txn *badger.Txn) error {
+func (k *KeyValueStore) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !validKey(id) {
+		return ErrInvalidID
+	}
+	// an empty ID can never have been saved
+	if id == "" {
+		return nil
+	}
+	return k.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete([]byte(forwardsID(id))); err != nil {
+			return err
+		}
+		return txn.Delete([]byte(id))
+	})
+}
+
+func (k *KeyValueStore) List(ctx context.Context) ([]api.MessageEntry, error) {
+	return collect(ctx, k.Iterate)
+}
+
+// Iterate reads a consistent snapshot of the database, so entries saved during the iteration are not seen
+func (k *KeyValueStore) Iterate(ctx context.Context, fn func(api.MessageEntry) error) error {
+	return k.db.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		it := txn.NewIterator(opts)
 		defer it.Close()
 
 		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			var alerts []api.Alert
 			item := it.Item()
+			if internalKey(item.Key()) {
+				continue
+			}
 
-			err := item.Value(func(v []byte) error {
-				if err := json.Unmarshal(v, &alerts); err != nil {
-					return err
-				}
-				return nil
+			err := item.Value(func(v []byte) (err error) {
+				alerts, err = decodeAlerts(v)
+				return err
 			})
 			if err != nil {
 				return err
 			}
-			entries = append(entries, api.MessageEntry{
+			err = fn(api.MessageEntry{
 				ID:     string(item.Key()),
 				Alerts: alerts,
 			})
+			if err != nil {
+				return err
+			}
 		}
 		return nil
 	})
+}
+
+// AddForwards appends outcomes to the forwarding outcomes saved under id
+func (k *KeyValueStore) AddForwards(ctx context.Context, id string, outcomes []api.ForwardOutcome) error {
+	if !validKey(id) {
+		return ErrInvalidID
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return k.db.Update(func(txn *badger.Txn) error {
+		// the notification may have been deleted while it was forwarded
+		if _, err := txn.Get([]byte(id)); err == badger.ErrKeyNotFound || err == badger.ErrEmptyKey {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		saved, err := k.forwards(txn, id)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		b, err := json.Marshal(appendForwards(saved, outcomes))
+		if err != nil {
+			return err
+		}
+		return txn.Set([]byte(forwardsID(id)), b)
+	})
+}
+
+// Forwards returns the forwarding outcomes saved under id, oldest first
+func (k *KeyValueStore) Forwards(ctx context.Context, id string) ([]api.ForwardOutcome, error) {
+	if !validKey(id) {
+		return nil, ErrInvalidID
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var out []api.ForwardOutcome
+	err := k.db.View(func(txn *badger.Txn) (err error) {
+		out, err = k.forwards(txn, id)
+		return err
+	})
+	return out, err
+}
+
+func (k *KeyValueStore) forwards(txn *badger.Txn, id string) ([]api.ForwardOutcome, error) {
+	item, err := txn.Get([]byte(forwardsID(id)))
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
-	return entries, nil
+	var out []api.ForwardOutcome
+	err = item.Value(func(v []byte) error {
+		return json.Unmarshal(v, &out)
+	})
+	return out, err
+}
+
+// Backup writes a consistent backup of every entry written after the version since to w.
+// It returns the version to pass as since for the next incremental backup.
+func (k *KeyValueStore) Backup(w io.Writer, since uint64) (uint64, error) {
+	last, err := k.db.Backup(w, since)
+	if err != nil {
+		return since, err
+	}
+	if last <= since {
+		// nothing was written
+		return since, nil
+	}
+	// badger only streams entries with a version greater than since
+	return last, nil
+}
+
+// Restore loads a backup written by Backup. It must not run concurrently with other writes.
+func (k *KeyValueStore) Restore(r io.Reader) error {
+	return k.db.Load(r, maxPendingRestoreWrites)
+}
+
+// Empty reports whether the store holds no entries
+func (k *KeyValueStore) Empty() (bool, error) {
+	empty := true
+	err := k.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if !internalKey(it.Item().Key()) {
+				empty = false
+				return nil
+			}
+		}
+		return nil
+	})
+	return empty, err
+}
+
+// Close closes the underlying database, flushing pending writes to disk
+func (k *KeyValueStore) Close() error {
+	return k.db.Close()
 }
 
 func (k *KeyValueStore) toMessageEntry(key, v []byte) (*api.MessageEntry, error) {
-	var alerts []api.Alert
-	if err := json.Unmarshal(v, &alerts); err != nil {
+	alerts, err := decodeAlerts(v)
+	if err != nil {
 		return nil, err
 	}
 
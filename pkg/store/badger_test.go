@@ -1,65 +1,198 @@
 package store
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"reflect"
 	"testing"
 
+	"github.com/dgraph-io/badger/v3"
 	"github.com/go-kit/log"
+
 	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
 )
 
-func TestKeyValueStore_Set(t *testing.T) {
-	store, err := NewKeyValueStore("", log.NewNopLogger())
+func TestKeyValueStore_Reopen(t *testing.T) {
+	ctx := context.Background()
+	alerts := []api.Alert{{Status: "firing"}}
+	dir := t.TempDir()
+	store, err := NewKeyValueStore(dir, log.NewNopLogger())
 	if err != nil {
 		t.Fatal(err)
 	}
+	if err := store.Set(ctx, "any", alerts); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewKeyValueStore(dir, log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("expected closed database to reopen but got %v", err)
+	}
+	defer reopened.Close()
 
-	if err := store.Set("any", getTestAlerts()); err != nil {
+	result, err := reopened.Get(ctx, "any")
+	if err != nil {
 		t.Fatal(err)
 	}
+	if !reflect.DeepEqual(result, alerts) {
+		t.Fatalf("wanted %v got %v", alerts, result)
+	}
 }
 
-func TestKeyValueStore_Get(t *testing.T) {
-	store, err := NewKeyValueStore("", log.NewNopLogger())
+func TestKeyValueStore_Migrate(t *testing.T) {
+	ctx := context.Background()
+	alerts := []api.Alert{{Status: "firing"}}
+	dir := t.TempDir()
+	store, err := NewKeyValueStore(dir, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// write the database as a receiver did before the schema version was recorded
+	legacy, err := json.Marshal(alerts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(schemaKey); err != nil {
+			return err
+		}
+		return txn.Set([]byte("legacy"), legacy)
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
+	if err := store.Set(ctx, "current", alerts); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
 
-	if err := store.Set("any", getTestAlerts()); err != nil {
+	reopened, err := NewKeyValueStore(dir, log.NewNopLogger())
+	if err != nil {
 		t.Fatal(err)
 	}
+	defer reopened.Close()
+	expectSchemaVersion(ctx, t, reopened, 0)
+	result, err := reopened.Get(ctx, "legacy")
+	if err != nil {
+		t.Fatalf("expected legacy value to be readable before migrating but got %v", err)
+	}
+	if !reflect.DeepEqual(result, alerts) {
+		t.Fatalf("wanted %v got %v", alerts, result)
+	}
 
-	result, err := store.Get("any")
+	results, err := reopened.Migrate(ctx, true)
 	if err != nil {
-		t.Fatal("expected retrieve to succeed")
+		t.Fatal(err)
+	}
+	expect := []MigrationResult{{Migration: recordMigrations[0].Migration, Entries: 1}}
+	if !reflect.DeepEqual(results, expect) {
+		t.Fatalf("wanted %v got %v", expect, results)
 	}
+	expectSchemaVersion(ctx, t, reopened, 0)
 
-	if !reflect.DeepEqual(result, getTestAlerts()) {
-		t.Fatalf("wanted %v got %v", getTestAlerts(), result)
+	results, err = reopened.Migrate(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(results, expect) {
+		t.Fatalf("wanted %v got %v", expect, results)
+	}
+	expectSchemaVersion(ctx, t, reopened, RecordVersion)
+
+	err = reopened.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("legacy"))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			if isBareAlerts(v) {
+				t.Fatalf("expected legacy value to be rewritten but got %s", v)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := reopened.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("wanted 2 entries without the schema version got %v", entries)
 	}
-}
 
-func TestNewKeyValueStore_List(t *testing.T) {
-	store, err := NewKeyValueStore("", log.NewNopLogger())
+	results, err = reopened.Migrate(ctx, false)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if len(results) != 0 {
+		t.Fatalf("expected no pending migrations but got %v", results)
+	}
+}
 
-	if err := store.Set("any", getTestAlerts()); err != nil {
+func TestKeyValueStore_SchemaTooNew(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewKeyValueStore(dir, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.setSchemaVersion(RecordVersion + 1); err != nil {
 		t.Fatal(err)
 	}
-	result, err := store.List()
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewKeyValueStore(dir, log.NewNopLogger())
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("wanted %v got %v", ErrSchemaTooNew, err)
+	}
+}
+
+func TestKeyValueStore_Encryption(t *testing.T) {
+	ctx := context.Background()
+	alerts := []api.Alert{{Status: "firing", Labels: map[string]string{"alertname": "Test"}, Annotations: map[string]string{"summary": "something broke"}}}
+	dir := t.TempDir()
+	store, err := NewEncryptedKeyValueStore(dir, EncryptionOptions{Key: testEncryptionKey}, log.NewNopLogger())
 	if err != nil {
-		t.Fatalf("expected retrieve to succeed but got %v", err)
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, "any", alerts); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
 	}
+	expectNoPlaintext(t, dir, "something broke")
 
-	expect := []api.MessageEntry{
-		{
-			ID:     "any",
-			Alerts: getTestAlerts()},
+	if _, err := NewKeyValueStore(dir, log.NewNopLogger()); err == nil {
+		t.Fatalf("expected an encrypted database to require the key")
+	}
+	if err := RotateKeyValueStoreKey(dir, testEncryptionKey, otherEncryptionKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEncryptedKeyValueStore(dir, EncryptionOptions{Key: testEncryptionKey}, log.NewNopLogger()); err == nil {
+		t.Fatalf("expected the rotated key to be required")
 	}
 
-	if !reflect.DeepEqual(result, expect) {
-		t.Fatalf("wanted %v got %v", expect, result)
+	reopened, err := NewEncryptedKeyValueStore(dir, EncryptionOptions{Key: otherEncryptionKey}, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	result, err := reopened.Get(ctx, "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, alerts) {
+		t.Fatalf("wanted %v got %v", alerts, result)
 	}
 }
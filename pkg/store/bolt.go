@@ -0,0 +1,527 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+const (
+	// boltOpenTimeout bounds the wait for the file lock held by another process
+	boltOpenTimeout = 5 * time.Second
+	// boltHistoryLength bounds the notifications kept in the bucket of each ID
+	boltHistoryLength = 100
+)
+
+// historyBucket holds a nested bucket per ID, each keyed by notification sequence
+var historyBucket = []byte("history")
+
+// forwardsBucket holds the forwarding outcomes of each ID
+var forwardsBucket = []byte("forwards")
+
+var (
+	// metaBucket holds the schema version of the database
+	metaBucket       = []byte("meta")
+	schemaVersionKey = []byte("schema_version")
+)
+
+// BoltStore is a Store backed by a bbolt database file. Every notification saved under an ID is kept
+// under an increasing sequence number, and the latest is returned by Get.
+type BoltStore struct {
+	db     *bolt.DB
+	sealer *sealer
+	// tmpDir is removed on Close when the database was created for an empty path
+	tmpDir string
+}
+
+// NewBoltStore creates a new BoltStore at the provided path
+// If path is empty a database in a temporary directory is used and removed on Close
+func NewBoltStore(path string) (*BoltStore, error) {
+	return NewEncryptedBoltStore(path, nil)
+}
+
+// NewEncryptedBoltStore creates a new BoltStore at the provided path, sealing every notification with key
+// using AES-GCM. Notifications written in plaintext before a key was set are refused until the key is
+// rotated with RotateBoltStoreKey.
+// If path is empty a database in a temporary directory is used and removed on Close
+func NewEncryptedBoltStore(path string, key []byte) (*BoltStore, error) {
+	sealer, err := newSealer(key)
+	if err != nil {
+		return nil, err
+	}
+	var tmpDir string
+	if path == "" {
+		dir, err := os.MkdirTemp("", "webhook-bolt-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to open db: %w", err)
+		}
+		tmpDir, path = dir, filepath.Join(dir, "history.db")
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		if tmpDir != "" {
+			os.RemoveAll(tmpDir)
+		}
+		return nil, fmt.Errorf("failed to open db: %w", err)
+	}
+	err = db.Update(initBoltSchema)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open db: %w", err)
+	}
+	return &BoltStore{db: db, sealer: sealer, tmpDir: tmpDir}, nil
+}
+
+// initBoltSchema creates the buckets, recording the latest schema version in a new database,
+// and rejects a database written by a newer receiver
+func initBoltSchema(tx *bolt.Tx) error {
+	if meta := tx.Bucket(metaBucket); meta != nil {
+		_, err := parseSchemaVersion(meta.Get(schemaVersionKey), RecordVersion)
+		return err
+	}
+	for _, name := range [][]byte{historyBucket, forwardsBucket} {
+		if _, err := tx.CreateBucket(name); err != nil {
+			return err
+		}
+	}
+	meta, err := tx.CreateBucket(metaBucket)
+	if err != nil {
+		return err
+	}
+	return meta.Put(schemaVersionKey, []byte(strconv.Itoa(RecordVersion)))
+}
+
+// SchemaVersion returns the schema version of the database
+func (b *BoltStore) SchemaVersion(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var version int
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		version, err = parseSchemaVersion(tx.Bucket(metaBucket).Get(schemaVersionKey), RecordVersion)
+		return err
+	})
+	return version, err
+}
+
+// Migrate rewrites every notification written before the latest schema version. Each migration is applied
+// in a single transaction.
+func (b *BoltStore) Migrate(ctx context.Context, dryRun bool) ([]MigrationResult, error) {
+	version, err := b.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []MigrationResult
+	for _, m := range pendingRecordMigrations(version) {
+		var n int
+		migrate := func(tx *bolt.Tx) error {
+			if n, err = b.migrateValues(ctx, tx, m.apply, dryRun); err != nil {
+				return err
+			}
+			if dryRun {
+				return nil
+			}
+			return tx.Bucket(metaBucket).Put(schemaVersionKey, []byte(strconv.Itoa(m.Version)))
+		}
+		if dryRun {
+			err = b.db.View(migrate)
+		} else {
+			err = b.db.Update(migrate)
+		}
+		if err != nil {
+			return results, fmt.Errorf("migration %d: %w", m.Version, err)
+		}
+		results = append(results, MigrationResult{Migration: m.Migration, Entries: n})
+	}
+	return results, nil
+}
+
+// migrateValues rewrites the plaintext of every notification with apply, reporting how many changed
+func (b *BoltStore) migrateValues(ctx context.Context, tx *bolt.Tx, apply func(v []byte) ([]byte, bool, error), dryRun bool) (int, error) {
+	var changed int
+	history := tx.Bucket(historyBucket)
+	err := history.ForEach(func(id, _ []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		bucket := history.Bucket(id)
+		// values cannot be put while iterating, so collect them first
+		updates := map[string][]byte{}
+		err := bucket.ForEach(func(seq, v []byte) error {
+			plain, err := b.sealer.reopen(string(id), v)
+			if err != nil {
+				return fmt.Errorf("%q: %w", id, err)
+			}
+			out, ok, err := apply(plain)
+			if err != nil {
+				return fmt.Errorf("%q: %w", id, err)
+			}
+			if !ok {
+				return nil
+			}
+			if updates[string(seq)], err = b.sealer.seal(string(id), out); err != nil {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		changed += len(updates)
+		if dryRun {
+			return nil
+		}
+		for seq, v := range updates {
+			if err := bucket.Put([]byte(seq), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return changed, err
+}
+
+func (b *BoltStore) Get(ctx context.Context, id string) ([]api.Alert, error) {
+	if !validKey(id) {
+		return nil, ErrInvalidID
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var out []api.Alert
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(historyBucket).Bucket([]byte(id))
+		if bucket == nil {
+			return ErrNotFound
+		}
+		_, v := bucket.Cursor().Last()
+		if v == nil {
+			return ErrNotFound
+		}
+		var err error
+		out, err = b.decode(id, v)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (b *BoltStore) Set(ctx context.Context, id string, alerts []api.Alert) error {
+	if !validKey(id) {
+		return ErrInvalidID
+	}
+	v, err := encodeAlerts(alerts)
+	if err != nil {
+		return err
+	}
+	if v, err = b.sealer.seal(id, v); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(historyBucket).CreateBucketIfNotExists([]byte(id))
+		if err != nil {
+			return err
+		}
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(sequenceKey(seq), v); err != nil {
+			return err
+		}
+		if seq <= boltHistoryLength {
+			return nil
+		}
+		return pruneHistory(bucket, sequenceKey(seq-boltHistoryLength+1))
+	})
+}
+
+// pruneHistory deletes the notifications of bucket saved before the one under oldest, so that the
+// database does not grow with every notification. Pages freed by the deletes are reused by later writes.
+func pruneHistory(bucket *bolt.Bucket, oldest []byte) error {
+	c := bucket.Cursor()
+	// a delete moves the cursor, so start again from the first key after each one
+	for k, _ := c.First(); k != nil && bytes.Compare(k, oldest) < 0; k, _ = c.First() {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BoltStore) Delete(ctx context.Context, id string) error {
+	if !validKey(id) {
+		return ErrInvalidID
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(historyBucket).DeleteBucket([]byte(id))
+		if err == bolt.ErrBucketNotFound || err == bolt.ErrBucketNameRequired {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(forwardsBucket).Delete([]byte(id))
+	})
+}
+
+// AddForwards appends outcomes to the forwarding outcomes saved under id
+func (b *BoltStore) AddForwards(ctx context.Context, id string, outcomes []api.ForwardOutcome) error {
+	if !validKey(id) {
+		return ErrInvalidID
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		// the notification may have been deleted while it was forwarded
+		if tx.Bucket(historyBucket).Bucket([]byte(id)) == nil {
+			return nil
+		}
+		saved, err := b.forwards(tx, id)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		v, err := json.Marshal(appendForwards(saved, outcomes))
+		if err != nil {
+			return err
+		}
+		if v, err = b.sealer.seal(forwardsID(id), v); err != nil {
+			return err
+		}
+		return tx.Bucket(forwardsBucket).Put([]byte(id), v)
+	})
+}
+
+// Forwards returns the forwarding outcomes saved under id, oldest first
+func (b *BoltStore) Forwards(ctx context.Context, id string) ([]api.ForwardOutcome, error) {
+	if !validKey(id) {
+		return nil, ErrInvalidID
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var out []api.ForwardOutcome
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		out, err = b.forwards(tx, id)
+		return err
+	})
+	return out, err
+}
+
+func (b *BoltStore) forwards(tx *bolt.Tx, id string) ([]api.ForwardOutcome, error) {
+	// an empty ID can never have been saved
+	if id == "" {
+		return nil, ErrNotFound
+	}
+	v := tx.Bucket(forwardsBucket).Get([]byte(id))
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	plain, err := b.sealer.open(forwardsID(id), v)
+	if err != nil {
+		return nil, err
+	}
+	var out []api.ForwardOutcome
+	if err := json.Unmarshal(plain, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (b *BoltStore) List(ctx context.Context) ([]api.MessageEntry, error) {
+	return collect(ctx, b.Iterate)
+}
+
+// Iterate reads the database in batches so that fn runs outside of a transaction, which would stop the
+// database file from growing. Entries saved or deleted between batches may or may not be seen.
+func (b *BoltStore) Iterate(ctx context.Context, fn func(api.MessageEntry) error) error {
+	var after []byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch, err := b.readBatch(after)
+		if err != nil {
+			return err
+		}
+		for _, e := range batch {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if len(batch) < iterateBatchSize {
+			return nil
+		}
+		after = []byte(batch[len(batch)-1].ID)
+	}
+}
+
+// readBatch returns up to iterateBatchSize entries with an ID after the given one, or from the first when it is nil
+func (b *BoltStore) readBatch(after []byte) ([]api.MessageEntry, error) {
+	var batch []api.MessageEntry
+	err := b.db.View(func(tx *bolt.Tx) error {
+		history := tx.Bucket(historyBucket)
+		c := history.Cursor()
+		id, _ := c.First()
+		if after != nil {
+			if id, _ = c.Seek(after); bytes.Equal(id, after) {
+				id, _ = c.Next()
+			}
+		}
+		for ; id != nil && len(batch) < iterateBatchSize; id, _ = c.Next() {
+			_, v := history.Bucket(id).Cursor().Last()
+			if v == nil {
+				continue
+			}
+			alerts, err := b.decode(string(id), v)
+			if err != nil {
+				return err
+			}
+			batch = append(batch, api.MessageEntry{ID: string(id), Alerts: alerts})
+		}
+		return nil
+	})
+	return batch, err
+}
+
+// RotateBoltStoreKey reseals every notification in the database at path, which must not be open, from oldKey
+// to newKey in a single transaction. An empty oldKey encrypts a plaintext database, and notifications written in
+// plaintext are sealed with newKey too.
+// The file is then compacted so that the pages freed by the rotation no longer hold the previous values.
+func RotateBoltStoreKey(path string, oldKey, newKey []byte) error {
+	if err := ValidateEncryptionKey(newKey); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	b, err := NewEncryptedBoltStore(path, oldKey)
+	if err != nil {
+		return err
+	}
+	next, err := newSealer(newKey)
+	if err != nil {
+		b.Close()
+		return err
+	}
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		return rotateBoltValues(tx, b.sealer, next)
+	})
+	if err == nil {
+		err = compactBoltFile(b.db, path)
+	}
+	if closeErr := b.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// compactBoltFile replaces the file at path with a copy of db holding only its live pages
+func compactBoltFile(db *bolt.DB, path string) error {
+	tmp := path + ".compact"
+	dst, err := bolt.Open(tmp, 0o600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return err
+	}
+	if err := bolt.Compact(dst, db, 0); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compact db: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// rotateBoltValues opens every notification and forwarding outcome with from and seals it with to
+func rotateBoltValues(tx *bolt.Tx, from, to *sealer) error {
+	forwards := tx.Bucket(forwardsBucket)
+	updates := map[string][]byte{}
+	err := forwards.ForEach(func(id, v []byte) error {
+		plain, err := from.reopen(forwardsID(string(id)), v)
+		if err != nil {
+			return fmt.Errorf("forwards of %q: %w", id, err)
+		}
+		updates[string(id)], err = to.seal(forwardsID(string(id)), plain)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for id, v := range updates {
+		if err := forwards.Put([]byte(id), v); err != nil {
+			return err
+		}
+	}
+
+	history := tx.Bucket(historyBucket)
+	return history.ForEach(func(id, _ []byte) error {
+		bucket := history.Bucket(id)
+		updates := map[string][]byte{}
+		err := bucket.ForEach(func(seq, v []byte) error {
+			plain, err := from.reopen(string(id), v)
+			if err != nil {
+				return fmt.Errorf("%q: %w", id, err)
+			}
+			updates[string(seq)], err = to.seal(string(id), plain)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for seq, v := range updates {
+			if err := bucket.Put([]byte(seq), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltStore) decode(id string, v []byte) ([]api.Alert, error) {
+	plain, err := b.sealer.open(id, v)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAlerts(plain)
+}
+
+// Close closes the database file
+func (b *BoltStore) Close() error {
+	err := b.db.Close()
+	if b.tmpDir != "" {
+		os.RemoveAll(b.tmpDir)
+	}
+	return err
+}
+
+// sequenceKey encodes seq big-endian so that keys sort in the order notifications were saved
+func sequenceKey(seq uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, seq)
+	return b
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+func TestBoltStore_Reopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// enough notifications for the sequence to span more than one byte
+	for i := 0; i < 300; i++ {
+		if err := store.Set(ctx, "any", []api.Alert{{Status: fmt.Sprint(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("expected closed database to reopen but got %v", err)
+	}
+	defer reopened.Close()
+
+	result, err := reopened.Get(ctx, "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []api.Alert{{Status: "299"}}
+	if !reflect.DeepEqual(result, expect) {
+		t.Fatalf("wanted %v got %v", expect, result)
+	}
+}
+
+func TestBoltStore_History(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewBoltStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for i := 0; i < boltHistoryLength+10; i++ {
+		if err := store.Set(ctx, "any", []api.Alert{{Status: "firing"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Set(ctx, "any", []api.Alert{{Status: "resolved"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys int
+	err = store.db.View(func(tx *bolt.Tx) error {
+		keys = tx.Bucket(historyBucket).Bucket([]byte("any")).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys != boltHistoryLength {
+		t.Fatalf("wanted %v got %v", boltHistoryLength, keys)
+	}
+	result, err := store.Get(ctx, "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []api.Alert{{Status: "resolved"}}; !reflect.DeepEqual(result, expect) {
+		t.Fatalf("wanted %v got %v", expect, result)
+	}
+}
+
+func TestBoltStore_Migrate(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewBoltStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	expectSchemaVersion(ctx, t, store, RecordVersion)
+	if results, err := store.Migrate(ctx, false); err != nil || len(results) != 0 {
+		t.Fatalf("expected no pending migrations but got %v, %v", results, err)
+	}
+}
+
+func TestBoltStore_SchemaTooNew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(schemaVersionKey, []byte(strconv.Itoa(RecordVersion+1)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewBoltStore(path)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("wanted %v got %v", ErrSchemaTooNew, err)
+	}
+}
+
+func TestBoltStore_Encryption(t *testing.T) {
+	ctx := context.Background()
+	alerts := []api.Alert{{Status: "firing", Labels: map[string]string{"alertname": "Test"}, Annotations: map[string]string{"summary": "something broke"}}}
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, "plaintext", alerts); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	keyed, err := NewEncryptedBoltStore(path, testEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyed.Get(ctx, "plaintext"); !errors.Is(err, ErrNotSealed) {
+		t.Fatalf("wanted %v got %v", ErrNotSealed, err)
+	}
+	if err := keyed.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// encrypt the notifications written before a key was set
+	if err := RotateBoltStoreKey(path, nil, testEncryptionKey); err != nil {
+		t.Fatal(err)
+	}
+	expectNoPlaintext(t, filepath.Dir(path), "something broke")
+
+	encrypted, err := NewEncryptedBoltStore(path, testEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encrypted.Set(ctx, "sealed", alerts); err != nil {
+		t.Fatal(err)
+	}
+	if err := encrypted.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expectNoPlaintext(t, filepath.Dir(path), "something broke")
+
+	if err := RotateBoltStoreKey(path, testEncryptionKey, otherEncryptionKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := RotateBoltStoreKey(path, testEncryptionKey, otherEncryptionKey); err == nil {
+		t.Fatalf("expected rotating with the previous key to fail")
+	}
+
+	unkeyed, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unkeyed.Get(ctx, "sealed"); !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("wanted %v got %v", ErrEncrypted, err)
+	}
+	if err := unkeyed.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewEncryptedBoltStore(path, otherEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	for _, id := range []string{"plaintext", "sealed"} {
+		result, err := reopened.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(result, alerts) {
+			t.Fatalf("wanted %v got %v", alerts, result)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package store_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-kit/log"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store/storetest"
+)
+
+// testKey encrypts the encrypted variants of the backends
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+// backends opens a fresh store for each implementation. Every backend must pass the same conformance suite.
+var backends = map[string]storetest.Factory{
+	"inmem": func(t *testing.T) store.Store {
+		return store.NewInMemStore()
+	},
+	"badger": func(t *testing.T) store.Store {
+		s, err := store.NewKeyValueStore(t.TempDir(), log.NewNopLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	},
+	"sqlite": func(t *testing.T) store.Store {
+		s, err := store.NewSQLStore(filepath.Join(t.TempDir(), "history.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	},
+	"bolt": func(t *testing.T) store.Store {
+		s, err := store.NewBoltStore(filepath.Join(t.TempDir(), "history.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	},
+	"badger-encrypted": func(t *testing.T) store.Store {
+		s, err := store.NewEncryptedKeyValueStore(t.TempDir(), store.EncryptionOptions{Key: testKey}, log.NewNopLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	},
+	"bolt-encrypted": func(t *testing.T) store.Store {
+		s, err := store.NewEncryptedBoltStore(filepath.Join(t.TempDir(), "history.db"), testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	},
+	"redis-encrypted": func(t *testing.T) store.Store {
+		s, err := store.NewEncryptedRedisStore("redis://"+miniredis.RunT(t).Addr(), store.DefaultRedisPrefix, 0, testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	},
+	"namespace": func(t *testing.T) store.Store {
+		return store.Namespace(store.NewInMemStore(), "tenant")
+	},
+	"namespace-sqlite": func(t *testing.T) store.Store {
+		s, err := store.NewSQLStore("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the view does not close the store it shares
+		t.Cleanup(func() { s.Close() })
+		return store.Namespace(s, "tenant")
+	},
+	"redis": func(t *testing.T) store.Store {
+		s, err := store.NewRedisStore("redis://"+miniredis.RunT(t).Addr(), store.DefaultRedisPrefix, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	},
+}
+
+func TestConformance(t *testing.T) {
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			storetest.Run(t, open)
+		})
+	}
+}
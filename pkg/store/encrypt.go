@@ -0,0 +1,112 @@
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"os"
+)
+
+const (
+	// ErrEncrypted is returned when reading a value that was sealed with a key the store was not opened with
+	ErrEncrypted = Error("value is encrypted")
+	// ErrNotSealed is returned when an encrypted store reads a value written in plaintext.
+	// The rotate-key subcommand seals them.
+	ErrNotSealed = Error("value is not sealed with its id")
+)
+
+// sealedPrefix marks a value sealed by a sealer and bound to its ID. Values written in plaintext are JSON
+// and never start with it.
+const sealedPrefix = 0x02
+
+// ValidateEncryptionKey checks that key selects AES-128, AES-192 or AES-256
+func ValidateEncryptionKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	}
+	return fmt.Errorf("encryption key must be 16, 24 or 32 bytes but is %d", len(key))
+}
+
+// LoadEncryptionKey reads the encryption key held in the file at path. A trailing newline is ignored.
+func LoadEncryptionKey(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key: %w", err)
+	}
+	key := bytes.TrimRight(b, "\r\n")
+	if err := ValidateEncryptionKey(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// sealer encrypts the values of the stores that write opaque values with AES-GCM.
+// A nil sealer leaves values in plaintext.
+type sealer struct {
+	aead cipher.AEAD
+}
+
+// newSealer returns a sealer for key, or nil when key is empty
+func newSealer(key []byte) (*sealer, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+	if err := ValidateEncryptionKey(key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &sealer{aead: aead}, nil
+}
+
+// seal encrypts v with the ID it is saved under as additional data, so that a sealed value cannot be
+// moved to another ID
+func (s *sealer) seal(id string, v []byte) ([]byte, error) {
+	if s == nil {
+		return v, nil
+	}
+	out := make([]byte, 1+s.aead.NonceSize(), 1+s.aead.NonceSize()+len(v)+s.aead.Overhead())
+	out[0] = sealedPrefix
+	if _, err := rand.Read(out[1:]); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(out, out[1:], v, []byte(id)), nil
+}
+
+// open returns the plaintext of v saved under id. A sealer refuses values it did not seal, so that a store
+// encrypted at rest never returns a value written to it in plaintext.
+func (s *sealer) open(id string, v []byte) ([]byte, error) {
+	if s != nil && (len(v) == 0 || v[0] != sealedPrefix) {
+		return nil, ErrNotSealed
+	}
+	return s.reopen(id, v)
+}
+
+// reopen returns the plaintext of v saved under id like open, but also accepts values written in plaintext
+// so that they can be sealed
+func (s *sealer) reopen(id string, v []byte) ([]byte, error) {
+	if len(v) == 0 || v[0] != sealedPrefix {
+		return v, nil
+	}
+	if s == nil {
+		return nil, ErrEncrypted
+	}
+	v = v[1:]
+	if len(v) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("sealed value is truncated")
+	}
+	out, err := s.aead.Open(nil, v[:s.aead.NonceSize()], v[s.aead.NonceSize():], []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value, the encryption key may be wrong: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	testEncryptionKey  = []byte("0123456789abcdef")
+	otherEncryptionKey = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func TestSealer(t *testing.T) {
+	s, err := newSealer(testEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := []byte(`[{"status":"firing"}]`)
+	sealed, err := s.seal("a", plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("firing")) {
+		t.Fatalf("expected sealed value to hide the plaintext but got %q", sealed)
+	}
+
+	opened, err := s.open("a", sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plain) {
+		t.Fatalf("wanted %s got %s", plain, opened)
+	}
+	if _, err := s.open("b", sealed); err == nil {
+		t.Fatalf("expected opening a value moved to another id to fail")
+	}
+	if _, err = s.open("a", plain); !errors.Is(err, ErrNotSealed) {
+		t.Fatalf("wanted %v got %v", ErrNotSealed, err)
+	}
+	if opened, err = s.reopen("a", plain); err != nil || !bytes.Equal(opened, plain) {
+		t.Fatalf("expected plaintext to be reopened unchanged but got %s, %v", opened, err)
+	}
+
+	var none *sealer
+	if _, err := none.open("a", sealed); !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("wanted %v got %v", ErrEncrypted, err)
+	}
+	other, err := newSealer(otherEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.open("a", sealed); err == nil {
+		t.Fatalf("expected opening with the wrong key to fail")
+	}
+}
+
+func TestLoadEncryptionKey(t *testing.T) {
+	dir := t.TempDir()
+	for name, tc := range map[string]struct {
+		contents string
+		wantErr  bool
+	}{
+		"trailing newline": {contents: string(testEncryptionKey) + "\n"},
+		"aes-256":          {contents: string(otherEncryptionKey)},
+		"wrong length":     {contents: "short\n", wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(tc.contents), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			key, err := LoadEncryptionKey(path)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error but got key %q", key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(key) != string(bytes.TrimSpace([]byte(tc.contents))) {
+				t.Fatalf("wanted %q got %q", tc.contents, key)
+			}
+		})
+	}
+}
+
+// expectNoPlaintext fails if any file under dir holds s
+func expectNoPlaintext(t *testing.T, dir, s string) {
+	t.Helper()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(b, []byte(s)) {
+			t.Fatalf("expected %s to be encrypted but found %q", path, s)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
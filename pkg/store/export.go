@@ -0,0 +1,131 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+// ExportVersion is the version of the export format written by Export
+const ExportVersion = 1
+
+// ExportContentType is the content type of the export format
+const ExportContentType = "application/x-ndjson"
+
+// ErrInvalidExport is wrapped by errors returned by Import for malformed exports
+const ErrInvalidExport = Error("invalid export")
+
+// ExportHeader is the first line of an export
+type ExportHeader struct {
+	Version int `json:"version"`
+}
+
+// ExportTrailer is the last line of an export. It is only written once every entry was, so an export
+// cut short is rejected by Import.
+type ExportTrailer struct {
+	Entries int `json:"entries"`
+}
+
+// Export writes the full contents of s as NDJSON. The first line is an ExportHeader, each following line
+// is an api.MessageEntry, ordered by ID and holding its forwarding outcomes when s is a ForwardLog, and the
+// last line is an ExportTrailer. Entries are written as they are read from s. Nothing is written before the
+// first entry is read, so that a store that fails straight away leaves w untouched.
+func Export(ctx context.Context, s Store, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	n := 0
+	err := s.Iterate(ctx, func(e api.MessageEntry) error {
+		e, err := withForwards(ctx, s, e)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			if err := enc.Encode(ExportHeader{Version: ExportVersion}); err != nil {
+				return err
+			}
+		}
+		n++
+		return enc.Encode(e)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export store: %w", err)
+	}
+	if n == 0 {
+		if err := enc.Encode(ExportHeader{Version: ExportVersion}); err != nil {
+			return err
+		}
+	}
+	return enc.Encode(ExportTrailer{Entries: n})
+}
+
+// Import reads an export written by Export into s, overwriting any entries with the same ID.
+// Forwarding outcomes are appended to any saved under the same ID when s is a ForwardLog.
+// The export is validated in full before anything is written. It returns the number of entries imported.
+func Import(ctx context.Context, s Store, r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w: missing header", ErrInvalidExport)
+	}
+	var header ExportHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return 0, fmt.Errorf("%w: invalid header: %v", ErrInvalidExport, err)
+	}
+	if header.Version != ExportVersion {
+		return 0, fmt.Errorf("%w: unsupported version %d", ErrInvalidExport, header.Version)
+	}
+
+	var entries []api.MessageEntry
+	var expect int
+	var complete bool
+	for line := 2; scanner.Scan(); line++ {
+		if complete {
+			return 0, fmt.Errorf("%w: unexpected line %d after the last entry", ErrInvalidExport, line)
+		}
+		var e struct {
+			api.MessageEntry
+			// Entries is only set by the trailer
+			Entries *int `json:"entries"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return 0, fmt.Errorf("%w: invalid entry on line %d: %v", ErrInvalidExport, line, err)
+		}
+		if e.Entries != nil {
+			expect, complete = *e.Entries, true
+			continue
+		}
+		if e.ID == "" {
+			return 0, fmt.Errorf("%w: entry on line %d has no id", ErrInvalidExport, line)
+		}
+		entries = append(entries, e.MessageEntry)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if !complete {
+		return 0, fmt.Errorf("%w: missing trailer, the export is incomplete", ErrInvalidExport)
+	}
+	if len(entries) != expect {
+		return 0, fmt.Errorf("%w: expected %d entries but got %d", ErrInvalidExport, expect, len(entries))
+	}
+
+	for i, e := range entries {
+		if err := s.Set(ctx, e.ID, e.Alerts); err != nil {
+			return i, fmt.Errorf("failed to import %q: %w", e.ID, err)
+		}
+		if len(e.Forwards) == 0 {
+			continue
+		}
+		if err := AddForwards(ctx, s, e.ID, e.Forwards); err != nil {
+			return i, fmt.Errorf("failed to import the forwarding outcomes of %q: %w", e.ID, err)
+		}
+	}
+	return len(entries), nil
+}
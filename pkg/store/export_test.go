@@ -0,0 +1,130 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store/storetest"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	from := store.NewInMemStore()
+	for _, id := range []string{"b", "a"} {
+		if err := from.Set(ctx, id, storetest.Alerts()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := store.Export(ctx, from, &buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || lines[0] != `{"version":1}` || !strings.HasPrefix(lines[1], `{"id":"a"`) || lines[3] != `{"entries":2}` {
+		t.Fatalf("unexpected export %s", buf.String())
+	}
+
+	to, err := store.NewKeyValueStore("", log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := store.Import(ctx, to, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("wanted %v got %v", 2, n)
+	}
+
+	result, err := to.Get(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, storetest.Alerts()) {
+		t.Fatalf("wanted %v got %v", storetest.Alerts(), result)
+	}
+}
+
+func TestExportImport_Forwards(t *testing.T) {
+	ctx := context.Background()
+	from := store.NewInMemStore()
+	if err := from.Set(ctx, "a", storetest.Alerts()); err != nil {
+		t.Fatal(err)
+	}
+	outcomes := []api.ForwardOutcome{{Time: time.Unix(1, 0).UTC(), Target: "downstream", URL: "http://downstream.example", Attempts: 1, Status: 200, Duration: "1ms"}}
+	if err := from.AddForwards(ctx, "a", outcomes); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := store.Export(ctx, from, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"forwards":[{`) {
+		t.Fatalf("expected the forwarding outcomes in the export %s", buf.String())
+	}
+
+	to, err := store.NewSQLStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer to.Close()
+	if _, err := store.Import(ctx, to, &buf); err != nil {
+		t.Fatal(err)
+	}
+	result, err := to.Forwards(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, outcomes) {
+		t.Fatalf("wanted %v got %v", outcomes, result)
+	}
+}
+
+func TestExportEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := store.Export(context.Background(), store.NewInMemStore(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "{\"version\":1}\n{\"entries\":0}\n" {
+		t.Fatalf("unexpected export %s", buf.String())
+	}
+	n, err := store.Import(context.Background(), store.NewInMemStore(), &buf)
+	if err != nil || n != 0 {
+		t.Fatalf("wanted %v got %v, %v", 0, n, err)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	ctx := context.Background()
+	for _, invalid := range []string{
+		``,
+		`{"version":0}`,
+		"{\"version\":1}\n",
+		"{\"version\":1}\n{\"id\":\"a\",\"alerts\":[]}\n",
+		"{\"version\":1}\n{\"entries\":1}\n",
+		"{\"version\":1}\n{\"entries\":0}\n{\"id\":\"a\",\"alerts\":[]}\n",
+		"{\"version\":2}\n{\"id\":\"a\",\"alerts\":[]}\n{\"entries\":1}\n",
+		"{\"version\":1}\n{\"alerts\":[]}\n{\"entries\":1}\n",
+		"{\"version\":1}\n{",
+	} {
+		s := store.NewInMemStore()
+		_, err := store.Import(ctx, s, strings.NewReader(invalid))
+		if !errors.Is(err, store.ErrInvalidExport) {
+			t.Fatalf("expected invalid export error importing %q but got %v", invalid, err)
+		}
+		if entries, _ := s.List(ctx); len(entries) != 0 {
+			t.Fatalf("expected nothing to be imported from %q but got %v", invalid, entries)
+		}
+	}
+}
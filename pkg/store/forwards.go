@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+// MaxForwards bounds the forwarding outcomes kept for each ID, dropping the oldest first
+const MaxForwards = 100
+
+// ForwardLog is implemented by stores that persist the outcomes of forwarding the notifications saved
+// under each ID. The outcomes of an ID are deleted with it.
+type ForwardLog interface {
+	// AddForwards appends outcomes to those saved under id
+	AddForwards(ctx context.Context, id string, outcomes []api.ForwardOutcome) error
+	// Forwards returns the outcomes saved under id, oldest first, or ErrNotFound if there are none
+	Forwards(ctx context.Context, id string) ([]api.ForwardOutcome, error)
+}
+
+// AddForwards appends outcomes to those saved under id when s persists them
+func AddForwards(ctx context.Context, s Store, id string, outcomes []api.ForwardOutcome) error {
+	if l, ok := s.(ForwardLog); ok {
+		return l.AddForwards(ctx, id, outcomes)
+	}
+	return nil
+}
+
+// Forwards returns the outcomes saved under id, or ErrNotFound if there are none or s does not persist them
+func Forwards(ctx context.Context, s Store, id string) ([]api.ForwardOutcome, error) {
+	if l, ok := s.(ForwardLog); ok {
+		return l.Forwards(ctx, id)
+	}
+	return nil, ErrNotFound
+}
+
+// forwardsID is the ID the forwarding outcomes of id are saved or sealed under by stores that keep them
+// alongside notifications. The internal prefix keeps it apart from the IDs of notifications.
+func forwardsID(id string) string {
+	return internalPrefix + "forwards\x00" + id
+}
+
+// withForwards sets the forwarding outcomes of e from s
+func withForwards(ctx context.Context, s Store, e api.MessageEntry) (api.MessageEntry, error) {
+	forwards, err := Forwards(ctx, s, e.ID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return e, err
+	}
+	e.Forwards = forwards
+	return e, nil
+}
+
+// appendForwards appends outcomes to saved, keeping the latest MaxForwards
+func appendForwards(saved, outcomes []api.ForwardOutcome) []api.ForwardOutcome {
+	all := append(saved, outcomes...)
+	if len(all) > MaxForwards {
+		all = all[len(all)-MaxForwards:]
+	}
+	return all
+}
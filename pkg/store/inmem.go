@@ -1,77 +1,309 @@
 package store
 
 import (
+	"container/list"
+	"context"
 	"fmt"
+	"sort"
 	"sync"
 
+	"github.com/prometheus/client_golang/prometheus"
+
 	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
 )
 
+// EvictionPolicy selects the entry an InMemoryStore evicts when it exceeds its budget
+type EvictionPolicy string
+
+const (
+	// EvictLRU evicts the entry least recently read or written
+	EvictLRU EvictionPolicy = "lru"
+	// EvictFIFO evicts the entry first written, regardless of later reads or writes
+	EvictFIFO EvictionPolicy = "fifo"
+)
+
+const (
+	evictedEntries = "entries"
+	evictedBytes   = "bytes"
+)
+
+// InMemoryOptions bound the memory used by an InMemoryStore. Zero values are unbounded.
+type InMemoryOptions struct {
+	MaxEntries int
+	// MaxBytes bounds the approximate size of the stored alerts
+	MaxBytes int64
+	Eviction EvictionPolicy
+}
+
+// InMemoryStore keeps the latest alerts for each ID in memory, evicting entries when over its budget.
+// It is safe for concurrent use.
 type InMemoryStore struct {
-	db sync.Map
+	mu    sync.Mutex
+	opts  InMemoryOptions
+	db    map[string]*list.Element
+	order *list.List
+	bytes int64
+
+	evictions *prometheus.CounterVec
+	entries   prometheus.Gauge
+	size      prometheus.Gauge
 }
 
+type inMemEntry struct {
+	id       string
+	alerts   []api.Alert
+	forwards []api.ForwardOutcome
+	size     int64
+}
+
+// NewInMemStore returns an unbounded InMemoryStore
 func NewInMemStore() *InMemoryStore {
-	return &InMemoryStore{db: sync.Map{}}
+	s, _ := NewBoundedInMemStore(InMemoryOptions{}, nil)
+	return s
 }
 
-func (i *InMemoryStore) Get(id string) ([]api.Alert, error) {
-	a, ok := i.db.Load(id)
+// NewBoundedInMemStore returns an InMemoryStore bounded by opts and registers its metrics with reg when it is not nil
+func NewBoundedInMemStore(opts InMemoryOptions, reg prometheus.Registerer) (*InMemoryStore, error) {
+	switch opts.Eviction {
+	case "":
+		opts.Eviction = EvictLRU
+	case EvictLRU, EvictFIFO:
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %q", opts.Eviction)
+	}
+
+	i := &InMemoryStore{
+		opts:  opts,
+		db:    make(map[string]*list.Element),
+		order: list.New(),
+		evictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webhook_store_evictions_total",
+			Help: "Total number of entries evicted from the in-memory store, by the limit that was exceeded.",
+		}, []string{"reason"}),
+		entries: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "webhook_store_entries",
+			Help: "Number of entries in the in-memory store.",
+		}),
+		size: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "webhook_store_bytes",
+			Help: "Approximate size in bytes of the alerts in the in-memory store.",
+		}),
+	}
+	// initialise both reasons so that they are exported before the first eviction
+	i.evictions.WithLabelValues(evictedEntries)
+	i.evictions.WithLabelValues(evictedBytes)
+	if reg != nil {
+		reg.MustRegister(i.evictions, i.entries, i.size)
+	}
+	return i, nil
+}
+
+func (i *InMemoryStore) Get(ctx context.Context, id string) ([]api.Alert, error) {
+	if !validKey(id) {
+		return nil, ErrInvalidID
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	e, ok := i.db[id]
 	if !ok {
 		return nil, ErrNotFound
 	}
-
-	return i.alertFromValue(a)
+	if i.opts.Eviction == EvictLRU {
+		i.order.MoveToBack(e)
+	}
+	return e.Value.(*inMemEntry).alerts, nil
 }
 
-func (i *InMemoryStore) Set(id string, alerts []api.Alert) error {
-	i.db.Store(id, alerts)
+func (i *InMemoryStore) Set(ctx context.Context, id string, alerts []api.Alert) error {
+	if !validKey(id) {
+		return ErrInvalidID
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	size := alertsSize(id, alerts)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if e, ok := i.db[id]; ok {
+		entry := e.Value.(*inMemEntry)
+		i.bytes += size - entry.size
+		entry.alerts, entry.size = alerts, size
+		if i.opts.Eviction == EvictLRU {
+			i.order.MoveToBack(e)
+		}
+	} else {
+		i.db[id] = i.order.PushBack(&inMemEntry{id: id, alerts: alerts, size: size})
+		i.bytes += size
+	}
+	i.evict(id)
+
+	i.entries.Set(float64(len(i.db)))
+	i.size.Set(float64(i.bytes))
 	return nil
 }
 
-func (i *InMemoryStore) List() ([]api.MessageEntry, error) {
-	var (
-		contents []api.MessageEntry
-		err      error
-	)
-
-	i.db.Range(func(key, value interface{}) bool {
-		alerts, e := i.alertFromValue(value)
-		if e != nil {
-			err = e
-			return false
+// evict removes entries in eviction order until the store is within its budget.
+// The entry for keep is never evicted, so a single entry larger than MaxBytes is kept on its own.
+func (i *InMemoryStore) evict(keep string) {
+	for e := i.order.Front(); e != nil; {
+		var reason string
+		switch {
+		case i.opts.MaxEntries > 0 && len(i.db) > i.opts.MaxEntries:
+			reason = evictedEntries
+		case i.opts.MaxBytes > 0 && i.bytes > i.opts.MaxBytes:
+			reason = evictedBytes
+		default:
+			return
 		}
 
-		id, e := i.idFromKey(key)
-		if e != nil {
-			err = e
-			return false
+		next := e.Next()
+		if entry := e.Value.(*inMemEntry); entry.id != keep {
+			i.order.Remove(e)
+			delete(i.db, entry.id)
+			i.bytes -= entry.size
+			i.evictions.WithLabelValues(reason).Inc()
 		}
+		e = next
+	}
+}
+
+func (i *InMemoryStore) Delete(ctx context.Context, id string) error {
+	if !validKey(id) {
+		return ErrInvalidID
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if e, ok := i.db[id]; ok {
+		i.order.Remove(e)
+		delete(i.db, id)
+		i.bytes -= e.Value.(*inMemEntry).size
+	}
+	i.entries.Set(float64(len(i.db)))
+	i.size.Set(float64(i.bytes))
+	return nil
+}
+
+func (i *InMemoryStore) List(ctx context.Context) ([]api.MessageEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
 
+	var contents []api.MessageEntry
+	for id, e := range i.db {
 		contents = append(contents, api.MessageEntry{
 			ID:     id,
-			Alerts: alerts,
+			Alerts: e.Value.(*inMemEntry).alerts,
 		})
-		return true
-	})
-	if err != nil {
-		return nil, err
 	}
+	sort.Slice(contents, func(a, b int) bool { return contents[a].ID < contents[b].ID })
 	return contents, nil
 }
 
-func (i *InMemoryStore) alertFromValue(from interface{}) ([]api.Alert, error) {
-	alerts, ok := from.([]api.Alert)
-	if !ok {
-		return nil, fmt.Errorf("failed assertion from map value to list of alerts: %w", ErrInternal)
+// Iterate holds the lock only while reading each entry, so entries saved or deleted during the iteration
+// may or may not be seen
+func (i *InMemoryStore) Iterate(ctx context.Context, fn func(api.MessageEntry) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	i.mu.Lock()
+	ids := make([]string, 0, len(i.db))
+	for id := range i.db {
+		ids = append(ids, id)
 	}
-	return alerts, nil
+	i.mu.Unlock()
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		i.mu.Lock()
+		var alerts []api.Alert
+		e, ok := i.db[id]
+		if ok {
+			alerts = e.Value.(*inMemEntry).alerts
+		}
+		i.mu.Unlock()
+		if !ok {
+			continue
+		}
+		if err := fn(api.MessageEntry{ID: id, Alerts: alerts}); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (i *InMemoryStore) idFromKey(from interface{}) (string, error) {
-	id, ok := from.(string)
-	if !ok {
-		return "", fmt.Errorf("failed assertion from map key to list of alerts: %w", ErrInternal)
+// AddForwards appends outcomes to those of the entry saved under id. The outcomes of an entry that was
+// evicted or deleted are dropped.
+func (i *InMemoryStore) AddForwards(ctx context.Context, id string, outcomes []api.ForwardOutcome) error {
+	if !validKey(id) {
+		return ErrInvalidID
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if e, ok := i.db[id]; ok {
+		entry := e.Value.(*inMemEntry)
+		entry.forwards = appendForwards(entry.forwards, outcomes)
+	}
+	return nil
+}
+
+func (i *InMemoryStore) Forwards(ctx context.Context, id string) ([]api.ForwardOutcome, error) {
+	if !validKey(id) {
+		return nil, ErrInvalidID
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	e, ok := i.db[id]
+	if !ok || len(e.Value.(*inMemEntry).forwards) == 0 {
+		return nil, ErrNotFound
+	}
+	forwards := e.Value.(*inMemEntry).forwards
+	out := make([]api.ForwardOutcome, len(forwards))
+	copy(out, forwards)
+	return out, nil
+}
+
+// Close is a no-op as nothing is persisted
+func (i *InMemoryStore) Close() error {
+	return nil
+}
+
+// alertSize is a rough per-alert overhead for its fields, timestamps and maps
+const alertSize = 256
+
+// alertsSize approximates the memory held by an entry from the length of its strings
+func alertsSize(id string, alerts []api.Alert) int64 {
+	size := int64(len(id))
+	for _, a := range alerts {
+		size += alertSize + int64(len(a.Status)+len(a.GeneratorURL)+len(a.Fingerprint))
+		for k, v := range a.Labels {
+			size += int64(len(k) + len(v))
+		}
+		for k, v := range a.Annotations {
+			size += int64(len(k) + len(v))
+		}
 	}
-	return id, nil
+	return size
 }
@@ -1,66 +1,124 @@
 package store
 
 import (
+	"context"
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
 	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
 )
 
-func TestInMemoryStore_Set(t *testing.T) {
-	store := NewInMemStore()
-	if err := store.Set("any", getTestAlerts()); err != nil {
-		t.Fatal(err)
-	}
-}
+func TestInMemoryStore_Eviction(t *testing.T) {
+	ctx := context.Background()
+	alerts := []api.Alert{{Status: "firing"}}
+	for _, tc := range []struct {
+		name    string
+		opts    InMemoryOptions
+		expect  []string
+		evicted map[string]float64
+	}{
+		{
+			name:    "unbounded",
+			expect:  []string{"a", "b", "c"},
+			evicted: map[string]float64{evictedEntries: 0, evictedBytes: 0},
+		},
+		{
+			name:    "lru entries",
+			opts:    InMemoryOptions{MaxEntries: 2, Eviction: EvictLRU},
+			expect:  []string{"a", "c"},
+			evicted: map[string]float64{evictedEntries: 1, evictedBytes: 0},
+		},
+		{
+			name:    "fifo entries",
+			opts:    InMemoryOptions{MaxEntries: 2, Eviction: EvictFIFO},
+			expect:  []string{"b", "c"},
+			evicted: map[string]float64{evictedEntries: 1, evictedBytes: 0},
+		},
+		{
+			name:    "lru bytes",
+			opts:    InMemoryOptions{MaxBytes: 2 * alertsSize("a", alerts), Eviction: EvictLRU},
+			expect:  []string{"a", "c"},
+			evicted: map[string]float64{evictedEntries: 0, evictedBytes: 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewBoundedInMemStore(tc.opts, prometheus.NewRegistry())
+			if err != nil {
+				t.Fatal(err)
+			}
+			// reading a makes it the most recently used before c is written
+			for _, id := range []string{"a", "b"} {
+				if err := s.Set(ctx, id, alerts); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := s.Get(ctx, "a"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Set(ctx, "c", alerts); err != nil {
+				t.Fatal(err)
+			}
 
-func TestInMemoryStore_Get(t *testing.T) {
-	store := NewInMemStore()
-	if err := store.Set("any", getTestAlerts()); err != nil {
-		t.Fatal(err)
+			entries, err := s.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, e := range entries {
+				ids = append(ids, e.ID)
+			}
+			if !reflect.DeepEqual(ids, tc.expect) {
+				t.Fatalf("wanted %v got %v", tc.expect, ids)
+			}
+			for reason, expect := range tc.evicted {
+				if got := testutil.ToFloat64(s.evictions.WithLabelValues(reason)); got != expect {
+					t.Fatalf("%s: wanted %v got %v", reason, expect, got)
+				}
+			}
+			if got := testutil.ToFloat64(s.entries); got != float64(len(tc.expect)) {
+				t.Fatalf("wanted %v got %v", len(tc.expect), got)
+			}
+			if got := testutil.ToFloat64(s.size); got != float64(int64(len(tc.expect))*alertsSize("a", alerts)) {
+				t.Fatalf("unexpected size %v", got)
+			}
+		})
 	}
+}
 
-	result, err := store.Get("any")
+func TestInMemoryStore_KeepsOversizedEntry(t *testing.T) {
+	ctx := context.Background()
+	alerts := []api.Alert{{Status: "firing"}}
+	s, err := NewBoundedInMemStore(InMemoryOptions{MaxBytes: 1024}, nil)
 	if err != nil {
-		t.Fatal("expected retrieve to succeed")
+		t.Fatal(err)
 	}
-
-	if !reflect.DeepEqual(result, getTestAlerts()) {
-		t.Fatalf("wanted %v got %v", getTestAlerts(), result)
+	if err := s.Set(ctx, "small", alerts); err != nil {
+		t.Fatal(err)
 	}
-}
-
-func TestInMemoryStore_List(t *testing.T) {
-	store := NewInMemStore()
-	if err := store.Set("any", getTestAlerts()); err != nil {
+	large := []api.Alert{{Status: "firing", Annotations: map[string]string{"description": strings.Repeat("x", 2048)}}}
+	if err := s.Set(ctx, "large", large); err != nil {
 		t.Fatal(err)
 	}
 
-	result, err := store.List()
-	if err != nil {
-		t.Fatal("expected retrieve to succeed")
+	if _, err := s.Get(ctx, "small"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("wanted %v got %v", ErrNotFound, err)
 	}
-
-	expect := []api.MessageEntry{
-		{
-			ID:     "any",
-			Alerts: getTestAlerts()},
+	result, err := s.Get(ctx, "large")
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	if !reflect.DeepEqual(result, expect) {
-		t.Fatalf("wanted %v got %v", expect, result)
+	if !reflect.DeepEqual(result, large) {
+		t.Fatalf("wanted %v got %v", large, result)
 	}
 }
 
-func getTestAlerts() []api.Alert {
-	return []api.Alert{
-		{
-			Status:       "firing",
-			GeneratorURL: "https://test.com",
-		},
-		{
-			Status:       "pending",
-			GeneratorURL: "https://example.com",
-		},
+func TestInMemoryStore_UnknownEviction(t *testing.T) {
+	if _, err := NewBoundedInMemStore(InMemoryOptions{Eviction: "random"}, nil); err == nil {
+		t.Fatal("expected an unknown eviction policy to be rejected")
 	}
 }
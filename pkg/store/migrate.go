@@ -0,0 +1,121 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+// RecordVersion is the schema version of the records written by the key/value stores
+const RecordVersion = 1
+
+// ErrSchemaTooNew is returned when opening a database written by a newer receiver
+const ErrSchemaTooNew = Error("schema version is newer than supported")
+
+// Migration upgrades the data in a store to Version from the version before it
+type Migration struct {
+	Version     int
+	Description string
+}
+
+// MigrationResult reports a migration that was applied, or that would be by a dry run
+type MigrationResult struct {
+	Migration
+	// Entries is the number of stored values rewritten by the migration
+	Entries int
+}
+
+// Migrator is implemented by stores that persist a schema version
+type Migrator interface {
+	// SchemaVersion returns the schema version of the stored data
+	SchemaVersion(ctx context.Context) (int, error)
+	// Migrate applies every pending migration in order. A dry run reports the migrations without writing.
+	Migrate(ctx context.Context, dryRun bool) ([]MigrationResult, error)
+}
+
+// record is the value saved for a notification from RecordVersion 1
+type record struct {
+	Version int         `json:"version"`
+	Alerts  []api.Alert `json:"alerts"`
+}
+
+// valueMigration rewrites a single stored value, reporting whether it changed
+type valueMigration struct {
+	Migration
+	apply func(v []byte) ([]byte, bool, error)
+}
+
+// recordMigrations upgrade the values of the key/value stores, ordered by version
+var recordMigrations = []valueMigration{
+	{
+		Migration: Migration{Version: 1, Description: "wrap the alerts of each notification in a versioned record"},
+		apply:     wrapRecord,
+	},
+}
+
+func encodeAlerts(alerts []api.Alert) ([]byte, error) {
+	return json.Marshal(record{Version: RecordVersion, Alerts: alerts})
+}
+
+func decodeAlerts(v []byte) ([]api.Alert, error) {
+	var alerts []api.Alert
+	if isBareAlerts(v) {
+		if err := json.Unmarshal(v, &alerts); err != nil {
+			return nil, err
+		}
+		return alerts, nil
+	}
+
+	var rec record
+	if err := json.Unmarshal(v, &rec); err != nil {
+		return nil, err
+	}
+	if rec.Version > RecordVersion {
+		return nil, fmt.Errorf("record version %d: %w", rec.Version, ErrSchemaTooNew)
+	}
+	return rec.Alerts, nil
+}
+
+// isBareAlerts reports whether v was written before records were versioned, as a bare array of alerts
+func isBareAlerts(v []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(v), []byte("["))
+}
+
+func wrapRecord(v []byte) ([]byte, bool, error) {
+	if !isBareAlerts(v) {
+		return v, false, nil
+	}
+	alerts, err := decodeAlerts(v)
+	if err != nil {
+		return nil, false, err
+	}
+	out, err := encodeAlerts(alerts)
+	return out, err == nil, err
+}
+
+// pendingRecordMigrations returns the record migrations after version
+func pendingRecordMigrations(version int) []valueMigration {
+	var pending []valueMigration
+	for _, m := range recordMigrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// parseSchemaVersion parses a stored schema version and rejects one newer than latest
+func parseSchemaVersion(v []byte, latest int) (int, error) {
+	version, err := strconv.Atoi(string(v))
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version %q: %w", v, err)
+	}
+	if version > latest {
+		return 0, fmt.Errorf("schema version %d, latest %d: %w", version, latest, ErrSchemaTooNew)
+	}
+	return version, nil
+}
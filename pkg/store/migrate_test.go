@@ -0,0 +1,66 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeAlerts(t *testing.T) {
+	for name, tc := range map[string]struct {
+		value   string
+		wantErr bool
+		tooNew  bool
+	}{
+		"bare array":     {value: ` [{"status":"firing"}]`},
+		"record":         {value: `{"version":1,"alerts":[{"status":"firing"}]}`},
+		"newer record":   {value: `{"version":2,"alerts":[{"status":"firing"}]}`, wantErr: true, tooNew: true},
+		"invalid record": {value: `{"version":`, wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			alerts, err := decodeAlerts([]byte(tc.value))
+			if tc.wantErr {
+				if err == nil || errors.Is(err, ErrSchemaTooNew) != tc.tooNew {
+					t.Fatalf("wanted error (too new %v) got %v", tc.tooNew, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(alerts) != 1 || alerts[0].Status != "firing" {
+				t.Fatalf("wanted a firing alert got %v", alerts)
+			}
+		})
+	}
+}
+
+func TestWrapRecord(t *testing.T) {
+	out, ok, err := wrapRecord([]byte(`[{"status":"firing"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || isBareAlerts(out) {
+		t.Fatalf("expected bare array to be wrapped but got %s", out)
+	}
+
+	again, ok, err := wrapRecord(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok || !reflect.DeepEqual(again, out) {
+		t.Fatalf("expected record to be left unchanged but got %s", again)
+	}
+}
+
+func expectSchemaVersion(ctx context.Context, t *testing.T, m Migrator, expect int) {
+	t.Helper()
+	version, err := m.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != expect {
+		t.Fatalf("wanted schema version %d got %d", expect, version)
+	}
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/labels"
+)
+
+// Query selects the entries holding at least one alert that satisfies every condition.
+// Zero values match everything.
+type Query struct {
+	Matchers labels.Matchers
+	Status   string
+	// From and To bound the alert start time, From inclusive and To exclusive
+	From time.Time
+	To   time.Time
+}
+
+// Matches reports whether a satisfies every condition of q
+func (q Query) Matches(a api.Alert) bool {
+	if q.Status != "" && a.Status != q.Status {
+		return false
+	}
+	if !q.From.IsZero() && a.StartsAt.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !a.StartsAt.Before(q.To) {
+		return false
+	}
+	return q.Matchers.Matches(a.Labels)
+}
+
+// Querier is implemented by stores that evaluate a Query without a full scan.
+// Query calls fn with each matching entry ordered by ID.
+type Querier interface {
+	Query(ctx context.Context, q Query, fn func(api.MessageEntry) error) error
+}
+
+// Select returns the entries of s matching q, using the store's own Querier if it has one
+func Select(ctx context.Context, s Store, q Query) ([]api.MessageEntry, error) {
+	return collect(ctx, func(ctx context.Context, fn func(api.MessageEntry) error) error {
+		return SelectEach(ctx, s, q, fn)
+	})
+}
+
+// SelectEach calls fn with each entry of s matching q, ordered by ID. Stores without a Querier are iterated
+// so that only the matching entries are passed on, without holding the others in memory.
+func SelectEach(ctx context.Context, s Store, q Query, fn func(api.MessageEntry) error) error {
+	if querier, ok := s.(Querier); ok {
+		return querier.Query(ctx, q, fn)
+	}
+
+	return s.Iterate(ctx, func(e api.MessageEntry) error {
+		if q.MatchesAny(e.Alerts) {
+			return fn(e)
+		}
+		return nil
+	})
+}
+
+// MatchesAny reports whether any of alerts satisfies q
+func (q Query) MatchesAny(alerts []api.Alert) bool {
+	for _, a := range alerts {
+		if q.Matches(a) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,487 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+const (
+	// DefaultRedisPrefix namespaces every key and the change channel
+	DefaultRedisPrefix = "webhook:"
+	// redisHistoryLength bounds the notifications kept in the list for each ID
+	redisHistoryLength = 100
+	// redisRewriteAttempts bounds the retries of a rewrite of a history that replicas keep pushing to
+	redisRewriteAttempts = 10
+)
+
+// RedisStore is a Store backed by Redis so that several replicas of the receiver share their history.
+// Every notification saved under an ID is pushed to a list for that ID and the latest is returned.
+// The ID of every saved notification is published on the ChangesChannel.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+	sealer *sealer
+}
+
+// NewRedisStore creates a new RedisStore for the server at url, such as redis://localhost:6379/0
+// If ttl is greater than zero the history of an ID expires when nothing is saved under it for ttl
+func NewRedisStore(url, prefix string, ttl time.Duration) (*RedisStore, error) {
+	return NewEncryptedRedisStore(url, prefix, ttl, nil)
+}
+
+// NewEncryptedRedisStore creates a new RedisStore that seals every notification with key using AES-GCM,
+// so that the server and its snapshots only hold ciphertext. Every replica must use the same key.
+func NewEncryptedRedisStore(url, prefix string, ttl time.Duration, key []byte) (*RedisStore, error) {
+	sealer, err := newSealer(key)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open db: %w", err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to open db: %w", err)
+	}
+	r := &RedisStore{client: client, prefix: prefix, ttl: ttl, sealer: sealer}
+	if err := r.initSchema(context.Background()); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to open db: %w", err)
+	}
+	return r, nil
+}
+
+func (r *RedisStore) Get(ctx context.Context, id string) ([]api.Alert, error) {
+	if !validKey(id) {
+		return nil, ErrInvalidID
+	}
+	v, err := r.client.LIndex(ctx, r.historyKey(id), -1).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.decode(id, v)
+}
+
+func (r *RedisStore) Set(ctx context.Context, id string, alerts []api.Alert) error {
+	if !validKey(id) {
+		return ErrInvalidID
+	}
+	v, err := encodeAlerts(alerts)
+	if err != nil {
+		return err
+	}
+	if v, err = r.sealer.seal(id, v); err != nil {
+		return err
+	}
+
+	// the index score is the time the history expires so that expired IDs can be pruned, or zero if it never does
+	var expiry float64
+	if r.ttl > 0 {
+		expiry = float64(time.Now().Add(r.ttl).UnixNano() / int64(time.Millisecond))
+	}
+	key := r.historyKey(id)
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, v)
+		pipe.LTrim(ctx, key, -redisHistoryLength, -1)
+		if r.ttl > 0 {
+			pipe.PExpire(ctx, key, r.ttl)
+		}
+		pipe.ZAdd(ctx, r.indexKey(), &redis.Z{Score: expiry, Member: id})
+		pipe.Publish(ctx, r.ChangesChannel(), id)
+		return nil
+	})
+	return err
+}
+
+func (r *RedisStore) Delete(ctx context.Context, id string) error {
+	if !validKey(id) {
+		return ErrInvalidID
+	}
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.historyKey(id), r.forwardsKey(id))
+		pipe.ZRem(ctx, r.indexKey(), id)
+		return nil
+	})
+	return err
+}
+
+func (r *RedisStore) List(ctx context.Context) ([]api.MessageEntry, error) {
+	return collect(ctx, r.Iterate)
+}
+
+// Iterate reads the index of IDs and then their latest notifications in batches
+func (r *RedisStore) Iterate(ctx context.Context, fn func(api.MessageEntry) error) error {
+	now := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	if err := r.client.ZRemRangeByScore(ctx, r.indexKey(), "(0", now).Err(); err != nil {
+		return err
+	}
+	ids, err := r.client.ZRange(ctx, r.indexKey(), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	sort.Strings(ids)
+
+	for len(ids) > 0 {
+		n := iterateBatchSize
+		if n > len(ids) {
+			n = len(ids)
+		}
+		if err := r.iterateBatch(ctx, ids[:n], fn); err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+func (r *RedisStore) iterateBatch(ctx context.Context, ids []string, fn func(api.MessageEntry) error) error {
+	cmds := make([]*redis.StringCmd, len(ids))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.LIndex(ctx, r.historyKey(id), -1)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	for i, cmd := range cmds {
+		v, err := cmd.Bytes()
+		// the history expired after the index was pruned
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		alerts, err := r.decode(ids[i], v)
+		if err != nil {
+			return err
+		}
+		if err := fn(api.MessageEntry{ID: ids[i], Alerts: alerts}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscribe returns the IDs saved by any replica sharing the store until ctx is done
+func (r *RedisStore) Subscribe(ctx context.Context) (<-chan string, error) {
+	sub := r.client.Subscribe(ctx, r.ChangesChannel())
+	// wait for the subscription to be confirmed so that no change made after Subscribe returns is missed
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		defer sub.Close()
+		messages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case out <- m.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// RotateRedisStoreKey reseals every notification and forwarding outcome saved with prefix on the server at url from oldKey to newKey.
+// An empty oldKey encrypts a plaintext store. Notifications already sealed with newKey are kept, so a rotation
+// that stopped part way can be run again. Every replica must be stopped while the key is rotated.
+func RotateRedisStoreKey(ctx context.Context, url, prefix string, oldKey, newKey []byte) error {
+	if err := ValidateEncryptionKey(newKey); err != nil {
+		return err
+	}
+	from, err := newSealer(oldKey)
+	if err != nil {
+		return err
+	}
+	r, err := NewEncryptedRedisStore(url, prefix, 0, newKey)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	ids, err := r.client.ZRange(ctx, r.indexKey(), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	// reseal binds each value to the ID it was sealed under
+	reseal := func(sealedID string) func(v []byte) ([]byte, bool, error) {
+		return func(v []byte) ([]byte, bool, error) {
+			if _, err := r.sealer.open(sealedID, v); err == nil {
+				return v, false, nil
+			}
+			plain, err := from.reopen(sealedID, v)
+			if err != nil {
+				return nil, false, err
+			}
+			out, err := r.sealer.seal(sealedID, plain)
+			return out, err == nil, err
+		}
+	}
+	for _, id := range ids {
+		if _, err := r.rewriteHistory(ctx, id, reseal(id), false); err != nil {
+			return fmt.Errorf("%q: %w", id, err)
+		}
+		if _, err := r.rewriteList(ctx, r.forwardsKey(id), reseal(forwardsID(id)), false); err != nil {
+			return fmt.Errorf("forwards of %q: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the schema version of the store
+func (r *RedisStore) SchemaVersion(ctx context.Context) (int, error) {
+	v, err := r.client.Get(ctx, r.schemaKey()).Bytes()
+	if err != nil {
+		return 0, err
+	}
+	return parseSchemaVersion(v, RecordVersion)
+}
+
+// Migrate rewrites every notification written before the latest schema version. Each history is rewritten on
+// its own, so a migration that stopped part way is applied again from the start.
+func (r *RedisStore) Migrate(ctx context.Context, dryRun bool) ([]MigrationResult, error) {
+	version, err := r.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []MigrationResult
+	for _, m := range pendingRecordMigrations(version) {
+		n, err := r.migrateValues(ctx, m, dryRun)
+		if err != nil {
+			return results, fmt.Errorf("migration %d: %w", m.Version, err)
+		}
+		results = append(results, MigrationResult{Migration: m.Migration, Entries: n})
+		if dryRun {
+			continue
+		}
+		if err := r.client.Set(ctx, r.schemaKey(), strconv.Itoa(m.Version), 0).Err(); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// migrateValues rewrites the plaintext of every notification with m, reporting how many changed
+func (r *RedisStore) migrateValues(ctx context.Context, m valueMigration, dryRun bool) (int, error) {
+	ids, err := r.client.ZRange(ctx, r.indexKey(), 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	var changed int
+	for _, id := range ids {
+		n, err := r.rewriteHistory(ctx, id, func(v []byte) ([]byte, bool, error) {
+			plain, err := r.sealer.reopen(id, v)
+			if err != nil {
+				return nil, false, err
+			}
+			out, ok, err := m.apply(plain)
+			if err != nil || !ok {
+				return nil, false, err
+			}
+			if out, err = r.sealer.seal(id, out); err != nil {
+				return nil, false, err
+			}
+			return out, true, nil
+		}, dryRun)
+		if err != nil {
+			return changed, fmt.Errorf("%q: %w", id, err)
+		}
+		changed += n
+	}
+	return changed, nil
+}
+
+// initSchema records the latest schema version for a new store and rejects a store written by a newer receiver
+func (r *RedisStore) initSchema(ctx context.Context) error {
+	// a replica starting at the same time may record it first
+	if err := r.client.SetNX(ctx, r.schemaKey(), strconv.Itoa(RecordVersion), 0).Err(); err != nil {
+		return err
+	}
+	_, err := r.SchemaVersion(ctx)
+	return err
+}
+
+// rewriteHistory replaces each notification of id with the value returned by rewrite, keeping the time the
+// history expires, and reports how many changed. Nothing is written on a dry run.
+func (r *RedisStore) rewriteHistory(ctx context.Context, id string, rewrite func(v []byte) ([]byte, bool, error), dryRun bool) (int, error) {
+	return r.rewriteList(ctx, r.historyKey(id), rewrite, dryRun)
+}
+
+// rewriteList replaces each value of the list at key like rewriteHistory. The list is watched so that a value
+// pushed by a replica while it is rewritten is not lost.
+func (r *RedisStore) rewriteList(ctx context.Context, key string, rewrite func(v []byte) ([]byte, bool, error), dryRun bool) (int, error) {
+	var changed int
+	txf := func(tx *redis.Tx) error {
+		values, err := tx.LRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		out := make([]interface{}, len(values))
+		changed = 0
+		for i, v := range values {
+			rewritten, ok, err := rewrite([]byte(v))
+			if err != nil {
+				return err
+			}
+			out[i] = v
+			if ok {
+				out[i] = rewritten
+				changed++
+			}
+		}
+		if changed == 0 || dryRun {
+			return nil
+		}
+
+		ttl, err := tx.PTTL(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			pipe.RPush(ctx, key, out...)
+			if ttl > 0 {
+				pipe.PExpire(ctx, key, ttl)
+			}
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < redisRewriteAttempts; attempt++ {
+		err := r.client.Watch(ctx, txf, key)
+		if err != redis.TxFailedErr {
+			return changed, err
+		}
+	}
+	return 0, fmt.Errorf("%s changed during each of %d attempts to rewrite it", key, redisRewriteAttempts)
+}
+
+// AddForwards appends outcomes to the forwarding outcomes saved under id, which expire with its history
+func (r *RedisStore) AddForwards(ctx context.Context, id string, outcomes []api.ForwardOutcome) error {
+	if !validKey(id) {
+		return ErrInvalidID
+	}
+	values := make([]interface{}, len(outcomes))
+	for i, o := range outcomes {
+		v, err := json.Marshal(o)
+		if err != nil {
+			return err
+		}
+		if values[i], err = r.sealer.seal(forwardsID(id), v); err != nil {
+			return err
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	// the notification may have been deleted while it was forwarded
+	n, err := r.client.Exists(ctx, r.historyKey(id)).Result()
+	if err != nil || n == 0 {
+		return err
+	}
+	key := r.forwardsKey(id)
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, values...)
+		pipe.LTrim(ctx, key, -MaxForwards, -1)
+		if r.ttl > 0 {
+			pipe.PExpire(ctx, key, r.ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// Forwards returns the forwarding outcomes saved under id, oldest first
+func (r *RedisStore) Forwards(ctx context.Context, id string) ([]api.ForwardOutcome, error) {
+	if !validKey(id) {
+		return nil, ErrInvalidID
+	}
+	values, err := r.client.LRange(ctx, r.forwardsKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, ErrNotFound
+	}
+	out := make([]api.ForwardOutcome, len(values))
+	for i, v := range values {
+		plain, err := r.sealer.open(forwardsID(id), []byte(v))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(plain, &out[i]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// ChangesChannel is the pub/sub channel the ID of every saved notification is published on
+func (r *RedisStore) ChangesChannel() string {
+	return r.prefix + "changes"
+}
+
+// Close closes the connections to the server
+func (r *RedisStore) Close() error {
+	return r.client.Close()
+}
+
+func (r *RedisStore) decode(id string, v []byte) ([]api.Alert, error) {
+	plain, err := r.sealer.open(id, v)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAlerts(plain)
+}
+
+func (r *RedisStore) historyKey(id string) string {
+	return r.prefix + "history:" + id
+}
+
+// forwardsKey holds the forwarding outcomes of id, oldest first
+func (r *RedisStore) forwardsKey(id string) string {
+	return r.prefix + "forwards:" + id
+}
+
+// schemaKey holds the schema version of the store
+func (r *RedisStore) schemaKey() string {
+	return r.prefix + "schema_version"
+}
+
+// indexKey is a sorted set of every ID with a history
+func (r *RedisStore) indexKey() string {
+	return r.prefix + "ids"
+}
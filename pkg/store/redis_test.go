@@ -0,0 +1,238 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store/storetest"
+)
+
+// redisHistoryLength is the number of notifications the redis store keeps for each ID
+const redisHistoryLength = 100
+
+// otherKey is the key testKey is rotated to
+var otherKey = []byte("fedcba9876543210fedcba9876543210")
+
+// historyKey is the key of the list holding the history of id
+func historyKey(id string) string {
+	return store.DefaultRedisPrefix + "history:" + id
+}
+
+func newTestRedisStore(t *testing.T, server *miniredis.Miniredis, ttl time.Duration) *store.RedisStore {
+	s, err := store.NewRedisStore("redis://"+server.Addr(), store.DefaultRedisPrefix, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestRedisStore_SharedBetweenReplicas(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	a, b := newTestRedisStore(t, server, 0), newTestRedisStore(t, server, 0)
+
+	if err := a.Set(ctx, "any", storetest.LabelledAlerts()); err != nil {
+		t.Fatal(err)
+	}
+	result, err := b.Get(ctx, "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, storetest.LabelledAlerts()) {
+		t.Fatalf("wanted %v got %v", storetest.LabelledAlerts(), result)
+	}
+}
+
+func TestRedisStore_History(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	s := newTestRedisStore(t, server, 0)
+
+	for i := 0; i < redisHistoryLength+10; i++ {
+		if err := s.Set(ctx, "any", storetest.Alerts()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Set(ctx, "any", storetest.LabelledAlerts()); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := server.List(historyKey("any"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != redisHistoryLength {
+		t.Fatalf("wanted %d got %d", redisHistoryLength, len(history))
+	}
+	result, err := s.Get(ctx, "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, storetest.LabelledAlerts()) {
+		t.Fatalf("wanted %v got %v", storetest.LabelledAlerts(), result)
+	}
+}
+
+func TestRedisStore_TTL(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	s := newTestRedisStore(t, server, time.Minute)
+
+	for _, id := range []string{"expired", "kept"} {
+		if err := s.Set(ctx, id, storetest.Alerts()); err != nil {
+			t.Fatal(err)
+		}
+		server.FastForward(40 * time.Second)
+	}
+
+	if _, err := s.Get(ctx, "expired"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("wanted %v got %v", store.ErrNotFound, err)
+	}
+	entries, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ID != "kept" {
+		t.Fatalf("expected only the unexpired entry but got %v", entries)
+	}
+}
+
+func TestRedisStore_Subscribe(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	a, b := newTestRedisStore(t, server, 0), newTestRedisStore(t, server, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	changes, err := b.Subscribe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"first", "second"} {
+		if err := a.Set(ctx, id, storetest.Alerts()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, expect := range []string{"first", "second"} {
+		select {
+		case id := <-changes:
+			if id != expect {
+				t.Fatalf("wanted %v got %v", expect, id)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %v", expect)
+		}
+	}
+
+	cancel()
+	for range changes {
+	}
+}
+
+func TestRedisStore_Encryption(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	s, err := store.NewEncryptedRedisStore("redis://"+server.Addr(), store.DefaultRedisPrefix, 0, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Set(ctx, "any", storetest.LabelledAlerts()); err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := server.List(historyKey("any"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || strings.Contains(values[0], "something broke") {
+		t.Fatalf("expected a single sealed notification but got %q", values)
+	}
+
+	plain := newTestRedisStore(t, server, 0)
+	if _, err := plain.Get(ctx, "any"); !errors.Is(err, store.ErrEncrypted) {
+		t.Fatalf("wanted %v got %v", store.ErrEncrypted, err)
+	}
+}
+
+func TestRedisStore_RotateKey(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	plain := newTestRedisStore(t, server, time.Hour)
+	if err := plain.Set(ctx, "plaintext", storetest.LabelledAlerts()); err != nil {
+		t.Fatal(err)
+	}
+
+	url := "redis://" + server.Addr()
+	encrypted, err := store.NewEncryptedRedisStore(url, store.DefaultRedisPrefix, 0, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encrypted.Close()
+	if _, err := encrypted.Get(ctx, "plaintext"); !errors.Is(err, store.ErrNotSealed) {
+		t.Fatalf("wanted %v got %v", store.ErrNotSealed, err)
+	}
+
+	// encrypt the notifications written before a key was set, then rotate to another key
+	if err := store.RotateRedisStoreKey(ctx, url, store.DefaultRedisPrefix, nil, testKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RotateRedisStoreKey(ctx, url, store.DefaultRedisPrefix, testKey, otherKey); err != nil {
+		t.Fatal(err)
+	}
+	values, err := server.List(historyKey("plaintext"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || strings.Contains(values[0], "something broke") {
+		t.Fatalf("expected a single sealed notification but got %q", values)
+	}
+	if server.TTL(historyKey("plaintext")) <= 0 {
+		t.Fatalf("expected the rotation to keep the history expiry")
+	}
+
+	rotated, err := store.NewEncryptedRedisStore(url, store.DefaultRedisPrefix, 0, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rotated.Close()
+	result, err := rotated.Get(ctx, "plaintext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, storetest.LabelledAlerts()) {
+		t.Fatalf("wanted %v got %v", storetest.LabelledAlerts(), result)
+	}
+}
+
+func TestRedisStore_Migrate(t *testing.T) {
+	ctx := context.Background()
+	s := newTestRedisStore(t, miniredis.RunT(t), 0)
+	if version, err := s.SchemaVersion(ctx); err != nil || version != store.RecordVersion {
+		t.Fatalf("wanted schema version %d got %d, %v", store.RecordVersion, version, err)
+	}
+	if results, err := s.Migrate(ctx, false); err != nil || len(results) != 0 {
+		t.Fatalf("expected no pending migrations but got %v, %v", results, err)
+	}
+}
+
+func TestRedisStore_SchemaTooNew(t *testing.T) {
+	server := miniredis.RunT(t)
+	if err := server.Set(store.DefaultRedisPrefix+"schema_version", strconv.Itoa(store.RecordVersion+1)); err != nil {
+		t.Fatal(err)
+	}
+	_, err := store.NewRedisStore("redis://"+server.Addr(), store.DefaultRedisPrefix, 0)
+	if !errors.Is(err, store.ErrSchemaTooNew) {
+		t.Fatalf("wanted %v got %v", store.ErrSchemaTooNew, err)
+	}
+}
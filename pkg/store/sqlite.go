@@ -0,0 +1,559 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	// registers the cgo-free "sqlite" driver
+	_ "modernc.org/sqlite"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/labels"
+)
+
+// sqlSchema normalizes each entry into its alerts and their labels and annotations so that
+// entries can be queried by label, status and start time without decoding every entry
+const sqlSchema = `
+CREATE TABLE IF NOT EXISTS notifications (
+	id         TEXT PRIMARY KEY,
+	updated_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS alerts (
+	notification_id TEXT NOT NULL REFERENCES notifications(id) ON DELETE CASCADE,
+	position        INTEGER NOT NULL,
+	status          TEXT NOT NULL,
+	starts_at       TEXT NOT NULL,
+	starts_at_unix  INTEGER NOT NULL,
+	ends_at         TEXT NOT NULL,
+	generator_url   TEXT NOT NULL,
+	fingerprint     TEXT NOT NULL,
+	PRIMARY KEY (notification_id, position)
+);
+CREATE INDEX IF NOT EXISTS alerts_status ON alerts(status);
+CREATE INDEX IF NOT EXISTS alerts_starts_at ON alerts(starts_at_unix);
+CREATE TABLE IF NOT EXISTS labels (
+	notification_id TEXT NOT NULL,
+	position        INTEGER NOT NULL,
+	name            TEXT NOT NULL,
+	value           TEXT NOT NULL,
+	PRIMARY KEY (notification_id, position, name),
+	FOREIGN KEY (notification_id, position) REFERENCES alerts(notification_id, position) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS labels_name_value ON labels(name, value);
+CREATE TABLE IF NOT EXISTS annotations (
+	notification_id TEXT NOT NULL,
+	position        INTEGER NOT NULL,
+	name            TEXT NOT NULL,
+	value           TEXT NOT NULL,
+	PRIMARY KEY (notification_id, position, name),
+	FOREIGN KEY (notification_id, position) REFERENCES alerts(notification_id, position) ON DELETE CASCADE
+);
+-- not a foreign key, as saving a notification replaces the row of its ID
+CREATE TABLE IF NOT EXISTS forwards (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	notification_id TEXT NOT NULL,
+	outcome         TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS forwards_notification ON forwards(notification_id, id);
+`
+
+// sqlMigration upgrades the schema of the database to its version with a script
+type sqlMigration struct {
+	Migration
+	script string
+}
+
+// sqlMigrations are ordered by version, which is kept in the user_version of the database
+var sqlMigrations = []sqlMigration{
+	{
+		Migration: Migration{Version: 1, Description: "create the notifications, alerts, labels, annotations and forwards tables"},
+		script:    sqlSchema,
+	},
+}
+
+// SQLStore is a Store backed by an embedded SQLite database
+type SQLStore struct {
+	db *sql.DB
+}
+
+// NewSQLStore creates a new SQLStore at the provided path
+// If path is empty an in-memory database is used
+func NewSQLStore(path string) (*SQLStore, error) {
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+	if path == "" {
+		dsn = "file::memory:?_pragma=foreign_keys(1)"
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open db: %w", err)
+	}
+	// SQLite allows a single writer and each connection to an in-memory database is a separate database
+	db.SetMaxOpenConns(1)
+
+	s := &SQLStore{db: db}
+	if err := s.initSchema(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+	return s, nil
+}
+
+// initSchema migrates a new database to the latest schema version and rejects a database written by
+// a newer receiver. Databases created before the version was recorded are left for Migrate.
+func (s *SQLStore) initSchema() error {
+	ctx := context.Background()
+	version, err := s.SchemaVersion(ctx)
+	if err != nil || version > 0 {
+		return err
+	}
+	var tables int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'`).Scan(&tables); err != nil {
+		return err
+	}
+	if tables > 0 {
+		return nil
+	}
+	_, err = s.Migrate(ctx, false)
+	return err
+}
+
+// SchemaVersion returns the schema version of the database, which is zero for databases created before it was recorded
+func (s *SQLStore) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	if err := s.db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return 0, contextErr(ctx, err)
+	}
+	latest := sqlMigrations[len(sqlMigrations)-1].Version
+	if version > latest {
+		return 0, fmt.Errorf("schema version %d, latest %d: %w", version, latest, ErrSchemaTooNew)
+	}
+	return version, nil
+}
+
+// Migrate applies each pending migration in its own transaction
+func (s *SQLStore) Migrate(ctx context.Context, dryRun bool) ([]MigrationResult, error) {
+	version, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []MigrationResult
+	for _, m := range sqlMigrations {
+		if m.Version <= version {
+			continue
+		}
+		if !dryRun {
+			if err := s.migrate(ctx, m); err != nil {
+				return results, fmt.Errorf("migration %d: %w", m.Version, err)
+			}
+		}
+		results = append(results, MigrationResult{Migration: m.Migration})
+	}
+	return results, nil
+}
+
+func (s *SQLStore) migrate(ctx context.Context, m sqlMigration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return contextErr(ctx, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.script); err != nil {
+		return contextErr(ctx, err)
+	}
+	// PRAGMA does not accept bound parameters
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, m.Version)); err != nil {
+		return contextErr(ctx, err)
+	}
+	return contextErr(ctx, tx.Commit())
+}
+
+func (s *SQLStore) Get(ctx context.Context, id string) ([]api.Alert, error) {
+	if !validKey(id) {
+		return nil, ErrInvalidID
+	}
+	entries, err := s.query(ctx, `WHERE n.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrNotFound
+	}
+	return entries[0].Alerts, nil
+}
+
+func (s *SQLStore) Set(ctx context.Context, id string, alerts []api.Alert) error {
+	if !validKey(id) {
+		return ErrInvalidID
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return contextErr(ctx, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM notifications WHERE id = ?`, id); err != nil {
+		return contextErr(ctx, err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO notifications (id, updated_at) VALUES (?, ?)`, id, time.Now().UnixNano()); err != nil {
+		return contextErr(ctx, err)
+	}
+	for i, a := range alerts {
+		_, err := tx.ExecContext(ctx, `INSERT INTO alerts (notification_id, position, status, starts_at, starts_at_unix, ends_at, generator_url, fingerprint)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, i, a.Status, formatTime(a.StartsAt), unixNano(a.StartsAt), formatTime(a.EndsAt), a.GeneratorURL, a.Fingerprint)
+		if err != nil {
+			return contextErr(ctx, err)
+		}
+		if err := insertPairs(ctx, tx, "labels", id, i, a.Labels); err != nil {
+			return contextErr(ctx, err)
+		}
+		if err := insertPairs(ctx, tx, "annotations", id, i, a.Annotations); err != nil {
+			return contextErr(ctx, err)
+		}
+	}
+	return contextErr(ctx, tx.Commit())
+}
+
+func (s *SQLStore) List(ctx context.Context) ([]api.MessageEntry, error) {
+	return s.query(ctx, "")
+}
+
+// Iterate reads the entries in batches ordered by ID so that the connection is not held while fn runs.
+// Entries saved or deleted between batches may or may not be seen.
+func (s *SQLStore) Iterate(ctx context.Context, fn func(api.MessageEntry) error) error {
+	batch, err := s.query(ctx, `WHERE n.id IN (SELECT id FROM notifications ORDER BY id LIMIT ?)`, iterateBatchSize)
+	for {
+		if err != nil {
+			return err
+		}
+		for _, e := range batch {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if len(batch) < iterateBatchSize {
+			return nil
+		}
+		after := batch[len(batch)-1].ID
+		batch, err = s.query(ctx, `WHERE n.id IN (SELECT id FROM notifications WHERE id > ? ORDER BY id LIMIT ?)`, after, iterateBatchSize)
+	}
+}
+
+func (s *SQLStore) Delete(ctx context.Context, id string) error {
+	if !validKey(id) {
+		return ErrInvalidID
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return contextErr(ctx, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM notifications WHERE id = ?`, id); err != nil {
+		return contextErr(ctx, err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM forwards WHERE notification_id = ?`, id); err != nil {
+		return contextErr(ctx, err)
+	}
+	return contextErr(ctx, tx.Commit())
+}
+
+// AddForwards appends outcomes to the forwarding outcomes saved under id
+func (s *SQLStore) AddForwards(ctx context.Context, id string, outcomes []api.ForwardOutcome) error {
+	if !validKey(id) {
+		return ErrInvalidID
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return contextErr(ctx, err)
+	}
+	defer tx.Rollback()
+
+	for _, o := range outcomes {
+		b, err := json.Marshal(o)
+		if err != nil {
+			return err
+		}
+		// the notification may have been deleted while it was forwarded
+		_, err = tx.ExecContext(ctx, `INSERT INTO forwards (notification_id, outcome)
+			SELECT ?, ? WHERE EXISTS (SELECT 1 FROM notifications WHERE id = ?)`, id, string(b), id)
+		if err != nil {
+			return contextErr(ctx, err)
+		}
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM forwards WHERE notification_id = ? AND id NOT IN
+		(SELECT id FROM forwards WHERE notification_id = ? ORDER BY id DESC LIMIT ?)`, id, id, MaxForwards)
+	if err != nil {
+		return contextErr(ctx, err)
+	}
+	return contextErr(ctx, tx.Commit())
+}
+
+// Forwards returns the forwarding outcomes saved under id, oldest first
+func (s *SQLStore) Forwards(ctx context.Context, id string) ([]api.ForwardOutcome, error) {
+	if !validKey(id) {
+		return nil, ErrInvalidID
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT outcome FROM forwards WHERE notification_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, contextErr(ctx, err)
+	}
+	defer rows.Close()
+
+	var out []api.ForwardOutcome
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, contextErr(ctx, err)
+		}
+		var o api.ForwardOutcome
+		if err := json.Unmarshal([]byte(v), &o); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, contextErr(ctx, err)
+	}
+	if len(out) == 0 {
+		return nil, ErrNotFound
+	}
+	return out, nil
+}
+
+// Query evaluates q in the database. Equality matchers, status and start time are used to select
+// candidate alerts and any remaining matchers are applied to the candidates.
+func (s *SQLStore) Query(ctx context.Context, q Query, fn func(api.MessageEntry) error) error {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if q.Status != "" {
+		conds = append(conds, "a.status = ?")
+		args = append(args, q.Status)
+	}
+	if !q.From.IsZero() {
+		conds = append(conds, "a.starts_at_unix >= ?")
+		args = append(args, unixNano(q.From))
+	}
+	if !q.To.IsZero() {
+		conds = append(conds, "a.starts_at_unix < ?")
+		args = append(args, unixNano(q.To))
+	}
+	for _, m := range q.Matchers {
+		if m.Type != labels.MatchEqual || m.Value == "" {
+			continue
+		}
+		conds = append(conds, `EXISTS (SELECT 1 FROM labels l WHERE l.notification_id = a.notification_id AND l.position = a.position AND l.name = ? AND l.value = ?)`)
+		args = append(args, m.Name, m.Value)
+	}
+
+	// candidates are read in batches ordered by ID, as in Iterate
+	conds = append(conds, "a.notification_id > ?")
+	candidates := `WHERE n.id IN (SELECT DISTINCT a.notification_id FROM alerts a WHERE ` + strings.Join(conds, " AND ") +
+		` ORDER BY a.notification_id LIMIT ?)`
+	after := ""
+	for {
+		batch, err := s.query(ctx, candidates, append(args, after, iterateBatchSize)...)
+		if err != nil {
+			return err
+		}
+		for _, e := range batch {
+			if !q.MatchesAny(e.Alerts) {
+				continue
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if len(batch) < iterateBatchSize {
+			return nil
+		}
+		after = batch[len(batch)-1].ID
+	}
+}
+
+// Close closes the underlying database
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// query loads the entries selected by the where clause on the notifications table n, ordered by ID
+func (s *SQLStore) query(ctx context.Context, where string, args ...interface{}) ([]api.MessageEntry, error) {
+	entries, err := s.queryEntries(ctx, where, args...)
+	return entries, contextErr(ctx, err)
+}
+
+func (s *SQLStore) queryEntries(ctx context.Context, where string, args ...interface{}) ([]api.MessageEntry, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT n.id, a.position, a.status, a.starts_at, a.ends_at, a.generator_url, a.fingerprint
+		FROM notifications n LEFT JOIN alerts a ON a.notification_id = n.id `+where+`
+		ORDER BY n.id, a.position`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type alertKey struct {
+		id       string
+		position int
+	}
+	var entries []api.MessageEntry
+	index := map[alertKey]*api.Alert{}
+	for rows.Next() {
+		var (
+			id                                             string
+			position                                       sql.NullInt64
+			status, startsAt, endsAt, generatorURL, fprint sql.NullString
+		)
+		if err := rows.Scan(&id, &position, &status, &startsAt, &endsAt, &generatorURL, &fprint); err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 || entries[len(entries)-1].ID != id {
+			entries = append(entries, api.MessageEntry{ID: id, Alerts: []api.Alert{}})
+		}
+		if !position.Valid {
+			continue
+		}
+
+		a := api.Alert{Status: status.String, GeneratorURL: generatorURL.String, Fingerprint: fprint.String}
+		if a.StartsAt, err = parseTime(startsAt.String); err != nil {
+			return nil, err
+		}
+		if a.EndsAt, err = parseTime(endsAt.String); err != nil {
+			return nil, err
+		}
+		e := &entries[len(entries)-1]
+		e.Alerts = append(e.Alerts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]interface{}, len(entries))
+	for i := range entries {
+		ids[i] = entries[i].ID
+		for j := range entries[i].Alerts {
+			index[alertKey{entries[i].ID, j}] = &entries[i].Alerts[j]
+		}
+	}
+	for _, table := range []string{"labels", "annotations"} {
+		if err := s.loadPairs(ctx, table, ids, func(id string, position int, name, value string) {
+			a, ok := index[alertKey{id, position}]
+			if !ok {
+				return
+			}
+			m := &a.Labels
+			if table == "annotations" {
+				m = &a.Annotations
+			}
+			if *m == nil {
+				*m = map[string]string{}
+			}
+			(*m)[name] = value
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// maxSQLVariables stays below SQLite's limit on the number of parameters in a statement
+const maxSQLVariables = 500
+
+func (s *SQLStore) loadPairs(ctx context.Context, table string, ids []interface{}, fn func(id string, position int, name, value string)) error {
+	for len(ids) > 0 {
+		batch := ids
+		if len(batch) > maxSQLVariables {
+			batch = batch[:maxSQLVariables]
+		}
+		ids = ids[len(batch):]
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+		rows, err := s.db.QueryContext(ctx, `SELECT notification_id, position, name, value FROM `+table+` WHERE notification_id IN (`+placeholders+`)`, batch...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var (
+				id, name, value string
+				position        int
+			)
+			if err := rows.Scan(&id, &position, &name, &value); err != nil {
+				rows.Close()
+				return err
+			}
+			fn(id, position, name, value)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertPairs(ctx context.Context, tx *sql.Tx, table, id string, position int, pairs map[string]string) error {
+	names := make([]string, 0, len(pairs))
+	for name := range pairs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO `+table+` (notification_id, position, name, value) VALUES (?, ?, ?, ?)`, id, position, name, pairs[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// contextErr returns the error of ctx in place of err once ctx is done, as the driver reports an
+// interrupted statement with its own error
+func contextErr(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// minUnixNano and maxUnixNano bound the times whose UnixNano is defined
+var (
+	minUnixNano = time.Unix(0, math.MinInt64)
+	maxUnixNano = time.Unix(0, math.MaxInt64)
+)
+
+// unixNano returns t in nanoseconds since the epoch. Times outside the range of an int64, such as the zero
+// StartsAt of an alert without one, are clamped so that they sort before or after every other time.
+func unixNano(t time.Time) int64 {
+	switch {
+	case t.Before(minUnixNano):
+		return math.MinInt64
+	case t.After(maxUnixNano):
+		return math.MaxInt64
+	}
+	return t.UnixNano()
+}
+
+// formatTime keeps the zone offset so that alerts read back equal those written, as with JSON encoding
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: %w", s, err)
+	}
+	return t, nil
+}
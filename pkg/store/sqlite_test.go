@@ -0,0 +1,223 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/labels"
+)
+
+func TestSQLStore_Reopen(t *testing.T) {
+	ctx := context.Background()
+	alerts := []api.Alert{{Status: "firing", Labels: map[string]string{"alertname": "Test"}, Annotations: map[string]string{"summary": "something broke"}}}
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := NewSQLStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, "any", alerts); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewSQLStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	result, err := reopened.Get(ctx, "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, alerts) {
+		t.Fatalf("wanted %v got %v", alerts, result)
+	}
+}
+
+func TestSQLStore_Query(t *testing.T) {
+	ctx := context.Background()
+	sqlStore, err := NewSQLStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlStore.Close()
+	inMem := NewInMemStore()
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := map[string][]api.Alert{
+		"critical": {{Status: "firing", StartsAt: start, Labels: map[string]string{"severity": "critical", "team": "a"}}},
+		"warning":  {{Status: "resolved", StartsAt: start.Add(time.Hour), Labels: map[string]string{"severity": "warning", "team": "a"}}},
+		"mixed": {
+			{Status: "firing", StartsAt: start.Add(2 * time.Hour), Labels: map[string]string{"severity": "warning", "team": "b"}},
+			{Status: "resolved", StartsAt: start, Labels: map[string]string{"severity": "critical", "team": "b"}},
+		},
+	}
+	for id, alerts := range entries {
+		for _, s := range []Store{sqlStore, inMem} {
+			if err := s.Set(ctx, id, alerts); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	matchers := func(s string) labels.Matchers {
+		ms, err := labels.ParseMatchers(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ms
+	}
+	for _, tc := range []struct {
+		name   string
+		query  Query
+		expect []string
+	}{
+		{name: "everything", query: Query{}, expect: []string{"critical", "mixed", "warning"}},
+		{name: "label", query: Query{Matchers: matchers(`severity="critical"`)}, expect: []string{"critical", "mixed"}},
+		{name: "labels on the same alert", query: Query{Matchers: matchers(`severity="critical",team="b"`)}, expect: []string{"mixed"}},
+		{name: "regex", query: Query{Matchers: matchers(`team=~"a|c"`)}, expect: []string{"critical", "warning"}},
+		{name: "status", query: Query{Status: "resolved"}, expect: []string{"mixed", "warning"}},
+		{name: "status and label", query: Query{Status: "firing", Matchers: matchers(`severity="warning"`)}, expect: []string{"mixed"}},
+		{name: "time range", query: Query{From: start.Add(time.Hour), To: start.Add(2 * time.Hour)}, expect: []string{"warning"}},
+		{name: "no match", query: Query{Matchers: matchers(`team="c"`)}, expect: nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, s := range []Store{sqlStore, inMem} {
+				result, err := Select(ctx, s, tc.query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var ids []string
+				for _, e := range result {
+					ids = append(ids, e.ID)
+				}
+				if !reflect.DeepEqual(ids, tc.expect) {
+					t.Fatalf("%T: wanted %v got %v", s, tc.expect, ids)
+				}
+			}
+		})
+	}
+}
+
+func TestSQLStore_QueryBatches(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewSQLStore(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	total := iterateBatchSize*2 + 1
+	for i := 0; i < total; i++ {
+		alert := api.Alert{Status: "firing", Labels: map[string]string{"i": fmt.Sprint(i % 2)}}
+		if err := s.Set(ctx, fmt.Sprintf("%04d", i), []api.Alert{alert}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []string
+	err = s.Query(ctx, Query{Status: "firing"}, func(e api.MessageEntry) error {
+		ids = append(ids, e.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != total || ids[0] != "0000" || ids[total-1] != fmt.Sprintf("%04d", total-1) {
+		t.Fatalf("expected %d entries in order but got %d", total, len(ids))
+	}
+}
+
+func TestSQLStore_ZeroStartsAt(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewSQLStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Set(ctx, "any", []api.Alert{{Status: "firing"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var startsAt int64
+	if err := s.db.QueryRow(`SELECT starts_at_unix FROM alerts WHERE notification_id = 'any'`).Scan(&startsAt); err != nil {
+		t.Fatal(err)
+	}
+	if startsAt != math.MinInt64 {
+		t.Fatalf("wanted %v got %v", int64(math.MinInt64), startsAt)
+	}
+
+	for _, q := range []Query{{From: time.Unix(0, 0)}, {To: time.Unix(0, 0)}} {
+		result, err := Select(ctx, s, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matched := len(result) == 1; matched != q.From.IsZero() {
+			t.Fatalf("%+v: expected an alert without a start time to sort before every other time but got %v", q, result)
+		}
+	}
+}
+
+func TestSQLStore_Migrate(t *testing.T) {
+	ctx := context.Background()
+	alerts := []api.Alert{{Status: "firing", Labels: map[string]string{"alertname": "Test"}, Annotations: map[string]string{"summary": "something broke"}}}
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := NewSQLStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSchemaVersion(ctx, t, store, sqlMigrations[len(sqlMigrations)-1].Version)
+	if err := store.Set(ctx, "any", alerts); err != nil {
+		t.Fatal(err)
+	}
+	// a database created before the schema version was recorded
+	if _, err := store.db.Exec(`PRAGMA user_version = 0`); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewSQLStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSchemaVersion(ctx, t, reopened, 0)
+	for _, dryRun := range []bool{true, false} {
+		results, err := reopened.Migrate(ctx, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != len(sqlMigrations) {
+			t.Fatalf("wanted %d migrations got %v", len(sqlMigrations), results)
+		}
+	}
+	expectSchemaVersion(ctx, t, reopened, sqlMigrations[len(sqlMigrations)-1].Version)
+	result, err := reopened.Get(ctx, "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, alerts) {
+		t.Fatalf("wanted %v got %v", alerts, result)
+	}
+
+	if _, err := reopened.db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, len(sqlMigrations)+1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewSQLStore(path)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("wanted %v got %v", ErrSchemaTooNew, err)
+	}
+}
@@ -1,16 +1,47 @@
 package store
 
-import "github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+import (
+	"context"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
 
 const (
 	ErrNotFound = Error("not found")
 	ErrInternal = Error("internal")
 )
 
+// Store keeps the history of notifications by ID. Every method except Close stops early and returns
+// the error of ctx once it is done.
 type Store interface {
-	Get(id string) ([]api.Alert, error)
-	Set(id string, alerts []api.Alert) error
-	List() ([]api.MessageEntry, error)
+	Get(ctx context.Context, id string) ([]api.Alert, error)
+	Set(ctx context.Context, id string, alerts []api.Alert) error
+	// List returns the latest alerts saved under each ID, ordered by ID
+	List(ctx context.Context) ([]api.MessageEntry, error)
+	// Iterate calls fn with the latest alerts saved under each ID, ordered by ID, without holding every entry
+	// in memory. It stops at the first error returned by fn and returns it. fn may delete the entry it is called with.
+	Iterate(ctx context.Context, fn func(api.MessageEntry) error) error
+	// Delete removes every notification saved under id. Deleting an unknown ID is not an error.
+	Delete(ctx context.Context, id string) error
+	// Close flushes pending writes and releases the store. It must be called once no further requests are served.
+	Close() error
+}
+
+// iterateBatchSize is the number of entries read at a time by the stores that iterate in batches,
+// so that their database is not held while fn runs
+const iterateBatchSize = 256
+
+// collect returns every entry passed to fn by iterate
+func collect(ctx context.Context, iterate func(context.Context, func(api.MessageEntry) error) error) ([]api.MessageEntry, error) {
+	var entries []api.MessageEntry
+	err := iterate(ctx, func(e api.MessageEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
 }
 
 type Error string
@@ -0,0 +1,686 @@
+// Package storetest is a conformance suite for store.Store implementations.
+//
+// A backend proves compatibility by running the suite from its own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) store.Store {
+//			return mystore.New(t.TempDir())
+//		})
+//	}
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/labels"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+)
+
+// Factory opens a new, empty store. The suite closes every store it opens.
+type Factory func(t *testing.T) store.Store
+
+// Run runs every conformance test against stores opened by open
+func Run(t *testing.T, open Factory) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		fn   func(ctx context.Context, t *testing.T, s store.Store)
+	}{
+		{"Set", testSet},
+		{"Get", testGet},
+		{"GetNotFound", testGetNotFound},
+		{"SetReplacesLatest", testSetReplacesLatest},
+		{"SetEmpty", testSetEmpty},
+		{"List", testList},
+		{"ListEmpty", testListEmpty},
+		{"Delete", testDelete},
+		{"ListOrderedByID", testListOrderedByID},
+		{"Iterate", testIterate},
+		{"IterateStops", testIterateStops},
+		{"UnicodeIDs", testUnicodeIDs},
+		{"LargePayload", testLargePayload},
+		{"ConcurrentWriters", testConcurrentWriters},
+		{"CanceledContext", testCanceledContext},
+		{"Forwards", testForwards},
+		{"Query", testQuery},
+		{"InvalidID", testInvalidID},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := open(t)
+			defer s.Close()
+			tc.fn(ctx, t, s)
+		})
+	}
+
+	t.Run("Backup", func(t *testing.T) {
+		testBackup(ctx, t, open)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		s := open(t)
+		if err := s.Set(ctx, "any", Alerts()); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// Alerts returns alerts without labels or annotations
+func Alerts() []api.Alert {
+	return []api.Alert{
+		{
+			Status:       "firing",
+			GeneratorURL: "https://test.com",
+		},
+		{
+			Status:       "pending",
+			GeneratorURL: "https://example.com",
+		},
+	}
+}
+
+// LabelledAlerts returns alerts using every field
+func LabelledAlerts() []api.Alert {
+	return []api.Alert{
+		{
+			Status:       "firing",
+			Labels:       map[string]string{"alertname": "Test", "severity": "critical"},
+			Annotations:  map[string]string{"summary": "something broke"},
+			StartsAt:     time.Date(2018, 8, 3, 9, 52, 26, 739266876, time.FixedZone("", 2*60*60)),
+			GeneratorURL: "https://test.com",
+			Fingerprint:  "2ad87485aa3a8adb",
+		},
+		{
+			Status:   "resolved",
+			Labels:   map[string]string{"alertname": "Other"},
+			StartsAt: time.Date(2018, 8, 3, 9, 52, 26, 0, time.UTC),
+			EndsAt:   time.Date(2018, 8, 3, 10, 52, 26, 0, time.UTC),
+		},
+	}
+}
+
+func testSet(ctx context.Context, t *testing.T, s store.Store) {
+	if err := s.Set(ctx, "any", Alerts()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testGet(ctx context.Context, t *testing.T, s store.Store) {
+	if err := s.Set(ctx, "any", LabelledAlerts()); err != nil {
+		t.Fatal(err)
+	}
+	expectGet(ctx, t, s, "any", LabelledAlerts())
+}
+
+func testGetNotFound(ctx context.Context, t *testing.T, s store.Store) {
+	if err := s.Set(ctx, "other", Alerts()); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"missing", "othe", "other ", ""} {
+		if _, err := s.Get(ctx, id); !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("%q: wanted %v got %v", id, store.ErrNotFound, err)
+		}
+	}
+}
+
+// testSetReplacesLatest checks that Get and List serve the most recent Set for an ID,
+// whether or not a backend keeps earlier notifications
+func testSetReplacesLatest(ctx context.Context, t *testing.T, s store.Store) {
+	for _, alerts := range [][]api.Alert{LabelledAlerts(), Alerts(), LabelledAlerts()[:1]} {
+		if err := s.Set(ctx, "any", alerts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectGet(ctx, t, s, "any", LabelledAlerts()[:1])
+	expectList(ctx, t, s, []api.MessageEntry{{ID: "any", Alerts: LabelledAlerts()[:1]}})
+}
+
+func testSetEmpty(ctx context.Context, t *testing.T, s store.Store) {
+	if err := s.Set(ctx, "empty", []api.Alert{}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := s.Get(ctx, "empty")
+	if err != nil {
+		t.Fatalf("expected an entry without alerts to be found but got %v", err)
+	}
+	if len(result) != 0 {
+		t.Fatalf("expected no alerts but got %v", result)
+	}
+}
+
+func testList(ctx context.Context, t *testing.T, s store.Store) {
+	for _, id := range []string{"b", "a"} {
+		if err := s.Set(ctx, id, Alerts()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Set(ctx, "a", LabelledAlerts()); err != nil {
+		t.Fatal(err)
+	}
+	expectList(ctx, t, s, []api.MessageEntry{
+		{ID: "a", Alerts: LabelledAlerts()},
+		{ID: "b", Alerts: Alerts()},
+	})
+}
+
+func testListEmpty(ctx context.Context, t *testing.T, s store.Store) {
+	result, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 0 {
+		t.Fatalf("expected no entries but got %v", result)
+	}
+}
+
+func testDelete(ctx context.Context, t *testing.T, s store.Store) {
+	for _, id := range []string{"a", "b"} {
+		if err := s.Set(ctx, id, Alerts()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Set(ctx, "a", LabelledAlerts()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "a"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("wanted %v got %v", store.ErrNotFound, err)
+	}
+	expectList(ctx, t, s, []api.MessageEntry{{ID: "b", Alerts: Alerts()}})
+
+	for _, id := range []string{"a", "missing", ""} {
+		if err := s.Delete(ctx, id); err != nil {
+			t.Fatalf("%q: expected deleting an unknown ID to succeed but got %v", id, err)
+		}
+	}
+
+	// a deleted ID starts a new history
+	if err := s.Set(ctx, "a", Alerts()); err != nil {
+		t.Fatal(err)
+	}
+	expectGet(ctx, t, s, "a", Alerts())
+}
+
+// testListOrderedByID checks that List returns entries in byte-wise ID order regardless of write order
+func testListOrderedByID(ctx context.Context, t *testing.T, s store.Store) {
+	ids := []string{"b", "a", "B", "a_2", "a-2", "aa", "10", "9", "z", "ä"}
+	for _, id := range ids {
+		if err := s.Set(ctx, id, Alerts()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(ids)
+
+	result, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range result {
+		got = append(got, e.ID)
+	}
+	if !reflect.DeepEqual(got, ids) {
+		t.Fatalf("wanted %v got %v", ids, got)
+	}
+}
+
+// testForwards checks the forwarding outcomes of stores that persist them
+func testForwards(ctx context.Context, t *testing.T, s store.Store) {
+	if _, ok := s.(store.ForwardLog); !ok {
+		t.Skip("store does not persist forwarding outcomes")
+	}
+	if err := s.Set(ctx, "a", Alerts()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Forwards(ctx, s, "a"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("wanted %v got %v", store.ErrNotFound, err)
+	}
+
+	var outcomes []api.ForwardOutcome
+	for i := 0; i < store.MaxForwards+2; i++ {
+		outcomes = append(outcomes, api.ForwardOutcome{
+			Time:     time.Unix(int64(i), 0).UTC(),
+			Target:   "downstream",
+			URL:      "http://downstream.example/hook",
+			Attempts: 1,
+			Status:   500,
+			Error:    fmt.Sprintf("attempt %d", i),
+			Duration: "1ms",
+		})
+	}
+	for _, batch := range [][]api.ForwardOutcome{outcomes[:2], outcomes[2:]} {
+		if err := store.AddForwards(ctx, s, "a", batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the notification may be deleted before it is forwarded
+	if err := store.AddForwards(ctx, s, "missing", outcomes[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Forwards(ctx, s, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("wanted %v got %v", store.ErrNotFound, err)
+	}
+
+	// the oldest are dropped and saving a notification keeps the outcomes of its ID
+	if err := s.Set(ctx, "a", LabelledAlerts()); err != nil {
+		t.Fatal(err)
+	}
+	result, err := store.Forwards(ctx, s, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := outcomes[2:]; !reflect.DeepEqual(result, expect) {
+		t.Fatalf("wanted %v got %v", expect, result)
+	}
+	expectList(ctx, t, s, []api.MessageEntry{{ID: "a", Alerts: LabelledAlerts()}})
+
+	if err := s.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(ctx, "a", Alerts()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Forwards(ctx, s, "a"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("wanted %v got %v", store.ErrNotFound, err)
+	}
+
+	// the outcomes of each ID are kept apart, and a single batch is bounded too
+	if err := s.Set(ctx, "b", Alerts()); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddForwards(ctx, s, "b", outcomes); err != nil {
+		t.Fatal(err)
+	}
+	result, err = store.Forwards(ctx, s, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := outcomes[2:]; !reflect.DeepEqual(result, expect) {
+		t.Fatalf("wanted %v got %v", expect, result)
+	}
+	if _, err := store.Forwards(ctx, s, "a"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("wanted %v got %v", store.ErrNotFound, err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := store.AddForwards(canceled, s, "b", outcomes[:1]); !errors.Is(err, context.Canceled) {
+		t.Fatalf("AddForwards: wanted %v got %v", context.Canceled, err)
+	}
+	if _, err := store.Forwards(canceled, s, "b"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Forwards: wanted %v got %v", context.Canceled, err)
+	}
+}
+
+// testQuery checks that stores evaluating queries themselves select the same entries, in the same order,
+// as a scan of every entry
+func testQuery(ctx context.Context, t *testing.T, s store.Store) {
+	querier, ok := s.(store.Querier)
+	if !ok {
+		t.Skip("store does not evaluate queries")
+	}
+	for id, alerts := range map[string][]api.Alert{
+		"labelled": LabelledAlerts(),
+		"plain":    Alerts(),
+		"resolved": LabelledAlerts()[1:],
+		"warning":  {{Status: "firing", Labels: map[string]string{"alertname": "Test", "severity": "warning"}}},
+	} {
+		if err := s.Set(ctx, id, alerts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var queries []store.Query
+	for _, m := range []string{`severity="critical"`, `severity!="critical"`, `alertname=~"Test|Other"`, `alertname!~"T.*"`} {
+		matchers, err := labels.ParseMatchers(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		queries = append(queries, store.Query{Matchers: matchers}, store.Query{Matchers: matchers, Status: "firing"})
+	}
+	startsAt := LabelledAlerts()[1].StartsAt
+	queries = append(queries,
+		store.Query{},
+		store.Query{Status: "resolved"},
+		store.Query{Status: "missing"},
+		store.Query{From: startsAt},
+		store.Query{To: startsAt},
+		store.Query{From: startsAt.Add(-time.Hour), To: startsAt},
+	)
+
+	for _, q := range queries {
+		var expect, got []api.MessageEntry
+		err := s.Iterate(ctx, func(e api.MessageEntry) error {
+			if q.MatchesAny(e.Alerts) {
+				expect = append(expect, e)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = querier.Query(ctx, q, func(e api.MessageEntry) error {
+			got = append(got, e)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("%+v: wanted %v got %v", q, expect, got)
+		}
+	}
+
+	stop := errors.New("stop")
+	if err := querier.Query(ctx, store.Query{}, func(api.MessageEntry) error { return stop }); err != stop {
+		t.Fatalf("wanted %v got %v", stop, err)
+	}
+}
+
+// testInvalidID checks that IDs holding a NUL, which could forge the keys a store keeps for itself such as the
+// forwarding outcomes of another ID, are rejected from every method without writing anything. Only a Namespace
+// rejects the tenant separator, as the keys of its tenants hold it.
+func testInvalidID(ctx context.Context, t *testing.T, s store.Store) {
+	if err := s.Set(ctx, "a", Alerts()); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"\x00forwards\x00a", "a\x00"} {
+		expectInvalidID(ctx, t, s, id)
+	}
+	expectList(ctx, t, s, []api.MessageEntry{{ID: "a", Alerts: Alerts()}})
+	if _, err := store.Forwards(ctx, s, "a"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("wanted %v got %v", store.ErrNotFound, err)
+	}
+
+	id := "tenant\x1fid"
+	if err := s.Set(ctx, id, Alerts()); err == nil {
+		t.Skip("store accepts the tenant separator")
+	}
+	expectInvalidID(ctx, t, s, id)
+	expectList(ctx, t, s, []api.MessageEntry{{ID: "a", Alerts: Alerts()}})
+}
+
+// expectInvalidID checks that every method of s rejects id
+func expectInvalidID(ctx context.Context, t *testing.T, s store.Store, id string) {
+	t.Helper()
+	if err := s.Set(ctx, id, Alerts()); !errors.Is(err, store.ErrInvalidID) {
+		t.Fatalf("Set %q: wanted %v got %v", id, store.ErrInvalidID, err)
+	}
+	if _, err := s.Get(ctx, id); !errors.Is(err, store.ErrInvalidID) {
+		t.Fatalf("Get %q: wanted %v got %v", id, store.ErrInvalidID, err)
+	}
+	if err := s.Delete(ctx, id); !errors.Is(err, store.ErrInvalidID) {
+		t.Fatalf("Delete %q: wanted %v got %v", id, store.ErrInvalidID, err)
+	}
+	if _, ok := s.(store.ForwardLog); ok {
+		if err := store.AddForwards(ctx, s, id, []api.ForwardOutcome{{Target: "downstream"}}); !errors.Is(err, store.ErrInvalidID) {
+			t.Fatalf("AddForwards %q: wanted %v got %v", id, store.ErrInvalidID, err)
+		}
+		if _, err := store.Forwards(ctx, s, id); !errors.Is(err, store.ErrInvalidID) {
+			t.Fatalf("Forwards %q: wanted %v got %v", id, store.ErrInvalidID, err)
+		}
+	}
+}
+
+// testBackup checks that a full and an incremental backup of a store that writes them restore
+// every entry into an empty store
+func testBackup(ctx context.Context, t *testing.T, open Factory) {
+	s := open(t)
+	defer s.Close()
+	backuper, ok := s.(store.Backuper)
+	if !ok {
+		t.Skip("store does not write backups")
+	}
+	for _, id := range []string{"a", "b"} {
+		if err := s.Set(ctx, id, LabelledAlerts()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var full, incremental bytes.Buffer
+	since, err := backuper.Backup(&full, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "c"} {
+		if err := s.Set(ctx, id, Alerts()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if next, err := backuper.Backup(&incremental, since); err != nil || next <= since {
+		t.Fatalf("expected the incremental backup to advance from %d but got %d, %v", since, next, err)
+	}
+
+	restored := open(t)
+	defer restored.Close()
+	target := restored.(store.Backuper)
+	if empty, err := target.Empty(); err != nil || !empty {
+		t.Fatalf("expected a new store to be empty but got %v, %v", empty, err)
+	}
+	for _, backup := range []*bytes.Buffer{&full, &incremental} {
+		if err := target.Restore(backup); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if empty, err := target.Empty(); err != nil || empty {
+		t.Fatalf("expected the restored store not to be empty but got %v, %v", empty, err)
+	}
+	expectList(ctx, t, restored, []api.MessageEntry{
+		{ID: "a", Alerts: Alerts()},
+		{ID: "b", Alerts: LabelledAlerts()},
+		{ID: "c", Alerts: Alerts()},
+	})
+}
+
+// iterateEntries is more than the number of entries any backend reads in a batch
+const iterateEntries = 600
+
+func testIterate(ctx context.Context, t *testing.T, s store.Store) {
+	var ids []string
+	for i := 0; i < iterateEntries; i++ {
+		id := fmt.Sprintf("id-%d", (i*7919)%iterateEntries)
+		if err := s.Set(ctx, id, Alerts()); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var got []api.MessageEntry
+	err := s.Iterate(ctx, func(e api.MessageEntry) error {
+		got = append(got, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(ids) {
+		t.Fatalf("wanted %d entries got %d", len(ids), len(got))
+	}
+	for i, e := range got {
+		if e.ID != ids[i] || !reflect.DeepEqual(e.Alerts, Alerts()) {
+			t.Fatalf("entry %d: wanted %s with %v got %v", i, ids[i], Alerts(), e)
+		}
+	}
+
+	listed, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(listed, got) {
+		t.Fatalf("expected List to return the iterated entries")
+	}
+}
+
+func testIterateStops(ctx context.Context, t *testing.T, s store.Store) {
+	for _, id := range []string{"a", "b", "c"} {
+		if err := s.Set(ctx, id, Alerts()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stop := errors.New("stop")
+	var seen []string
+	err := s.Iterate(ctx, func(e api.MessageEntry) error {
+		seen = append(seen, e.ID)
+		return stop
+	})
+	if err != stop {
+		t.Fatalf("wanted %v got %v", stop, err)
+	}
+	if !reflect.DeepEqual(seen, []string{"a"}) {
+		t.Fatalf("expected iteration to stop after the first entry but saw %v", seen)
+	}
+}
+
+func testUnicodeIDs(ctx context.Context, t *testing.T, s store.Store) {
+	ids := []string{"アラート_受信者", "🔥_webhook", "ümlaut/with/slashes", `spaces and "quotes"`, "tab\tand\nnewline", "%2F?x=1"}
+	for i, id := range ids {
+		alerts := []api.Alert{{Status: fmt.Sprint(i), Labels: map[string]string{"alertname": id}}}
+		if err := s.Set(ctx, id, alerts); err != nil {
+			t.Fatalf("%q: %v", id, err)
+		}
+	}
+	for i, id := range ids {
+		expectGet(ctx, t, s, id, []api.Alert{{Status: fmt.Sprint(i), Labels: map[string]string{"alertname": id}}})
+	}
+
+	result, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != len(ids) {
+		t.Fatalf("expected %d entries but got %v", len(ids), result)
+	}
+}
+
+func testLargePayload(ctx context.Context, t *testing.T, s store.Store) {
+	// roughly 2MiB of alerts in a single entry
+	alerts := make([]api.Alert, 1000)
+	for i := range alerts {
+		alerts[i] = api.Alert{
+			Status:      "firing",
+			Labels:      map[string]string{"alertname": "Large", "index": fmt.Sprint(i)},
+			Annotations: map[string]string{"description": strings.Repeat("x", 2048)},
+		}
+	}
+	if err := s.Set(ctx, "large", alerts); err != nil {
+		t.Fatal(err)
+	}
+	expectGet(ctx, t, s, "large", alerts)
+}
+
+func testConcurrentWriters(ctx context.Context, t *testing.T, s store.Store) {
+	const writers, writes = 8, 25
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*writes*2)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				// every writer contends on a shared ID as well as writing its own
+				for _, id := range []string{"shared", fmt.Sprintf("writer-%d", w)} {
+					alerts := []api.Alert{{Status: fmt.Sprintf("%d-%d", w, i)}}
+					if err := s.Set(ctx, id, alerts); err != nil {
+						errs <- err
+					}
+				}
+				if _, err := s.Get(ctx, "shared"); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for w := 0; w < writers; w++ {
+		expectGet(ctx, t, s, fmt.Sprintf("writer-%d", w), []api.Alert{{Status: fmt.Sprintf("%d-%d", w, writes-1)}})
+	}
+	shared, err := s.Get(ctx, "shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shared) != 1 || !strings.HasSuffix(shared[0].Status, fmt.Sprintf("-%d", writes-1)) {
+		t.Fatalf("expected the shared entry to hold one writer's final alerts but got %v", shared)
+	}
+
+	result, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != writers+1 {
+		t.Fatalf("expected %d entries but got %d", writers+1, len(result))
+	}
+}
+
+// testCanceledContext checks that every method returns the error of a done context
+func testCanceledContext(ctx context.Context, t *testing.T, s store.Store) {
+	if err := s.Set(ctx, "any", Alerts()); err != nil {
+		t.Fatal(err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if err := s.Set(canceled, "other", Alerts()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Set: wanted %v got %v", context.Canceled, err)
+	}
+	if _, err := s.Get(canceled, "any"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Get: wanted %v got %v", context.Canceled, err)
+	}
+	if _, err := s.List(canceled); !errors.Is(err, context.Canceled) {
+		t.Fatalf("List: wanted %v got %v", context.Canceled, err)
+	}
+	if err := s.Delete(canceled, "any"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Delete: wanted %v got %v", context.Canceled, err)
+	}
+	err := s.Iterate(canceled, func(api.MessageEntry) error { return nil })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Iterate: wanted %v got %v", context.Canceled, err)
+	}
+
+	// nothing was changed by the canceled calls
+	expectList(ctx, t, s, []api.MessageEntry{{ID: "any", Alerts: Alerts()}})
+}
+
+func expectGet(ctx context.Context, t *testing.T, s store.Store, id string, expect []api.Alert) {
+	t.Helper()
+	result, err := s.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("%q: expected retrieve to succeed but got %v", id, err)
+	}
+	if !reflect.DeepEqual(result, expect) {
+		t.Fatalf("%q: wanted %v got %v", id, expect, result)
+	}
+}
+
+func expectList(ctx context.Context, t *testing.T, s store.Store, expect []api.MessageEntry) {
+	t.Helper()
+	result, err := s.List(ctx)
+	if err != nil {
+		t.Fatalf("expected list to succeed but got %v", err)
+	}
+	if !reflect.DeepEqual(result, expect) {
+		t.Fatalf("wanted %v got %v", expect, result)
+	}
+}
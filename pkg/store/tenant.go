@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"strings"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+// tenantSeparator delimits the tenant at the start of a key. IDs holding it are rejected by every
+// tenant, including the default one, so that no ID can address the keys of another tenant.
+const tenantSeparator = "\x1f"
+
+// internalPrefix starts the keys stores keep for themselves, such as the forwarding outcomes of an ID.
+// IDs holding it are rejected by every store so that no ID can forge one of those keys.
+const internalPrefix = "\x00"
+
+// ErrInvalidID is returned for an ID that holds the tenant separator or a NUL
+const ErrInvalidID = Error("id contains a reserved character")
+
+// TenantKey returns the key id is saved under for tenant. The default tenant "" uses id unchanged.
+// Callers must check the id with ValidID first.
+func TenantKey(tenant, id string) string {
+	if tenant == "" {
+		return id
+	}
+	return tenantSeparator + tenant + tenantSeparator + id
+}
+
+// ValidID reports whether id can be saved under a tenant
+func ValidID(id string) bool {
+	return !strings.ContainsAny(id, tenantSeparator+internalPrefix)
+}
+
+// validKey reports whether a store can save key. Unlike an ID, a key may hold the tenant separator.
+func validKey(key string) bool {
+	return !strings.Contains(key, internalPrefix)
+}
+
+// Namespace returns a view of s holding only the entries of tenant, so that tenants sharing a store
+// cannot read or overwrite each other's history. Closing the view does not close s.
+func Namespace(s Store, tenant string) Store {
+	return &namespace{store: s, tenant: tenant, prefix: TenantKey(tenant, "")}
+}
+
+type namespace struct {
+	store  Store
+	tenant string
+	prefix string
+}
+
+func (n *namespace) Get(ctx context.Context, id string) ([]api.Alert, error) {
+	if !ValidID(id) {
+		return nil, ErrInvalidID
+	}
+	return n.store.Get(ctx, TenantKey(n.tenant, id))
+}
+
+func (n *namespace) Set(ctx context.Context, id string, alerts []api.Alert) error {
+	if !ValidID(id) {
+		return ErrInvalidID
+	}
+	return n.store.Set(ctx, TenantKey(n.tenant, id), alerts)
+}
+
+func (n *namespace) Delete(ctx context.Context, id string) error {
+	if !ValidID(id) {
+		return ErrInvalidID
+	}
+	return n.store.Delete(ctx, TenantKey(n.tenant, id))
+}
+
+func (n *namespace) List(ctx context.Context) ([]api.MessageEntry, error) {
+	return collect(ctx, n.Iterate)
+}
+
+func (n *namespace) Iterate(ctx context.Context, fn func(api.MessageEntry) error) error {
+	return n.store.Iterate(ctx, func(e api.MessageEntry) error {
+		if e, ok := n.own(e); ok {
+			return fn(e)
+		}
+		return nil
+	})
+}
+
+// Query lets the underlying store evaluate q when it is a Querier
+func (n *namespace) Query(ctx context.Context, q Query, fn func(api.MessageEntry) error) error {
+	return SelectEach(ctx, n.store, q, func(e api.MessageEntry) error {
+		if e, ok := n.own(e); ok {
+			return fn(e)
+		}
+		return nil
+	})
+}
+
+// AddForwards appends outcomes to those of id when the underlying store persists them
+func (n *namespace) AddForwards(ctx context.Context, id string, outcomes []api.ForwardOutcome) error {
+	if !ValidID(id) {
+		return ErrInvalidID
+	}
+	return AddForwards(ctx, n.store, TenantKey(n.tenant, id), outcomes)
+}
+
+func (n *namespace) Forwards(ctx context.Context, id string) ([]api.ForwardOutcome, error) {
+	if !ValidID(id) {
+		return nil, ErrInvalidID
+	}
+	return Forwards(ctx, n.store, TenantKey(n.tenant, id))
+}
+
+// Close is a no-op as the underlying store is shared
+func (n *namespace) Close() error {
+	return nil
+}
+
+// own reports whether e belongs to the tenant and returns it with the tenant removed from its ID.
+// The order of entries is kept as every key of a tenant shares its prefix.
+func (n *namespace) own(e api.MessageEntry) (api.MessageEntry, bool) {
+	if n.tenant == "" {
+		return e, !strings.HasPrefix(e.ID, tenantSeparator)
+	}
+	if !strings.HasPrefix(e.ID, n.prefix) {
+		return e, false
+	}
+	e.ID = strings.TrimPrefix(e.ID, n.prefix)
+	return e, true
+}
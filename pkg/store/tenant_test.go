@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/labels"
+)
+
+func TestNamespace(t *testing.T) {
+	ctx := context.Background()
+	shared := NewInMemStore()
+	views := map[string]Store{
+		"":       Namespace(shared, ""),
+		"team-a": Namespace(shared, "team-a"),
+		"team-b": Namespace(shared, "team-b"),
+	}
+	for tenant, s := range views {
+		alerts := []api.Alert{{Status: "firing", Labels: map[string]string{"tenant": tenant}}}
+		for _, id := range []string{"same", tenant + "-only"} {
+			if err := s.Set(ctx, id, alerts); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for tenant, s := range views {
+		alerts := []api.Alert{{Status: "firing", Labels: map[string]string{"tenant": tenant}}}
+		result, err := s.Get(ctx, "same")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(result, alerts) {
+			t.Fatalf("%q: wanted %v got %v", tenant, alerts, result)
+		}
+
+		expect := []api.MessageEntry{{ID: tenant + "-only", Alerts: alerts}, {ID: "same", Alerts: alerts}}
+		sort.Slice(expect, func(i, j int) bool { return expect[i].ID < expect[j].ID })
+		entries, err := s.List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(entries, expect) {
+			t.Fatalf("%q: wanted %v got %v", tenant, expect, entries)
+		}
+
+		matchers, err := labels.ParseMatchers(`tenant="` + tenant + `"`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		selected, err := Select(ctx, s, Query{Matchers: matchers})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(selected, expect) {
+			t.Fatalf("%q: wanted %v got %v", tenant, expect, selected)
+		}
+	}
+
+	if _, err := views["team-a"].Get(ctx, "team-b-only"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("wanted %v got %v", ErrNotFound, err)
+	}
+	for tenant, s := range views {
+		if _, err := s.Get(ctx, TenantKey("team-b", "same")); !errors.Is(err, ErrInvalidID) {
+			t.Fatalf("%q: wanted %v got %v", tenant, ErrInvalidID, err)
+		}
+		if err := s.Set(ctx, TenantKey("team-b", "same"), nil); !errors.Is(err, ErrInvalidID) {
+			t.Fatalf("%q: wanted %v got %v", tenant, ErrInvalidID, err)
+		}
+	}
+	if err := views["team-a"].Delete(ctx, "same"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tenant := range []string{"", "team-b"} {
+		if _, err := views[tenant].Get(ctx, "same"); err != nil {
+			t.Fatalf("%q: expected deleting from another tenant to leave the entry but got %v", tenant, err)
+		}
+	}
+}
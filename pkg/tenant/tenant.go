@@ -0,0 +1,248 @@
+// Package tenant scopes the history kept by the receiver to the tenants sharing a deployment.
+package tenant
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"gopkg.in/yaml.v2"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+)
+
+// Header names the tenant of a request that is not sent to a /t/{tenant} path
+const Header = "X-Scope-OrgID"
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// ValidName reports whether name may be used as a tenant
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// Config is the tenants configuration file
+type Config struct {
+	Tenants []Tenant `yaml:"tenants"`
+}
+
+// Tenant configures a single tenant. Tenants that are not configured are served without auth or retention.
+type Tenant struct {
+	Name string `yaml:"name"`
+	// Token is the bearer token required on every request for the tenant. Empty accepts any request.
+	Token string `yaml:"token"`
+	// Retention is how long the history of an ID is kept after its last notification. Zero keeps it forever.
+	Retention time.Duration `yaml:"retention"`
+}
+
+// Parse parses and validates a tenants configuration
+func Parse(b []byte) (*Config, error) {
+	var cfg Config
+	if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse tenants config: %w", err)
+	}
+
+	names := map[string]bool{}
+	for i, t := range cfg.Tenants {
+		if !ValidName(t.Name) {
+			return nil, fmt.Errorf("tenant %d has invalid name %q", i, t.Name)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("duplicate tenant name %q", t.Name)
+		}
+		names[t.Name] = true
+		if t.Retention < 0 {
+			return nil, fmt.Errorf("tenant %q has negative retention", t.Name)
+		}
+	}
+	return &cfg, nil
+}
+
+// LoadFile parses the tenants configuration at path
+func LoadFile(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Registry authorizes requests for each tenant and expires history past the tenant's retention.
+// It is safe for concurrent use.
+type Registry struct {
+	tenants map[string]Tenant
+
+	mu sync.Mutex
+	// written holds the time of the last notification saved under each ID of a tenant with retention
+	written map[string]map[string]time.Time
+	// saving is held for reading while a notification is saved and its time recorded, and for writing while
+	// an expired ID is deleted, so that a notification saved as its ID expires is not deleted with it
+	saving sync.RWMutex
+}
+
+// NewRegistry returns a Registry for the tenants in cfg, which may be nil
+func NewRegistry(cfg *Config) *Registry {
+	r := &Registry{
+		tenants: map[string]Tenant{},
+		written: map[string]map[string]time.Time{},
+	}
+	if cfg != nil {
+		for _, t := range cfg.Tenants {
+			r.tenants[t.Name] = t
+		}
+	}
+	return r
+}
+
+// Authorize reports whether token grants access to tenant
+func (r *Registry) Authorize(tenant, token string) bool {
+	t, ok := r.tenants[tenant]
+	if !ok || t.Token == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1
+}
+
+// Authenticated reports whether any tenant requires a token
+func (r *Registry) Authenticated() bool {
+	for _, t := range r.tenants {
+		if t.Token != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Retains reports whether any tenant has a retention, so that Run has work to do
+func (r *Registry) Retains() bool {
+	for _, t := range r.tenants {
+		if t.Retention > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Save saves alerts under id for tenant in s, recording that they were received at t
+func (r *Registry) Save(ctx context.Context, s store.Store, tenant, id string, alerts []api.Alert, t time.Time) error {
+	view := store.Namespace(s, tenant)
+	if r.tenants[tenant].Retention <= 0 {
+		return view.Set(ctx, id, alerts)
+	}
+	r.saving.RLock()
+	defer r.saving.RUnlock()
+	if err := view.Set(ctx, id, alerts); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.written[tenant] == nil {
+		r.written[tenant] = map[string]time.Time{}
+	}
+	r.written[tenant][id] = t
+	return nil
+}
+
+// Reset deletes the history of every ID of tenant from s
+func (r *Registry) Reset(ctx context.Context, s store.Store, tenant string) (int, error) {
+	view := store.Namespace(s, tenant)
+	var n int
+	err := view.Iterate(ctx, func(e api.MessageEntry) error {
+		if err := view.Delete(ctx, e.ID); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+
+	r.mu.Lock()
+	delete(r.written, tenant)
+	r.mu.Unlock()
+	return n, nil
+}
+
+// Expire deletes from s the IDs of each tenant whose last notification is older than the tenant's retention.
+// IDs saved before the receiver started are treated as written at the first call to Expire.
+func (r *Registry) Expire(ctx context.Context, s store.Store, now time.Time) (int, error) {
+	var expired int
+	for name, t := range r.tenants {
+		if t.Retention <= 0 {
+			continue
+		}
+		view := store.Namespace(s, name)
+		err := view.Iterate(ctx, func(e api.MessageEntry) error {
+			r.mu.Lock()
+			if r.written[name] == nil {
+				r.written[name] = map[string]time.Time{}
+			}
+			last, ok := r.written[name][e.ID]
+			if !ok {
+				r.written[name][e.ID] = now
+			}
+			r.mu.Unlock()
+			if !ok || now.Sub(last) < t.Retention {
+				return nil
+			}
+
+			deleted, err := r.deleteExpired(ctx, view, name, e.ID, last)
+			if deleted {
+				expired++
+			}
+			return err
+		})
+		if err != nil {
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+// deleteExpired deletes id unless a notification was saved under it since it was last written at last
+func (r *Registry) deleteExpired(ctx context.Context, view store.Store, tenant, id string, last time.Time) (bool, error) {
+	r.saving.Lock()
+	defer r.saving.Unlock()
+	r.mu.Lock()
+	current := r.written[tenant][id]
+	r.mu.Unlock()
+	if !current.Equal(last) {
+		return false, nil
+	}
+	if err := view.Delete(ctx, id); err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	delete(r.written[tenant], id)
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Run expires history from s every interval until ctx is done
+func (r *Registry) Run(ctx context.Context, s store.Store, interval time.Duration, logger log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := r.Expire(ctx, s, now)
+			if err != nil {
+				level.Error(logger).Log("msg", "failed to expire tenant history", "err", err)
+				continue
+			}
+			if n > 0 {
+				level.Debug(logger).Log("msg", "expired tenant history", "entries", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/store"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(`
+tenants:
+  - name: team-a
+    token: secret
+    retention: 24h
+  - name: team-b
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Tenants) != 2 || cfg.Tenants[0].Retention != 24*time.Hour || cfg.Tenants[0].Token != "secret" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+
+	for _, invalid := range []string{
+		"tenants:\n  - name: ''",
+		"tenants:\n  - name: team/a",
+		"tenants:\n  - name: a\n  - name: a",
+		"tenants:\n  - name: a\n    retention: -1h",
+		"tenants:\n  - name: a\n    unknown: true",
+	} {
+		if _, err := Parse([]byte(invalid)); err == nil {
+			t.Fatalf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	r := NewRegistry(&Config{Tenants: []Tenant{{Name: "secured", Token: "secret"}, {Name: "open"}}})
+	for _, tc := range []struct {
+		tenant, token string
+		expect        bool
+	}{
+		{"secured", "secret", true},
+		{"secured", "wrong", false},
+		{"secured", "", false},
+		{"open", "", true},
+		{"unconfigured", "anything", true},
+	} {
+		if got := r.Authorize(tc.tenant, tc.token); got != tc.expect {
+			t.Fatalf("%s/%s: wanted %v got %v", tc.tenant, tc.token, tc.expect, got)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewInMemStore()
+	for _, tenant := range []string{"", "team-a", "team-b"} {
+		for _, id := range []string{"one", "two"} {
+			if err := store.Namespace(s, tenant).Set(ctx, id, []api.Alert{{Status: "firing"}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	n, err := NewRegistry(nil).Reset(ctx, s, "team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("wanted 2 got %d", n)
+	}
+	for tenant, expect := range map[string]int{"": 2, "team-a": 0, "team-b": 2} {
+		entries, err := store.Namespace(s, tenant).List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != expect {
+			t.Fatalf("%q: wanted %d got %d", tenant, expect, len(entries))
+		}
+	}
+}
+
+func TestExpire(t *testing.T) {
+	ctx := context.Background()
+	r := NewRegistry(&Config{Tenants: []Tenant{{Name: "short", Retention: time.Hour}, {Name: "forever"}}})
+	s := store.NewInMemStore()
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// existing is saved before the registry knows of it, as after a restart
+	for _, tenant := range []string{"short", "forever"} {
+		if err := store.Namespace(s, tenant).Set(ctx, "existing", []api.Alert{{Status: "firing"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := r.Expire(ctx, s, start); err != nil || n != 0 {
+		t.Fatalf("expected nothing to expire but got %d, %v", n, err)
+	}
+
+	for id, at := range map[string]time.Time{"old": start, "recent": start.Add(90 * time.Minute)} {
+		if err := r.Save(ctx, s, "short", id, []api.Alert{{Status: "firing"}}, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := r.Expire(ctx, s, start.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("wanted 2 got %d", n)
+	}
+	for _, id := range []string{"existing", "old"} {
+		if _, err := store.Namespace(s, "short").Get(ctx, id); !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("%s: wanted %v got %v", id, store.ErrNotFound, err)
+		}
+	}
+	if _, err := store.Namespace(s, "short").Get(ctx, "recent"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Namespace(s, "forever").Get(ctx, "existing"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// savingStore saves a notification for each entry as it is read, as a request arriving during expiry would
+type savingStore struct {
+	store.Store
+	save func(id string)
+}
+
+func (s savingStore) Iterate(ctx context.Context, fn func(api.MessageEntry) error) error {
+	return s.Store.Iterate(ctx, func(e api.MessageEntry) error {
+		s.save(e.ID)
+		return fn(e)
+	})
+}
+
+func TestRegistry_ExpireKeepsSavedDuringExpiry(t *testing.T) {
+	ctx := context.Background()
+	r := NewRegistry(&Config{Tenants: []Tenant{{Name: "short", Retention: time.Hour}}})
+	s := store.NewInMemStore()
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := r.Save(ctx, s, "short", "old", []api.Alert{{Status: "firing"}}, start); err != nil {
+		t.Fatal(err)
+	}
+
+	later := start.Add(2 * time.Hour)
+	saving := savingStore{Store: s, save: func(id string) {
+		if err := r.Save(ctx, s, "short", strings.TrimPrefix(id, store.TenantKey("short", "")), []api.Alert{{Status: "resolved"}}, later); err != nil {
+			t.Fatal(err)
+		}
+	}}
+	n, err := r.Expire(ctx, saving, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("wanted 0 got %d", n)
+	}
+	alerts, err := store.Namespace(s, "short").Get(ctx, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alerts[0].Status != "resolved" {
+		t.Fatalf("expected the notification saved during expiry to be kept but got %v", alerts)
+	}
+}
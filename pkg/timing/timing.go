@@ -0,0 +1,400 @@
+// Package timing analyses the observed notification timing of Alertmanager groups so that
+// the group_wait, group_interval and repeat_interval route timers can be verified.
+package timing
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/lifecycle"
+)
+
+const (
+	// maxGroups bounds the number of groups tracked, forgetting the least recently notified first
+	maxGroups = 10000
+	// maxObservations bounds the observations kept for each group, dropping the oldest first
+	maxObservations = 1000
+)
+
+// Timer names a route timer
+type Timer string
+
+const (
+	GroupWait      Timer = "group_wait"
+	GroupInterval  Timer = "group_interval"
+	RepeatInterval Timer = "repeat_interval"
+)
+
+// Expectations are the configured route timers that observed timings are asserted against.
+// A zero duration disables the assertion for that timer.
+type Expectations struct {
+	GroupWait      time.Duration
+	GroupInterval  time.Duration
+	RepeatInterval time.Duration
+	// Tolerance is the allowed difference between an observed and expected duration
+	Tolerance time.Duration
+}
+
+// expected returns the duration o should have taken. Alertmanager only flushes a group on a group_interval
+// tick, so a change waits for the nearest multiple of group_interval and a repeat for the first tick at or
+// after repeat_interval.
+func (e Expectations) expected(o observation) time.Duration {
+	switch o.timer {
+	case GroupWait:
+		return e.GroupWait
+	case GroupInterval:
+		if e.GroupInterval <= 0 {
+			return 0
+		}
+		ticks := (o.d + e.GroupInterval/2) / e.GroupInterval
+		if ticks < 1 {
+			ticks = 1
+		}
+		return ticks * e.GroupInterval
+	case RepeatInterval:
+		if e.RepeatInterval <= 0 || e.GroupInterval <= 0 {
+			return e.RepeatInterval
+		}
+		return (e.RepeatInterval + e.GroupInterval - 1) / e.GroupInterval * e.GroupInterval
+	}
+	return 0
+}
+
+// Summary describes a set of observed durations
+type Summary struct {
+	Count int      `json:"count"`
+	Min   Duration `json:"min"`
+	Max   Duration `json:"max"`
+	Mean  Duration `json:"mean"`
+}
+
+// Duration is a time.Duration that is encoded to JSON as a string such as "1m30s"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + time.Duration(d).String() + `"`), nil
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	parsed, err := time.ParseDuration(strings.Trim(string(b), `"`))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Violation is an observed duration outside the tolerance of the expected timer
+type Violation struct {
+	GroupKey   string    `json:"groupKey"`
+	Timer      Timer     `json:"timer"`
+	Observed   Duration  `json:"observed"`
+	Expected   Duration  `json:"expected"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: observed %s %s but expected %s", v.GroupKey, v.Timer, time.Duration(v.Observed), time.Duration(v.Expected))
+}
+
+// Report summarises the timing of a group or of every group of a route
+type Report struct {
+	GroupKey string `json:"groupKey,omitempty"`
+	Route    string `json:"route"`
+	Receiver string `json:"receiver"`
+	// Notifications is the number of notifications received
+	Notifications int `json:"notifications"`
+	// GroupWait is the delay from StartsAt to the first notification for alerts in the first notification of a group
+	GroupWait Summary `json:"groupWait"`
+	// FirstNotificationDelay is the delay from StartsAt to the first notification for every alert
+	FirstNotificationDelay Summary `json:"firstNotificationDelay"`
+	// GroupInterval are the gaps between successive notifications whose alerts changed
+	GroupInterval Summary `json:"groupInterval"`
+	// RepeatInterval are the gaps between successive notifications whose alerts did not change
+	RepeatInterval Summary     `json:"repeatInterval"`
+	Violations     []Violation `json:"violations,omitempty"`
+}
+
+type observation struct {
+	timer      Timer
+	d          time.Duration
+	receivedAt time.Time
+}
+
+type group struct {
+	tenant    string
+	key       string
+	route     string
+	receiver  string
+	count     int
+	lastAt    time.Time
+	lastState string
+	// notified holds the alerts of the last notification. An alert missing from it has left the group, so it
+	// is measured again if it returns.
+	notified     map[string]bool
+	observations []observation
+}
+
+// groupID identifies a group of a tenant
+type groupID struct {
+	tenant string
+	key    string
+}
+
+// Analyzer observes notifications and computes timing reports, separately for each tenant.
+// It is safe for concurrent use.
+type Analyzer struct {
+	mu        sync.Mutex
+	expect    Expectations
+	retention time.Duration
+	groups    map[groupID]*list.Element
+	// order holds the groups from the least to the most recently notified
+	order *list.List
+}
+
+// NewAnalyzer returns an Analyzer asserting observations against expect. A group is forgotten when it is
+// not notified for retention, or never if retention is zero.
+func NewAnalyzer(expect Expectations, retention time.Duration) *Analyzer {
+	return &Analyzer{expect: expect, retention: retention, groups: make(map[groupID]*list.Element), order: list.New()}
+}
+
+// Observe records a notification for tenant received at the provided time.
+// Messages without a GroupKey cannot be attributed to a group and are ignored.
+func (a *Analyzer) Observe(tenant string, msg api.Message, receivedAt time.Time) {
+	if msg.GroupKey == "" {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expire(receivedAt)
+	id := groupID{tenant: tenant, key: msg.GroupKey}
+	var g *group
+	if el, ok := a.groups[id]; ok {
+		g = el.Value.(*group)
+		a.order.MoveToBack(el)
+	} else {
+		g = &group{
+			tenant:   tenant,
+			key:      msg.GroupKey,
+			route:    RouteKey(msg.GroupKey),
+			receiver: msg.Receiver,
+		}
+		a.groups[id] = a.order.PushBack(g)
+		if a.order.Len() > maxGroups {
+			a.forget(a.order.Front())
+		}
+	}
+
+	state := alertState(msg.Alerts)
+	notified := make(map[string]bool, len(msg.Alerts))
+	for _, alert := range msg.Alerts {
+		fp := lifecycle.Fingerprint(alert)
+		notified[fp] = true
+		if g.notified[fp] || alert.StartsAt.IsZero() {
+			continue
+		}
+		timer := Timer("")
+		if g.count == 0 {
+			timer = GroupWait
+		}
+		g.observe(observation{timer: timer, d: receivedAt.Sub(alert.StartsAt), receivedAt: receivedAt})
+	}
+	g.notified = notified
+
+	if g.count > 0 {
+		timer := GroupInterval
+		if state == g.lastState {
+			timer = RepeatInterval
+		}
+		g.observe(observation{timer: timer, d: receivedAt.Sub(g.lastAt), receivedAt: receivedAt})
+	}
+	g.count++
+	g.lastAt, g.lastState = receivedAt, state
+}
+
+func (g *group) observe(o observation) {
+	g.observations = append(g.observations, o)
+	if len(g.observations) > maxObservations {
+		g.observations = g.observations[len(g.observations)-maxObservations:]
+	}
+}
+
+// expire forgets the groups not notified for the retention. Groups are kept in the order they were last
+// notified, so only the expired ones are visited.
+func (a *Analyzer) expire(now time.Time) {
+	if a.retention <= 0 {
+		return
+	}
+	for el := a.order.Front(); el != nil && now.Sub(el.Value.(*group).lastAt) > a.retention; el = a.order.Front() {
+		a.forget(el)
+	}
+}
+
+// Reset forgets the groups of tenant
+func (a *Analyzer) Reset(tenant string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for el := a.order.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*group).tenant == tenant {
+			a.forget(el)
+		}
+		el = next
+	}
+}
+
+func (a *Analyzer) forget(el *list.Element) {
+	g := a.order.Remove(el).(*group)
+	delete(a.groups, groupID{tenant: g.tenant, key: g.key})
+}
+
+// alertState identifies the set of alerts and their status in a notification
+func alertState(alerts []api.Alert) string {
+	state := make([]string, 0, len(alerts))
+	for _, a := range alerts {
+		state = append(state, lifecycle.Fingerprint(a)+"="+a.Status)
+	}
+	sort.Strings(state)
+	return strings.Join(state, ",")
+}
+
+// Groups returns a report for each group of tenant sorted by group key
+func (a *Analyzer) Groups(tenant string) []Report {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	reports := []Report{}
+	for el := a.order.Front(); el != nil; el = el.Next() {
+		g := el.Value.(*group)
+		if g.tenant != tenant {
+			continue
+		}
+		reports = append(reports, a.report(Report{GroupKey: g.key, Route: g.route, Receiver: g.receiver}, g))
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].GroupKey < reports[j].GroupKey })
+	return reports
+}
+
+// Routes returns a report for each route and receiver of tenant combining all of its groups
+func (a *Analyzer) Routes(tenant string) []Report {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	byRoute := make(map[string][]*group)
+	for el := a.order.Front(); el != nil; el = el.Next() {
+		g := el.Value.(*group)
+		if g.tenant != tenant {
+			continue
+		}
+		k := g.route + "\x00" + g.receiver
+		byRoute[k] = append(byRoute[k], g)
+	}
+
+	reports := make([]Report, 0, len(byRoute))
+	for _, groups := range byRoute {
+		sort.Slice(groups, func(i, j int) bool { return groups[i].key < groups[j].key })
+		reports = append(reports, a.report(Report{Route: groups[0].route, Receiver: groups[0].receiver}, groups...))
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Route != reports[j].Route {
+			return reports[i].Route < reports[j].Route
+		}
+		return reports[i].Receiver < reports[j].Receiver
+	})
+	return reports
+}
+
+// Violations returns every observation of tenant outside the tolerance of its expected timer
+func (a *Analyzer) Violations(tenant string) []Violation {
+	var violations []Violation
+	for _, r := range a.Groups(tenant) {
+		violations = append(violations, r.Violations...)
+	}
+	return violations
+}
+
+func (a *Analyzer) report(r Report, groups ...*group) Report {
+	var groupWait, firstDelay, interval, repeat []time.Duration
+	for _, g := range groups {
+		r.Notifications += g.count
+		for _, o := range g.observations {
+			switch o.timer {
+			case GroupWait:
+				groupWait = append(groupWait, o.d)
+				firstDelay = append(firstDelay, o.d)
+			case GroupInterval:
+				interval = append(interval, o.d)
+			case RepeatInterval:
+				repeat = append(repeat, o.d)
+			default:
+				firstDelay = append(firstDelay, o.d)
+			}
+			if v, ok := a.check(g.key, o); ok {
+				r.Violations = append(r.Violations, v)
+			}
+		}
+	}
+
+	r.GroupWait = summarize(groupWait)
+	r.FirstNotificationDelay = summarize(firstDelay)
+	r.GroupInterval = summarize(interval)
+	r.RepeatInterval = summarize(repeat)
+	return r
+}
+
+func (a *Analyzer) check(groupKey string, o observation) (Violation, bool) {
+	expected := a.expect.expected(o)
+	if expected == 0 {
+		return Violation{}, false
+	}
+	diff := o.d - expected
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff <= a.expect.Tolerance {
+		return Violation{}, false
+	}
+	return Violation{
+		GroupKey:   groupKey,
+		Timer:      o.timer,
+		Observed:   Duration(o.d),
+		Expected:   Duration(expected),
+		ReceivedAt: o.receivedAt,
+	}, true
+}
+
+func summarize(ds []time.Duration) Summary {
+	if len(ds) == 0 {
+		return Summary{}
+	}
+	s := Summary{Count: len(ds), Min: Duration(ds[0]), Max: Duration(ds[0])}
+	var total time.Duration
+	for _, d := range ds {
+		total += d
+		if Duration(d) < s.Min {
+			s.Min = Duration(d)
+		}
+		if Duration(d) > s.Max {
+			s.Max = Duration(d)
+		}
+	}
+	s.Mean = Duration(total / time.Duration(len(ds)))
+	return s
+}
+
+// RouteKey returns the route part of an Alertmanager group key.
+// Group keys are the route key followed by ":" and the group labels, such as {}/{env="prod"}:{alertname="Test"}.
+func RouteKey(groupKey string) string {
+	if i := strings.LastIndex(groupKey, ":{"); i >= 0 {
+		return groupKey[:i]
+	}
+	return groupKey
+}
@@ -0,0 +1,152 @@
+package timing
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+func TestAnalyzer(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	a1 := api.Alert{Status: "firing", Labels: map[string]string{"alertname": "Test", "instance": "a"}, StartsAt: start}
+	a2 := api.Alert{Status: "firing", Labels: map[string]string{"alertname": "Test", "instance": "b"}, StartsAt: start.Add(time.Minute)}
+	msg := func(alerts ...api.Alert) api.Message {
+		return api.Message{Receiver: "webhook", GroupKey: `{}/{env="prod"}:{alertname="Test"}`, Alerts: alerts}
+	}
+
+	analyzer := NewAnalyzer(Expectations{
+		GroupWait:      30 * time.Second,
+		GroupInterval:  5 * time.Minute,
+		RepeatInterval: time.Hour,
+		Tolerance:      5 * time.Second,
+	}, 0)
+	analyzer.Observe("", msg(a1), start.Add(30*time.Second))
+	analyzer.Observe("", msg(a1, a2), start.Add(5*time.Minute+30*time.Second))
+	analyzer.Observe("", msg(a1, a2), start.Add(time.Hour+10*time.Minute+30*time.Second))
+	analyzer.Observe("", api.Message{Receiver: "other", GroupKey: `{}/{env="prod"}:{alertname="Other"}`, Alerts: []api.Alert{a1}}, start.Add(time.Minute))
+	analyzer.Observe("", api.Message{Alerts: []api.Alert{a1}}, start)
+
+	groups := analyzer.Groups("")
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups but got %v", groups)
+	}
+
+	g := groups[1]
+	if g.Route != `{}/{env="prod"}` || g.Notifications != 3 {
+		t.Fatalf("unexpected group %v", g)
+	}
+	if g.GroupWait.Count != 1 || time.Duration(g.GroupWait.Mean) != 30*time.Second {
+		t.Fatalf("unexpected group wait %v", g.GroupWait)
+	}
+	if g.FirstNotificationDelay.Count != 2 || time.Duration(g.FirstNotificationDelay.Max) != 4*time.Minute+30*time.Second {
+		t.Fatalf("unexpected first notification delay %v", g.FirstNotificationDelay)
+	}
+	if g.GroupInterval.Count != 1 || time.Duration(g.GroupInterval.Min) != 5*time.Minute {
+		t.Fatalf("unexpected group interval %v", g.GroupInterval)
+	}
+	if g.RepeatInterval.Count != 1 || time.Duration(g.RepeatInterval.Min) != time.Hour+5*time.Minute {
+		t.Fatalf("unexpected repeat interval %v", g.RepeatInterval)
+	}
+
+	violations := analyzer.Violations("")
+	if len(violations) != 2 {
+		t.Fatalf("expected repeat interval and other group wait violations but got %v", violations)
+	}
+	if violations[1].Timer != RepeatInterval || time.Duration(violations[1].Observed) != time.Hour+5*time.Minute {
+		t.Fatalf("unexpected violation %v", violations[1])
+	}
+
+	routes := analyzer.Routes("")
+	if len(routes) != 2 || routes[0].Receiver != "other" || routes[1].Notifications != 3 {
+		t.Fatalf("unexpected routes %v", routes)
+	}
+	if groups := analyzer.Groups("team-a"); len(groups) != 0 {
+		t.Fatalf("expected another tenant to have no groups but got %v", groups)
+	}
+
+	analyzer.Reset("team-a")
+	if groups := analyzer.Groups(""); len(groups) == 0 {
+		t.Fatal("expected resetting another tenant to keep the groups")
+	}
+	analyzer.Reset("")
+	if groups, violations := analyzer.Groups(""), analyzer.Violations(""); len(groups) != 0 || len(violations) != 0 {
+		t.Fatalf("expected reset groups to be forgotten but got %v and %v", groups, violations)
+	}
+}
+
+func TestAnalyzer_Forgets(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	alert := api.Alert{Status: "firing", Labels: map[string]string{"alertname": "Test"}, StartsAt: start}
+	msg := func(groupKey string, alerts ...api.Alert) api.Message {
+		return api.Message{Receiver: "webhook", GroupKey: groupKey, Alerts: alerts}
+	}
+
+	analyzer := NewAnalyzer(Expectations{}, time.Hour)
+	for i := 0; i <= maxGroups; i++ {
+		analyzer.Observe("", msg(fmt.Sprint(i)), start)
+	}
+	if groups := analyzer.Groups(""); len(groups) != maxGroups || groups[0].GroupKey != "1" {
+		t.Fatalf("expected the least recently notified group to be forgotten over the limit but got %d groups", len(groups))
+	}
+
+	// an alert that left the group is measured again when it returns
+	analyzer.Observe("", msg("returning", alert), start.Add(2*time.Hour))
+	analyzer.Observe("", msg("returning"), start.Add(2*time.Hour+time.Minute))
+	analyzer.Observe("", msg("returning", alert), start.Add(2*time.Hour+2*time.Minute))
+	groups := analyzer.Groups("")
+	if len(groups) != 1 {
+		t.Fatalf("expected groups not notified within the retention to be forgotten but got %d groups", len(groups))
+	}
+	if groups[0].FirstNotificationDelay.Count != 2 {
+		t.Fatalf("unexpected first notification delay %v", groups[0].FirstNotificationDelay)
+	}
+}
+
+func TestAnalyzer_GroupIntervalTicks(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	a1 := api.Alert{Status: "firing", Labels: map[string]string{"alertname": "Test", "instance": "a"}, StartsAt: start}
+	a2 := api.Alert{Status: "firing", Labels: map[string]string{"alertname": "Test", "instance": "b"}, StartsAt: start}
+	msg := func(alerts ...api.Alert) api.Message {
+		return api.Message{Receiver: "webhook", GroupKey: `{}:{alertname="Test"}`, Alerts: alerts}
+	}
+
+	analyzer := NewAnalyzer(Expectations{
+		GroupWait:      30 * time.Second,
+		GroupInterval:  5 * time.Minute,
+		RepeatInterval: 12 * time.Minute,
+		Tolerance:      5 * time.Second,
+	}, 0)
+	at := start.Add(30 * time.Second)
+	analyzer.Observe("", msg(a1), at)
+	// a change is flushed on a later tick when the ticks in between had nothing new
+	at = at.Add(15 * time.Minute)
+	analyzer.Observe("", msg(a1, a2), at)
+	// a repeat is flushed on the first tick after the repeat interval
+	at = at.Add(15 * time.Minute)
+	analyzer.Observe("", msg(a1, a2), at)
+	if violations := analyzer.Violations(""); len(violations) != 0 {
+		t.Fatalf("expected gaps on group interval ticks not to be flagged but got %v", violations)
+	}
+
+	resolved := a2
+	resolved.Status = "resolved"
+	analyzer.Observe("", msg(a1, resolved), at.Add(7*time.Minute+30*time.Second))
+	violations := analyzer.Violations("")
+	if len(violations) != 1 || violations[0].Timer != GroupInterval || time.Duration(violations[0].Expected) != 10*time.Minute {
+		t.Fatalf("expected a gap between ticks to be flagged but got %v", violations)
+	}
+}
+
+func TestRouteKey(t *testing.T) {
+	for groupKey, expect := range map[string]string{
+		`{}:{alertname="Test", job="prometheus24"}`: `{}`,
+		`{}/{env="prod"}:{}`:                        `{}/{env="prod"}`,
+		`custom`:                                    `custom`,
+	} {
+		if got := RouteKey(groupKey); got != expect {
+			t.Fatalf("wanted %s got %s", expect, got)
+		}
+	}
+}
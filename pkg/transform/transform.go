@@ -0,0 +1,250 @@
+// Package transform applies a configurable pipeline of transformations to notifications before they are stored.
+package transform
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+// Supported actions
+const (
+	DropLabels        = "drop_labels"
+	RenameLabel       = "rename_label"
+	AddLabels         = "add_labels"
+	RedactAnnotations = "redact_annotations"
+	Split             = "split"
+)
+
+const defaultReplacement = "[REDACTED]"
+
+// Config is the transformation configuration file
+type Config struct {
+	Transformations []Step `yaml:"transformations"`
+}
+
+// Step is a single transformation
+type Step struct {
+	Action string `yaml:"action"`
+	// Labels are the names removed by drop_labels
+	Labels []string `yaml:"labels"`
+	// Source and Target are the old and new names for rename_label
+	Source string `yaml:"source"`
+	Target string `yaml:"target"`
+	// Values are the labels set by add_labels. Each value is a template executed against the message.
+	Values map[string]string `yaml:"values"`
+	// Regex, Replacement and Annotations configure redact_annotations.
+	// An empty Annotations list redacts every annotation.
+	Regex       string   `yaml:"regex"`
+	Replacement *string  `yaml:"replacement"`
+	Annotations []string `yaml:"annotations"`
+}
+
+// Pipeline runs each configured step in order
+type Pipeline struct {
+	steps   []func(msgs []api.Message) ([]api.Message, error)
+	redacts bool
+}
+
+// Parse parses a transformation configuration into a Pipeline
+func Parse(b []byte) (*Pipeline, error) {
+	var cfg Config
+	if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse transformation config: %w", err)
+	}
+	return New(cfg)
+}
+
+// LoadFile parses the transformation configuration at path
+func LoadFile(path string) (*Pipeline, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// New builds a Pipeline from cfg
+func New(cfg Config) (*Pipeline, error) {
+	p := &Pipeline{}
+	for i, s := range cfg.Transformations {
+		step, err := build(s)
+		if err != nil {
+			return nil, fmt.Errorf("transformation %d: %w", i, err)
+		}
+		p.steps = append(p.steps, step)
+		p.redacts = p.redacts || s.Action == RedactAnnotations
+	}
+	return p, nil
+}
+
+// Redacts reports whether the pipeline redacts annotations
+func (p *Pipeline) Redacts() bool {
+	return p.redacts
+}
+
+// Apply transforms msg, returning more than one message if it was split, in which case each message holds a single alert
+func (p *Pipeline) Apply(msg api.Message) ([]api.Message, error) {
+	msgs := []api.Message{copyMessage(msg)}
+	for _, step := range p.steps {
+		var err error
+		if msgs, err = step(msgs); err != nil {
+			return nil, err
+		}
+	}
+	return msgs, nil
+}
+
+func build(s Step) (func(msgs []api.Message) ([]api.Message, error), error) {
+	switch s.Action {
+	case DropLabels:
+		if len(s.Labels) == 0 {
+			return nil, fmt.Errorf("%s requires labels", s.Action)
+		}
+		return eachLabelSet(func(lset map[string]string) {
+			for _, name := range s.Labels {
+				delete(lset, name)
+			}
+		}), nil
+
+	case RenameLabel:
+		if s.Source == "" || s.Target == "" {
+			return nil, fmt.Errorf("%s requires source and target", s.Action)
+		}
+		return eachLabelSet(func(lset map[string]string) {
+			if v, ok := lset[s.Source]; ok {
+				delete(lset, s.Source)
+				lset[s.Target] = v
+			}
+		}), nil
+
+	case AddLabels:
+		if len(s.Values) == 0 {
+			return nil, fmt.Errorf("%s requires values", s.Action)
+		}
+		tmpls := map[string]*template.Template{}
+		for name, v := range s.Values {
+			t, err := template.New(name).Parse(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid template for label %q: %w", name, err)
+			}
+			tmpls[name] = t
+		}
+		return func(msgs []api.Message) ([]api.Message, error) {
+			for i := range msgs {
+				for name, t := range tmpls {
+					w := bytes.NewBuffer([]byte{})
+					if err := t.Execute(w, msgs[i]); err != nil {
+						return nil, err
+					}
+					for _, a := range msgs[i].Alerts {
+						a.Labels[name] = w.String()
+					}
+					msgs[i].CommonLabels[name] = w.String()
+				}
+			}
+			return msgs, nil
+		}, nil
+
+	case RedactAnnotations:
+		if s.Regex == "" {
+			return nil, fmt.Errorf("%s requires regex", s.Action)
+		}
+		re, err := regexp.Compile(s.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+		replacement := defaultReplacement
+		if s.Replacement != nil {
+			replacement = *s.Replacement
+		}
+		only := map[string]bool{}
+		for _, name := range s.Annotations {
+			only[name] = true
+		}
+		redact := func(annotations map[string]string) {
+			for name, v := range annotations {
+				if len(only) == 0 || only[name] {
+					annotations[name] = re.ReplaceAllString(v, replacement)
+				}
+			}
+		}
+		return func(msgs []api.Message) ([]api.Message, error) {
+			for _, m := range msgs {
+				for _, a := range m.Alerts {
+					redact(a.Annotations)
+				}
+				redact(m.CommonAnnotations)
+			}
+			return msgs, nil
+		}, nil
+
+	case Split:
+		return func(msgs []api.Message) ([]api.Message, error) {
+			var out []api.Message
+			for _, m := range msgs {
+				if len(m.Alerts) <= 1 {
+					out = append(out, m)
+					continue
+				}
+				for _, a := range m.Alerts {
+					split := copyMessage(m)
+					split.Status = a.Status
+					split.Alerts = []api.Alert{copyAlert(a)}
+					split.CommonLabels = copyMap(a.Labels)
+					split.CommonAnnotations = copyMap(a.Annotations)
+					out = append(out, split)
+				}
+			}
+			return out, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown action %q", s.Action)
+}
+
+// eachLabelSet applies fn to the labels of every alert as well as the group and common labels
+func eachLabelSet(fn func(lset map[string]string)) func(msgs []api.Message) ([]api.Message, error) {
+	return func(msgs []api.Message) ([]api.Message, error) {
+		for _, m := range msgs {
+			for _, a := range m.Alerts {
+				fn(a.Labels)
+			}
+			fn(m.GroupLabels)
+			fn(m.CommonLabels)
+		}
+		return msgs, nil
+	}
+}
+
+// copyMessage deep copies msg so that steps can modify label and annotation maps in place
+func copyMessage(msg api.Message) api.Message {
+	out := msg
+	out.Alerts = make([]api.Alert, len(msg.Alerts))
+	for i, a := range msg.Alerts {
+		out.Alerts[i] = copyAlert(a)
+	}
+	out.GroupLabels = copyMap(msg.GroupLabels)
+	out.CommonLabels = copyMap(msg.CommonLabels)
+	out.CommonAnnotations = copyMap(msg.CommonAnnotations)
+	return out
+}
+
+func copyAlert(a api.Alert) api.Alert {
+	a.Labels = copyMap(a.Labels)
+	a.Annotations = copyMap(a.Annotations)
+	return a
+}
+
+func copyMap(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
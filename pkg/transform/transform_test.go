@@ -0,0 +1,147 @@
+package transform
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/philipgough/alertmanager-test-webhook-receiver/pkg/api"
+)
+
+func testMessage() api.Message {
+	return api.Message{
+		Receiver:    "team",
+		Status:      "firing",
+		GroupLabels: map[string]string{"alertname": "Test", "instance": "a"},
+		CommonLabels: map[string]string{
+			"alertname": "Test",
+		},
+		CommonAnnotations: map[string]string{"summary": "customer cust-123 affected"},
+		Alerts: []api.Alert{
+			{
+				Status:      "firing",
+				Labels:      map[string]string{"alertname": "Test", "instance": "a", "pod": "p1"},
+				Annotations: map[string]string{"summary": "customer cust-123 affected", "runbook": "cust-123"},
+			},
+			{
+				Status:      "resolved",
+				Labels:      map[string]string{"alertname": "Test", "instance": "b"},
+				Annotations: map[string]string{"summary": "customer cust-456 affected"},
+			},
+		},
+	}
+}
+
+func TestPipeline_Apply(t *testing.T) {
+	p, err := Parse([]byte(`
+transformations:
+- action: drop_labels
+  labels: [pod]
+- action: rename_label
+  source: instance
+  target: host
+- action: add_labels
+  values:
+    env: ci
+    team: "{{ .Receiver }}"
+- action: redact_annotations
+  regex: cust-[0-9]+
+  annotations: [summary]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := testMessage()
+	got, err := p.Apply(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msg, testMessage()) {
+		t.Fatal("expected the input message not to be modified")
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected a single message but got %v", got)
+	}
+
+	m := got[0]
+	expectLabels := map[string]string{"alertname": "Test", "host": "a", "env": "ci", "team": "team"}
+	if !reflect.DeepEqual(m.Alerts[0].Labels, expectLabels) {
+		t.Fatalf("wanted %v got %v", expectLabels, m.Alerts[0].Labels)
+	}
+	expectGroup := map[string]string{"alertname": "Test", "host": "a"}
+	if !reflect.DeepEqual(m.GroupLabels, expectGroup) {
+		t.Fatalf("wanted %v got %v", expectGroup, m.GroupLabels)
+	}
+	if m.CommonLabels["env"] != "ci" {
+		t.Fatalf("expected static label to be common but got %v", m.CommonLabels)
+	}
+	expectAnnotations := map[string]string{"summary": "customer [REDACTED] affected", "runbook": "cust-123"}
+	if !reflect.DeepEqual(m.Alerts[0].Annotations, expectAnnotations) {
+		t.Fatalf("wanted %v got %v", expectAnnotations, m.Alerts[0].Annotations)
+	}
+	if m.CommonAnnotations["summary"] != "customer [REDACTED] affected" {
+		t.Fatalf("expected common annotations to be redacted but got %v", m.CommonAnnotations)
+	}
+}
+
+func TestPipeline_Split(t *testing.T) {
+	p, err := Parse([]byte(`
+transformations:
+- action: split
+- action: redact_annotations
+  regex: "[0-9]+"
+  replacement: "#"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := p.Apply(testMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected a message per alert but got %v", got)
+	}
+	for i, m := range got {
+		if len(m.Alerts) != 1 || m.Status != m.Alerts[0].Status {
+			t.Fatalf("unexpected message %v", m)
+		}
+		if !reflect.DeepEqual(m.CommonLabels, testMessage().Alerts[i].Labels) {
+			t.Fatalf("wanted %v got %v", testMessage().Alerts[i].Labels, m.CommonLabels)
+		}
+	}
+	if got[1].Status != "resolved" || got[1].Alerts[0].Annotations["summary"] != "customer cust-# affected" {
+		t.Fatalf("unexpected message %v", got[1])
+	}
+}
+
+func TestPipeline_Redacts(t *testing.T) {
+	for config, expect := range map[string]bool{
+		`transformations: [{action: split}]`:                        false,
+		`transformations: [{action: redact_annotations, regex: a}]`: true,
+	} {
+		p, err := Parse([]byte(config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Redacts() != expect {
+			t.Fatalf("%s: wanted %v got %v", config, expect, p.Redacts())
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, invalid := range []string{
+		`transformations: [{action: unknown}]`,
+		`transformations: [{action: drop_labels}]`,
+		`transformations: [{action: rename_label, source: a}]`,
+		`transformations: [{action: add_labels, values: {a: "{{ .Missing"}}]`,
+		`transformations: [{action: redact_annotations, regex: "("}]`,
+		`transformations: [{action: split, unknown: true}]`,
+	} {
+		if _, err := Parse([]byte(invalid)); err == nil {
+			t.Fatalf("expected error parsing %q", invalid)
+		}
+	}
+}